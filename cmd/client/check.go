@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"github.com/JeffersonQin/syncat/internal/client"
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"os"
+)
+
+// runConfig Run the config command, which validates the config files and reports all the problems found,
+// and return the exit code
+func runConfig(args []string) int {
+	if len(args) != 2 || args[1] != "check" {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	path, clientPath, err := loadConfigs()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	valid := config.Report(os.Stdout, path, config.GetConfig().Validate())
+	valid = config.Report(os.Stdout, clientPath, client.GetConfig().Validate()) && valid
+	if !valid {
+		return 1
+	}
+	return 0
+}
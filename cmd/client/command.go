@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"github.com/JeffersonQin/syncat/internal/client"
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"os"
+	"text/tabwriter"
+)
+
+// usage is printed when the subcommand is invalid
+const usage = `usage: client [options] [command]
+
+Without command, the client daemon is started, and reloads the config files on SIGHUP.
+
+options:
+  --config <path>         shared config file, SYNCAT_CONFIG if not given, searched in
+                          $XDG_CONFIG_HOME/syncat, $XDG_CONFIG_DIRS/syncat, and ../config beside the executable
+  --client-config <path>  client config file, SYNCAT_CLIENT_CONFIG if not given,
+                          client_config.yml beside the shared config file, or searched likewise
+
+Settings are overridden by environment variables named after their keys,
+e.g. SYNCAT_AUTH_TOKEN for auth.token, and SYNCAT_CLIENT_HOST for host of the client config.
+
+commands:
+  status          show the status of the daemon and its sync roots
+  sync [root]     sync the sync root, or all the sync roots
+  pause <root>    pause syncing the sync root
+  resume <root>   resume syncing the sync root
+  conflicts       list the unresolved conflicts
+  reload          reload the config files, also done on SIGHUP
+  config check    validate the config files and report all the problems found
+`
+
+// timeFormat is the format of the time printed
+const timeFormat = "2006-01-02 15:04:05"
+
+// runCommand Run the subcommand against the running daemon, and return the exit code
+func runCommand(args []string) int {
+	req := client.ControlRequest{Command: args[0]}
+	switch {
+	case args[0] == client.CommandStatus && len(args) == 1,
+		args[0] == client.CommandConflicts && len(args) == 1,
+		args[0] == client.CommandReload && len(args) == 1:
+	case args[0] == client.CommandSync && len(args) <= 2:
+		if len(args) == 2 {
+			req.Root = args[1]
+		}
+	case (args[0] == client.CommandPause || args[0] == client.CommandResume) && len(args) == 2:
+		req.Root = args[1]
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	socket := client.GetConfig().ControlSocket
+	if socket == "" {
+		fmt.Fprintln(os.Stderr, "control socket is not configured")
+		return 1
+	}
+	resp, err := client.Control(socket, req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	switch req.Command {
+	case client.CommandStatus:
+		printStatus(resp.Status)
+	case client.CommandConflicts:
+		printConflicts(resp.Conflicts)
+	case client.CommandReload:
+		printChanges(resp.Changes)
+	}
+	return 0
+}
+
+// printStatus Print the status of the daemon and its sync roots
+func printStatus(status *client.Status) {
+	state := "disconnected"
+	if status.Connected {
+		state = "connected"
+	}
+	fmt.Printf("server: %s (%s)\n", status.Server, state)
+	fmt.Printf("client: %s\n\n", status.ClientUuid)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ROOT\tSTATE\tLAST SYNC\tUP\tDOWN\tDELETED\tCONFLICTS\tRATIO\tERROR")
+	for _, r := range status.Roots {
+		state := "active"
+		if r.Paused {
+			state = "paused"
+		} else if r.Syncing {
+			state = "syncing"
+		}
+		lastSync := "-"
+		if r.LastSync != nil {
+			lastSync = r.LastSync.Format(timeFormat)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%.2f\t%s\n", r.Root, state, lastSync, r.LastStats.Uploaded,
+			r.LastStats.Downloaded, r.LastStats.Deleted, r.LastStats.Conflicts, r.LastStats.CompressionRatio(),
+			r.LastError)
+	}
+	_ = w.Flush()
+}
+
+// printConflicts Print the unresolved conflicts
+func printConflicts(conflicts []client.ConflictStatus) {
+	if len(conflicts) == 0 {
+		fmt.Println("no conflicts")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ROOT\tPATH\tLOCAL COPY\tDETECTED")
+	for _, c := range conflicts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Root, c.Path, c.ConflictPath, c.Detected.Local().Format(timeFormat))
+	}
+	_ = w.Flush()
+}
+
+// printChanges Print the changes applied by reloading the config files, and the ones taking effect after restarting
+func printChanges(changes *config.Changes) {
+	if changes == nil || (len(changes.Applied) == 0 && len(changes.RestartRequired) == 0) {
+		fmt.Println("no changes")
+		return
+	}
+	for _, change := range changes.Applied {
+		fmt.Printf("applied: %s\n", change)
+	}
+	for _, change := range changes.RestartRequired {
+		fmt.Printf("restart required: %s\n", change)
+	}
+}
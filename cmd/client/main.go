@@ -1,19 +1,154 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/JeffersonQin/syncat/internal/client"
 	"github.com/JeffersonQin/syncat/pkg/config"
+	"github.com/JeffersonQin/syncat/pkg/database"
+	"github.com/JeffersonQin/syncat/pkg/logging"
+	"github.com/JeffersonQin/syncat/pkg/syncnet"
+	"golang.org/x/exp/slog"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-func init() {
-	// Load common config
-	log.Println("Loading config...")
-	err := config.LoadConfig()
+// configPath and clientConfigPath are the paths of the config files given on the command line
+var (
+	configPath       = flag.String("config", "", "path of the shared config file")
+	clientConfigPath = flag.String("client-config", "", "path of the client config file")
+)
+
+// loadConfigs Locate and load the shared and the client config files given by the flags, and return their paths
+func loadConfigs() (string, string, error) {
+	path, err := config.Locate("config.yml", *configPath, "SYNCAT_CONFIG", "")
+	if err != nil {
+		return "", "", err
+	}
+	err = config.LoadConfig(path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load config %s: %w", path, err)
+	}
+	clientPath, err := config.Locate("client_config.yml", *clientConfigPath, "SYNCAT_CLIENT_CONFIG", path)
 	if err != nil {
-		log.Fatalln("failed to load config.", err)
+		return "", "", err
 	}
+	err = client.LoadConfig(clientPath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load client config %s: %w", clientPath, err)
+	}
+	return path, clientPath, nil
+}
+
+// setup Load and validate the configurations from the config files given by the flags,
+// and set up logging
+// The paths of the shared and the client config files are returned, which are read again on reload
+func setup() (string, string) {
+	path, clientPath, err := loadConfigs()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("Loaded config from", path, "and", clientPath)
+	// Refuse to start with any problem in the configurations, reporting all of them
+	sharedErr := config.GetConfig().Validate()
+	clientErr := client.GetConfig().Validate()
+	if sharedErr != nil || clientErr != nil {
+		config.Report(os.Stderr, path, sharedErr)
+		config.Report(os.Stderr, clientPath, clientErr)
+		log.Fatalln("invalid configuration, fix the problems above")
+	}
+	// Log in the configured level and format from now on
+	err = logging.Setup(config.GetConfig().Log)
+	if err != nil {
+		log.Fatalln("failed to set up logging.", err)
+	}
+	return path, clientPath
+}
+
+// readConfigs Read and validate the config files at the paths again, for reloading them
+func readConfigs(path string, clientPath string) (config.SyncatConfig, client.SyncatClientConfig, error) {
+	shared, err := config.ReadConfig(path)
+	if err != nil {
+		return config.SyncatConfig{}, client.SyncatClientConfig{}, fmt.Errorf("failed to load config %s: %w", path, err)
+	}
+	clientConfig, err := client.ReadConfig(clientPath)
+	if err != nil {
+		return config.SyncatConfig{}, client.SyncatClientConfig{},
+			fmt.Errorf("failed to load client config %s: %w", clientPath, err)
+	}
+	err = shared.Validate()
+	if err != nil {
+		return config.SyncatConfig{}, client.SyncatClientConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+	err = clientConfig.Validate()
+	if err != nil {
+		return config.SyncatConfig{}, client.SyncatClientConfig{}, fmt.Errorf("%s: %w", clientPath, err)
+	}
+	return shared, clientConfig, nil
 }
 
 func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Parse()
+
+	// Check the configurations without starting
+	if flag.Arg(0) == "config" {
+		os.Exit(runConfig(flag.Args()))
+	}
+
+	// Run subcommand against the running daemon, which does not need a valid configuration
+	if flag.NArg() > 0 {
+		_, _, err := loadConfigs()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		os.Exit(runCommand(flag.Args()))
+	}
+	path, clientPath := setup()
+
+	// Load database
+	slog.Info("Loading database...")
+	store, err := database.LoadDatabase(config.GetConfig().Db, database.ClientRole)
+	if err != nil {
+		slog.Error("failed to open database.", err)
+		os.Exit(1)
+	}
+	defer func() {
+		err := store.Close()
+		if err != nil {
+			slog.Error("failed to close database.", err)
+		}
+	}()
+
+	// Abandon the transfers interrupted long ago
+	pruned, err := syncnet.PruneTransfers(store, config.GetConfig(), time.Now().Add(-syncnet.TransferExpiry))
+	if err != nil {
+		slog.Error("failed to prune stale transfers.", err)
+	} else if pruned > 0 {
+		slog.Info("Pruned stale transfers", "count", pruned)
+	}
 
+	// Stop the daemon on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Start client daemon
+	slog.Info("Starting client...")
+	daemon, err := client.NewDaemon(store, config.GetConfig(), client.GetConfig())
+	if err != nil {
+		slog.Error("failed to create syncat client.", err)
+		return
+	}
+	err = daemon.Run(ctx, func() (config.SyncatConfig, client.SyncatClientConfig, error) {
+		return readConfigs(path, clientPath)
+	})
+	if err != nil {
+		slog.Error("failed to run syncat client.", err)
+	}
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"github.com/JeffersonQin/syncat/pkg/database"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// usage is printed when the subcommand is invalid
+const usage = `usage: server [options] [command]
+
+Without command, the server is started, and reloads the config files on SIGHUP.
+
+options:
+  --config <path>         shared config file, SYNCAT_CONFIG if not given, searched in
+                          $XDG_CONFIG_HOME/syncat, $XDG_CONFIG_DIRS/syncat, and ../config beside the executable
+  --server-config <path>  server config file, SYNCAT_SERVER_CONFIG if not given,
+                          server_config.yml beside the shared config file, or searched likewise
+
+Settings are overridden by environment variables named after their keys,
+e.g. SYNCAT_AUTH_TOKEN for auth.token, and SYNCAT_SERVER_PORT for port of the server config.
+
+commands:
+  config check  validate the config files and report all the problems found
+
+admin commands, where client is the id, uuid, or name of a client:
+  admin list                    list the registered clients
+  admin rename <client> <name>  give the client a human name, overriding its device name
+  admin revoke <client>         refuse the client from connecting
+  admin unrevoke <client>       allow the revoked client to connect again
+  admin purge <client>          delete the last sync status with the client
+`
+
+// timeFormat is the format of the time printed
+const timeFormat = "2006-01-02 15:04:05"
+
+// runAdmin Run the admin command against the database of the server, and return the exit code
+func runAdmin(args []string) int {
+	if len(args) < 2 || args[0] != "admin" {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	command, args := args[1], args[2:]
+	valid := (command == "list" && len(args) == 0) || (command == "rename" && len(args) == 2) ||
+		((command == "revoke" || command == "unrevoke" || command == "purge") && len(args) == 1)
+	if !valid {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	store, err := database.LoadDatabase(config.GetConfig().Db, database.ServerRole)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to open database.", err)
+		return 1
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+	if command == "list" {
+		err = listClients(store)
+	} else {
+		err = manageClient(store, command, args)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// formatTime Format the time, or a dash if it is zero
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(timeFormat)
+}
+
+// orDash Get the string, or a dash if it is empty
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// listClients Print the registered clients
+func listClients(store *database.Store) error {
+	clients, err := store.QueryClients()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tUUID\tHOST\tOS\tVERSION\tFIRST SEEN\tLAST SEEN\tLAST SYNC\tRESULT\tSTATE")
+	for _, c := range clients {
+		state, result := "active", c.LastSyncResult
+		if c.Revoked {
+			state = "revoked"
+		}
+		if result == "" {
+			result = "-"
+		} else {
+			result = c.LastSyncRoot + ": " + result
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", c.Id, c.DisplayName(), c.Uuid,
+			orDash(c.Hostname), orDash(c.Os), orDash(c.Version), formatTime(c.FirstSeen), formatTime(c.LastSeen),
+			formatTime(c.LastSyncTime), result, state)
+	}
+	return w.Flush()
+}
+
+// manageClient Run the admin command on the client referred by the first argument
+func manageClient(store *database.Store, command string, args []string) error {
+	c, err := store.QueryClientByRef(args[0])
+	if err != nil {
+		return err
+	}
+	switch command {
+	case "rename":
+		err = store.UpdateClientName(c.Id, args[1])
+	case "revoke", "unrevoke":
+		err = store.UpdateClientRevoked(c.Id, command == "revoke")
+	case "purge":
+		var count int64
+		count, err = store.DeleteLastSyncOfClient(c.Id)
+		if err == nil {
+			fmt.Printf("deleted %d last_sync rows of client %d\n", count, c.Id)
+		}
+	}
+	return err
+}
@@ -1,45 +1,151 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"github.com/JeffersonQin/syncat/internal/server"
 	"github.com/JeffersonQin/syncat/pkg/config"
 	"github.com/JeffersonQin/syncat/pkg/database"
+	"github.com/JeffersonQin/syncat/pkg/logging"
+	"github.com/JeffersonQin/syncat/pkg/syncnet"
+	"golang.org/x/exp/slog"
 	"log"
+	"os"
+	"time"
 )
 
-func init() {
-	// Load common config
-	log.Println("Loading config...")
-	err := config.LoadConfig()
+// configPath and serverConfigPath are the paths of the config files given on the command line
+var (
+	configPath       = flag.String("config", "", "path of the shared config file")
+	serverConfigPath = flag.String("server-config", "", "path of the server config file")
+)
+
+// loadConfigs Locate and load the shared and the server config files given by the flags, and return their paths
+func loadConfigs() (string, string, error) {
+	path, err := config.Locate("config.yml", *configPath, "SYNCAT_CONFIG", "")
+	if err != nil {
+		return "", "", err
+	}
+	err = config.LoadConfig(path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load config %s: %w", path, err)
+	}
+	serverPath, err := config.Locate("server_config.yml", *serverConfigPath, "SYNCAT_SERVER_CONFIG", path)
 	if err != nil {
-		log.Fatalln("failed to load config.", err)
+		return "", "", err
 	}
-	// Load server config
-	log.Println("Loading server config...")
-	err = server.LoadConfig()
+	err = server.LoadConfig(serverPath)
 	if err != nil {
-		log.Fatalln("failed to load server config.", err)
+		return "", "", fmt.Errorf("failed to load server config %s: %w", serverPath, err)
 	}
+	return path, serverPath, nil
 }
 
-func main() {
+// setup Load and validate the configurations from the config files given by the flags,
+// and set up logging
+// The paths of the shared and the server config files are returned, which are read again on reload
+func setup() (string, string) {
+	path, serverPath, err := loadConfigs()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("Loaded config from", path, "and", serverPath)
+	// Refuse to start with any problem in the configurations, reporting all of them
+	sharedErr := config.GetConfig().Validate()
+	serverErr := server.GetConfig().Validate()
+	if sharedErr != nil || serverErr != nil {
+		config.Report(os.Stderr, path, sharedErr)
+		config.Report(os.Stderr, serverPath, serverErr)
+		log.Fatalln("invalid configuration, fix the problems above")
+	}
+	// Log in the configured level and format from now on
+	err = logging.Setup(config.GetConfig().Log)
+	if err != nil {
+		log.Fatalln("failed to set up logging.", err)
+	}
+	return path, serverPath
+}
+
+// readConfigs Read and validate the config files at the paths again, for reloading them
+func readConfigs(path string, serverPath string) (config.SyncatConfig, server.SyncatServerConfig, error) {
+	shared, err := config.ReadConfig(path)
+	if err != nil {
+		return config.SyncatConfig{}, server.SyncatServerConfig{}, fmt.Errorf("failed to load config %s: %w", path, err)
+	}
+	serverConfig, err := server.ReadConfig(serverPath)
+	if err != nil {
+		return config.SyncatConfig{}, server.SyncatServerConfig{},
+			fmt.Errorf("failed to load server config %s: %w", serverPath, err)
+	}
+	err = shared.Validate()
+	if err != nil {
+		return config.SyncatConfig{}, server.SyncatServerConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+	err = serverConfig.Validate()
+	if err != nil {
+		return config.SyncatConfig{}, server.SyncatServerConfig{}, fmt.Errorf("%s: %w", serverPath, err)
+	}
+	return shared, serverConfig, nil
+}
+
+// run Open the database and serve the clients until the server stops, reloading the config files at the paths
+// The database is closed before returning, so that exiting on the error returned does not skip it
+func run(path string, serverPath string) error {
 	// Load database
-	log.Println("Loading database...")
-	err := database.LoadDatabase()
+	slog.Info("Loading database...")
+	store, err := database.LoadDatabase(config.GetConfig().Db, database.ServerRole)
 	if err != nil {
-		log.Fatalln("failed to open database.", err)
+		return fmt.Errorf("failed to open database: %w", err)
 	}
 	defer func() {
-		err := database.CloseDatabase()
+		err := store.Close()
 		if err != nil {
-			log.Println("failed to close database.", err)
+			slog.Error("failed to close database.", err)
 		}
 	}()
 
+	// Abandon the transfers interrupted long ago
+	pruned, err := syncnet.PruneTransfers(store, config.GetConfig(), time.Now().Add(-syncnet.TransferExpiry))
+	if err != nil {
+		slog.Error("failed to prune stale transfers.", err)
+	} else if pruned > 0 {
+		slog.Info("Pruned stale transfers", "count", pruned)
+	}
+
 	// Start server
-	log.Println("Starting server...")
-	err = server.StartSyncatServer()
+	slog.Info("Starting server...")
+	err = server.StartSyncatServer(store, func() (config.SyncatConfig, server.SyncatServerConfig, error) {
+		return readConfigs(path, serverPath)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start syncat server: %w", err)
+	}
+	return nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Parse()
+
+	// Check the configurations without starting
+	if flag.Arg(0) == "config" {
+		os.Exit(runConfig(flag.Args()))
+	}
+
+	// Run admin command against the database, which does not need a valid configuration
+	if flag.NArg() > 0 {
+		_, _, err := loadConfigs()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		os.Exit(runAdmin(flag.Args()))
+	}
+	path, serverPath := setup()
+	err := run(path, serverPath)
 	if err != nil {
-		log.Fatalln("failed to start syncat server.", err)
+		slog.Error("failed to run syncat server.", err)
+		os.Exit(1)
 	}
 }
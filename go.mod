@@ -8,6 +8,9 @@ require (
 	github.com/golang/protobuf v1.5.2
 	github.com/google/uuid v1.3.0
 	github.com/mattn/go-sqlite3 v1.14.16
+	golang.org/x/crypto v0.5.0
 	golang.org/x/exp v0.0.0-20221212164502-fae10dda9338
 	google.golang.org/protobuf v1.26.0
 )
+
+require golang.org/x/sys v0.4.0 // indirect
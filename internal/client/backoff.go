@@ -0,0 +1,57 @@
+package client
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff is the delay between reconnections, growing exponentially with jitter
+type backoff struct {
+	// initial is the delay before the first reconnection
+	initial time.Duration
+	// maxDelay is the maximum delay
+	maxDelay time.Duration
+	// attempt is the number of reconnections failed in a row
+	attempt int
+	// rand is the source of jitter
+	rand *rand.Rand
+}
+
+// newBackoff Create a new backoff growing from initial up to maxDelay
+func newBackoff(initial time.Duration, maxDelay time.Duration) *backoff {
+	b := &backoff{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	b.SetDelays(initial, maxDelay)
+	return b
+}
+
+// SetDelays Change the delays, keeping the number of failed attempts, e.g. when the configuration is reloaded
+func (b *backoff) SetDelays(initial time.Duration, maxDelay time.Duration) {
+	if initial <= 0 {
+		initial = time.Second
+	}
+	if maxDelay < initial {
+		maxDelay = initial
+	}
+	b.initial, b.maxDelay = initial, maxDelay
+}
+
+// Next Get the delay before the next reconnection
+// The delay is doubled for every failed attempt, and half of it is random,
+// so that the clients disconnected at the same time do not reconnect at the same time
+func (b *backoff) Next() time.Duration {
+	delay := b.initial
+	for i := 0; i < b.attempt && delay < b.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > b.maxDelay {
+		delay = b.maxDelay
+	}
+	b.attempt++
+	half := delay / 2
+	return half + time.Duration(b.rand.Int63n(int64(delay-half)+1))
+}
+
+// Reset Reset the delay after a successful connection
+func (b *backoff) Reset() {
+	b.attempt = 0
+}
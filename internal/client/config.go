@@ -0,0 +1,152 @@
+package client
+
+import (
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"sync/atomic"
+)
+
+// SyncatClientConfig is the configuration for the Syncat client
+type SyncatClientConfig struct {
+	// Name of the device shown on the server, e.g. Alice's laptop
+	DeviceName string `yaml:"device_name"`
+	// Port of the server
+	Port int `yaml:"port"`
+	// Host of the server
+	Host string `yaml:"host"`
+	// Unix domain socket of the server on the same machine, connected instead of host and port if not empty
+	Socket string `yaml:"socket"`
+	// Interval in seconds for scanning the sync roots for local changes
+	ScanInterval int `yaml:"scan_interval"`
+	// Interval in seconds for syncing all the sync roots, even if nothing changes locally
+	SyncInterval int `yaml:"sync_interval"`
+	// Delay in seconds before the first reconnection
+	ReconnectDelay int `yaml:"reconnect_delay"`
+	// Maximum delay in seconds between reconnections
+	MaxReconnectDelay int `yaml:"max_reconnect_delay"`
+	// Unix domain socket for controlling the client, disabled if empty
+	ControlSocket string `yaml:"control_socket"`
+}
+
+// clientConfig is the loaded client configuration, replaced as a whole by LoadConfig and SetConfig
+var clientConfig atomic.Pointer[SyncatClientConfig]
+
+// EnvPrefix is the prefix of the environment variables overriding the client configuration, e.g. SYNCAT_CLIENT_PORT
+const EnvPrefix = config.EnvPrefix + "CLIENT_"
+
+// LoadConfig Load the client configuration from the config file, overridden by the environment variables of EnvPrefix
+// Relative paths are resolved against the directory given by config.BaseDir
+func LoadConfig(path string) error {
+	loaded, err := ReadConfig(path)
+	if err != nil {
+		return err
+	}
+	SetConfig(loaded)
+	return nil
+}
+
+// ReadConfig Read the client configuration from the config file like LoadConfig, without replacing the loaded one
+func ReadConfig(path string) (SyncatClientConfig, error) {
+	var loaded SyncatClientConfig
+	err := config.LoadFile(path, &loaded)
+	if err != nil {
+		return SyncatClientConfig{}, err
+	}
+	err = config.ApplyEnv(EnvPrefix, &loaded)
+	if err != nil {
+		return SyncatClientConfig{}, err
+	}
+	base := config.BaseDir(path)
+	loaded.Socket = config.ResolvePath(base, loaded.Socket)
+	loaded.ControlSocket = config.ResolvePath(base, loaded.ControlSocket)
+	return loaded, nil
+}
+
+// GetConfig Get a snapshot of the loaded client configuration, which is consistent even if it is reloaded concurrently
+func GetConfig() SyncatClientConfig {
+	if c := clientConfig.Load(); c != nil {
+		return *c
+	}
+	return SyncatClientConfig{}
+}
+
+// SetConfig Replace the loaded client configuration, e.g. by the one applied on reload
+func SetConfig(c SyncatClientConfig) {
+	clientConfig.Store(&c)
+}
+
+// Validate Check the client configuration, and return config.ErrInvalidConfig with all the problems found
+func (c SyncatClientConfig) Validate() error {
+	var p config.Problems
+	switch {
+	case c.DeviceName == "":
+		p.Add("device_name", "must not be empty, set the name of this device shown on the server")
+	case config.IsPlaceholder(c.DeviceName):
+		p.Add("device_name", "is the placeholder %s of the template, set the name of this device shown on the server",
+			c.DeviceName)
+	}
+	if c.Socket == "" {
+		if c.Host == "" {
+			p.Add("host", "must not be empty unless socket is set")
+		}
+		p.CheckPort("port", c.Port)
+	}
+	intervals := []struct {
+		field string
+		value int
+	}{
+		{"scan_interval", c.ScanInterval},
+		{"sync_interval", c.SyncInterval},
+		{"reconnect_delay", c.ReconnectDelay},
+		{"max_reconnect_delay", c.MaxReconnectDelay},
+	}
+	for _, interval := range intervals {
+		if interval.value < 0 {
+			p.Add(interval.field, "must not be negative, 0 for the default")
+		}
+	}
+	if c.ReconnectDelay > 0 && c.MaxReconnectDelay > 0 && c.MaxReconnectDelay < c.ReconnectDelay {
+		p.Add("max_reconnect_delay", "must not be less than reconnect_delay (%d)", c.ReconnectDelay)
+	}
+	return p.Err()
+}
+
+// reloadConfig Get the client configuration applied by reloading the current one with the loaded one,
+// and record the changes
+// The device name, the address of the server, and the control socket keep their current values until restarting,
+// while the intervals and the reconnection delays are applied live
+func reloadConfig(current SyncatClientConfig, loaded SyncatClientConfig, changes *config.Changes) SyncatClientConfig {
+	applied := loaded
+	if loaded.DeviceName != current.DeviceName {
+		changes.RequireRestart("device_name")
+		applied.DeviceName = current.DeviceName
+	}
+	if loaded.Host != current.Host {
+		changes.RequireRestart("host")
+		applied.Host = current.Host
+	}
+	if loaded.Port != current.Port {
+		changes.RequireRestart("port")
+		applied.Port = current.Port
+	}
+	if loaded.Socket != current.Socket {
+		changes.RequireRestart("socket")
+		applied.Socket = current.Socket
+	}
+	if loaded.ControlSocket != current.ControlSocket {
+		changes.RequireRestart("control_socket")
+		applied.ControlSocket = current.ControlSocket
+	}
+	if loaded.ScanInterval != current.ScanInterval {
+		changes.Apply("scan_interval")
+	}
+	if loaded.SyncInterval != current.SyncInterval {
+		changes.Apply("sync_interval")
+	}
+	if loaded.ReconnectDelay != current.ReconnectDelay {
+		changes.Apply("reconnect_delay")
+	}
+	if loaded.MaxReconnectDelay != current.MaxReconnectDelay {
+		changes.Apply("max_reconnect_delay")
+	}
+	return applied
+}
@@ -0,0 +1,279 @@
+package client
+
+import (
+	"encoding/json"
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"github.com/JeffersonQin/syncat/pkg/syncnet"
+	"net"
+	"os"
+	"sort"
+	"time"
+)
+
+// Commands of the control API
+const (
+	// CommandStatus queries the status of the daemon and its sync roots
+	CommandStatus = "status"
+	// CommandSync triggers a sync of the sync root, or all the sync roots if the root is empty
+	CommandSync = "sync"
+	// CommandPause pauses the sync root
+	CommandPause = "pause"
+	// CommandResume resumes the sync root
+	CommandResume = "resume"
+	// CommandConflicts lists the unresolved conflicts
+	CommandConflicts = "conflicts"
+	// CommandReload reloads the config files, applying the changes safe to apply live
+	CommandReload = "reload"
+)
+
+// controlTimeout is the timeout for a request of the control API
+const controlTimeout = 10 * time.Second
+
+// ControlRequest is a request to the control API, sent as a line of JSON
+type ControlRequest struct {
+	// Command is one of the commands of the control API
+	Command string `json:"command"`
+	// Root is the name of the sync root the command applies to
+	Root string `json:"root,omitempty"`
+}
+
+// ControlResponse is the response of the control API, sent as a line of JSON
+type ControlResponse struct {
+	// Ok indicates whether the request succeeded
+	Ok bool `json:"ok"`
+	// Error is the message of the failure
+	Error string `json:"error,omitempty"`
+	// Status is the response of status command
+	Status *Status `json:"status,omitempty"`
+	// Conflicts is the response of conflicts command
+	Conflicts []ConflictStatus `json:"conflicts,omitempty"`
+	// Changes is the response of reload command
+	Changes *config.Changes `json:"changes,omitempty"`
+}
+
+// Status is the status of the daemon
+type Status struct {
+	// Server is the address of the server
+	Server string `json:"server"`
+	// Connected indicates whether the daemon is connected to the server
+	Connected bool `json:"connected"`
+	// ClientUuid is the uuid allocated by the server, empty if not registered yet
+	ClientUuid string `json:"client_uuid"`
+	// Roots are the status of the sync roots, ordered by name
+	Roots []RootStatus `json:"roots"`
+}
+
+// RootStatus is the status of a sync root
+type RootStatus struct {
+	// Root is the name of the sync root
+	Root string `json:"root"`
+	// Directory is the local directory of the sync root
+	Directory string `json:"directory"`
+	// Paused indicates whether the sync root is paused
+	Paused bool `json:"paused"`
+	// Syncing indicates whether the sync root is in a sync session
+	Syncing bool `json:"syncing"`
+	// LastSync is the time the last sync session ended, nil if never synced since the daemon started
+	LastSync *time.Time `json:"last_sync,omitempty"`
+	// LastStats is the summary of the last successful sync session
+	LastStats syncnet.SyncStats `json:"last_stats"`
+	// LastError is the error of the last sync session, empty if it succeeded
+	LastError string `json:"last_error,omitempty"`
+}
+
+// ConflictStatus is an unresolved conflict
+type ConflictStatus struct {
+	// Root is the name of the sync root
+	Root string `json:"root"`
+	// Path is the path of the conflicting file, which now has the version of the server
+	Path string `json:"path"`
+	// ConflictPath is the path the local copy of the file is moved to
+	ConflictPath string `json:"conflict_path"`
+	// Detected is the time the conflict is detected
+	Detected time.Time `json:"detected"`
+}
+
+// listenControl Listen on the control socket
+// A socket left by a daemon not running anymore is removed, while a socket of a running daemon is never taken over
+func listenControl(socket string) (net.Listener, error) {
+	if _, err := os.Stat(socket); err == nil {
+		c, err := net.DialTimeout("unix", socket, controlTimeout)
+		if err == nil {
+			_ = c.Close()
+			return nil, ErrDaemonRunning{socket}
+		}
+		err = os.Remove(socket)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// only the owner of the daemon can control it
+	return listenPrivate(socket)
+}
+
+// serveControl Serve the control API on the listener until it is closed
+func (d *Daemon) serveControl(listener net.Listener) {
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go d.handleControl(c)
+	}
+}
+
+// handleControl Handle the requests of a control connection, one line of JSON for each
+func (d *Daemon) handleControl(c net.Conn) {
+	defer func() {
+		_ = c.Close()
+	}()
+	decoder := json.NewDecoder(c)
+	encoder := json.NewEncoder(c)
+	for {
+		var req ControlRequest
+		err := decoder.Decode(&req)
+		if err != nil {
+			return
+		}
+		err = encoder.Encode(d.control(req))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// control Handle a request of the control API
+func (d *Daemon) control(req ControlRequest) ControlResponse {
+	var resp ControlResponse
+	var err error
+	switch req.Command {
+	case CommandStatus:
+		resp.Status, err = d.status()
+	case CommandSync:
+		err = d.requestSync(req.Root)
+	case CommandPause:
+		err = d.setPaused(req.Root, true)
+	case CommandResume:
+		err = d.setPaused(req.Root, false)
+	case CommandConflicts:
+		resp.Conflicts, err = d.conflicts()
+	case CommandReload:
+		var changes config.Changes
+		changes, err = d.reload()
+		resp.Changes = &changes
+	default:
+		err = ErrUnknownCommand{req.Command}
+	}
+	if err != nil {
+		return ControlResponse{Error: err.Error()}
+	}
+	resp.Ok = true
+	return resp
+}
+
+// status Get the status of the daemon and its sync roots
+func (d *Daemon) status() (*Status, error) {
+	clientUuid, err := d.store.QueryClientUuid()
+	if err != nil {
+		return nil, err
+	}
+	_, addr := d.address()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	status := &Status{
+		Server:     addr,
+		Connected:  d.connected,
+		ClientUuid: clientUuid,
+	}
+	for root, dir := range d.roots() {
+		s := d.rootStatus[root]
+		s.Root, s.Directory, s.Paused = root, dir, d.paused[root]
+		status.Roots = append(status.Roots, s)
+	}
+	sort.Slice(status.Roots, func(i, j int) bool {
+		return status.Roots[i].Root < status.Roots[j].Root
+	})
+	return status, nil
+}
+
+// requestSync Request the daemon to sync the sync root, or all the sync roots if root is empty
+// The sync runs once the daemon is connected and idle
+func (d *Daemon) requestSync(root string) error {
+	if root != "" {
+		if _, ok := d.roots()[root]; !ok {
+			return ErrUnknownRoot{root}
+		}
+		if d.isPaused(root) {
+			return ErrRootPaused{root}
+		}
+	}
+	select {
+	case d.requests <- root:
+	default:
+		// enough syncs are pending already
+	}
+	return nil
+}
+
+// setPaused Pause or resume the sync root
+// The state is kept in database, so that a paused sync root stays paused after restarting
+func (d *Daemon) setPaused(root string, paused bool) error {
+	if _, ok := d.roots()[root]; !ok {
+		return ErrUnknownRoot{root}
+	}
+	err := d.store.UpdateRootPaused(root, paused)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.paused[root] = paused
+	return nil
+}
+
+// conflicts List the unresolved conflicts
+func (d *Daemon) conflicts() ([]ConflictStatus, error) {
+	conflicts, err := d.store.QueryUnresolvedConflicts()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ConflictStatus, 0, len(conflicts))
+	for _, c := range conflicts {
+		result = append(result, ConflictStatus{
+			Root:         c.Root,
+			Path:         c.Path,
+			ConflictPath: c.ConflictPath,
+			Detected:     c.Detected,
+		})
+	}
+	return result, nil
+}
+
+// Control Send the request to the control API of the daemon listening on the socket
+// ErrControlFailed is returned if the daemon fails to handle the request
+func Control(socket string, req ControlRequest) (ControlResponse, error) {
+	c, err := net.DialTimeout("unix", socket, controlTimeout)
+	if err != nil {
+		return ControlResponse{}, err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	err = c.SetDeadline(time.Now().Add(controlTimeout))
+	if err != nil {
+		return ControlResponse{}, err
+	}
+	err = json.NewEncoder(c).Encode(req)
+	if err != nil {
+		return ControlResponse{}, err
+	}
+	var resp ControlResponse
+	err = json.NewDecoder(c).Decode(&resp)
+	if err != nil {
+		return ControlResponse{}, err
+	}
+	if !resp.Ok {
+		return resp, ErrControlFailed{resp.Error}
+	}
+	return resp, nil
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package client
+
+import (
+	"net"
+	"os"
+)
+
+// listenPrivate Listen on the Unix socket, which is made accessible only by its owner
+// The platform has no umask, so the permissions are set right after the socket is created
+func listenPrivate(socket string) (net.Listener, error) {
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(socket, 0600)
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package client
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenPrivate Listen on the Unix socket, which is created accessible only by its owner
+// The umask is narrowed while the socket is created, so that it is never reachable with looser permissions
+// The umask is shared by the whole process, and the control socket is created before the daemon writes any file
+func listenPrivate(socket string) (net.Listener, error) {
+	umask := syscall.Umask(0177)
+	defer syscall.Umask(umask)
+	return net.Listen("unix", socket)
+}
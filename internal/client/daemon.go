@@ -0,0 +1,377 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"github.com/JeffersonQin/syncat/pkg/database"
+	"github.com/JeffersonQin/syncat/pkg/scanner"
+	"github.com/JeffersonQin/syncat/pkg/syncnet"
+	"golang.org/x/exp/slog"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Daemon keeps the client connected to the server, and syncs the sync roots
+// on local changes and on schedule
+type Daemon struct {
+	store *database.Store
+	// shared holds the configuration shared with the server, and config is the configuration of the client,
+	// both replaced by Reload
+	shared *config.Holder
+	config atomic.Pointer[SyncatClientConfig]
+	// bandwidth limits the transfers of the connections to the server, replaced on reload
+	bandwidth *syncnet.Bandwidth
+	// load reads the configurations again for the reloads requested by SIGHUP and the control API, nil if not supported
+	load Loader
+	// reloadMu serializes the reloads
+	reloadMu sync.Mutex
+	// reloaded is signalled when the configurations are reloaded, so that the intervals are applied
+	reloaded chan struct{}
+	// requests are the sync roots requested to sync by the control API, empty for all the sync roots
+	requests chan string
+	// mu guards the states below, which are read by the control API
+	mu sync.Mutex
+	// connected indicates whether the daemon is connected to the server
+	connected bool
+	// paused are the paused sync roots
+	paused map[string]bool
+	// rootStatus are the status of the sync roots synced since the daemon started
+	rootStatus map[string]RootStatus
+}
+
+// NewDaemon Create a new daemon syncing with the database and the configurations
+// An error is returned if the bandwidth limits are invalid
+func NewDaemon(store *database.Store, sharedConfig config.SyncatConfig,
+	clientConfig SyncatClientConfig) (*Daemon, error) {
+	bandwidth, err := syncnet.NewBandwidth(sharedConfig.Protocol.Bandwidth)
+	if err != nil {
+		return nil, err
+	}
+	d := &Daemon{
+		store:      store,
+		shared:     config.NewHolder(sharedConfig),
+		bandwidth:  bandwidth,
+		reloaded:   make(chan struct{}, 1),
+		requests:   make(chan string, 16),
+		paused:     make(map[string]bool),
+		rootStatus: make(map[string]RootStatus),
+	}
+	d.config.Store(&clientConfig)
+	return d, nil
+}
+
+// address Get the network and the address of the server, which is the Unix socket if configured
+func (d *Daemon) address() (string, string) {
+	clientConfig := d.config.Load()
+	if clientConfig.Socket != "" {
+		return "unix", clientConfig.Socket
+	}
+	return "tcp", clientConfig.Host + ":" + strconv.Itoa(clientConfig.Port)
+}
+
+// seconds Convert the configured seconds to duration, using the default value if not configured
+func seconds(value int, defaultValue int) time.Duration {
+	if value <= 0 {
+		value = defaultValue
+	}
+	return time.Duration(value) * time.Second
+}
+
+// reconnectDelays Get the configured delay before the first reconnection, and the maximum delay between reconnections
+func (d *Daemon) reconnectDelays() (time.Duration, time.Duration) {
+	clientConfig := d.config.Load()
+	return seconds(clientConfig.ReconnectDelay, 1), seconds(clientConfig.MaxReconnectDelay, 60)
+}
+
+// Run Run the daemon until the context is cancelled
+// The daemon reconnects with exponential backoff whenever the connection is lost
+// The control API is served on the control socket if configured
+// The configurations read by load are reloaded on SIGHUP and by the control API, which is refused if load is nil
+func (d *Daemon) Run(ctx context.Context, load Loader) error {
+	d.load = load
+	stop := d.reloadOnHangup()
+	defer stop()
+	paused, err := d.store.QueryPausedRoots()
+	if err != nil {
+		return err
+	}
+	for _, root := range paused {
+		d.paused[root] = true
+	}
+	if socket := d.config.Load().ControlSocket; socket != "" {
+		listener, err := listenControl(socket)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = listener.Close()
+		}()
+		go d.serveControl(listener)
+	}
+	b := newBackoff(d.reconnectDelays())
+	for {
+		conn, err := d.connect(ctx)
+		if err == nil {
+			b.Reset()
+			conn.Logger().Info("Connected to server")
+			d.setConnected(true)
+			err = d.serve(ctx, conn)
+			d.setConnected(false)
+			_ = conn.Close()
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		b.SetDelays(d.reconnectDelays())
+		delay := b.Next()
+		_, addr := d.address()
+		slog.Warn("Lost connection to server", "server", addr, "retry_in", delay.String(), "err", err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// connect Connect to the server and authenticate
+// The uuid allocated by the server is stored when the client is newly registered
+func (d *Daemon) connect(ctx context.Context) (*syncnet.IdleTimeoutConn, error) {
+	dialer := net.Dialer{Timeout: seconds(d.shared.Get().Protocol.Timeout, 10)}
+	network, addr := d.address()
+	c, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	// the idle timeout follows the shared configuration on reload
+	conn := &syncnet.IdleTimeoutConn{
+		Conn:      c,
+		Config:    d.shared,
+		Bandwidth: d.bandwidth,
+	}
+	conn.StartStreams(true)
+	err = d.authenticate(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// authenticate Send AUTH request and handle the REPLY of the server
+func (d *Daemon) authenticate(conn *syncnet.IdleTimeoutConn) error {
+	auth, err := syncnet.NewSyncatAuthRequest(d.store, d.shared.Get(), d.config.Load().DeviceName)
+	if err != nil {
+		return err
+	}
+	err = auth.Send(conn.Control())
+	if err != nil {
+		return err
+	}
+	req, err := syncnet.Wait(conn.Control(), []syncnet.PacketType{syncnet.REPLY})
+	if err != nil {
+		return err
+	}
+	return req.Handle(conn.Control(), d.store)
+}
+
+// serve Keep the connection alive and sync the sync roots, until the context is cancelled or an error occurs
+// All the sync roots are synced once connected, since the server may have changed while disconnected
+// The changes pushed by the server are synced as soon as the running sync session is done
+func (d *Daemon) serve(ctx context.Context, conn *syncnet.IdleTimeoutConn) error {
+	pingCtx, stopPing := context.WithCancel(ctx)
+	defer stopPing()
+	lost := make(chan error, 1)
+	go d.keepAlive(pingCtx, conn, lost)
+	scan := time.NewTicker(seconds(d.config.Load().ScanInterval, 10))
+	defer scan.Stop()
+	schedule := time.NewTicker(seconds(d.config.Load().SyncInterval, 300))
+	defer schedule.Stop()
+	err := d.syncAll(conn)
+	for err == nil {
+		select {
+		case <-ctx.Done():
+			return syncnet.NewSyncatByeRequest().Send(conn.Control())
+		case err = <-lost:
+		case <-scan.C:
+			err = d.scanAll(conn)
+		case <-schedule.C:
+			err = d.syncAll(conn)
+		case <-d.reloaded:
+			scan.Reset(seconds(d.config.Load().ScanInterval, 10))
+			schedule.Reset(seconds(d.config.Load().SyncInterval, 300))
+			// the added sync roots are synced right away
+			err = d.syncAll(conn)
+		case <-conn.Notified():
+			// synced below
+		case root := <-d.requests:
+			if root == "" {
+				err = d.syncAll(conn)
+			} else {
+				err = d.sync(conn, root)
+			}
+		}
+		if err == nil {
+			err = d.syncNotified(conn)
+		}
+	}
+	return err
+}
+
+// keepAlive Ping the server on the control stream until the context is cancelled or a ping fails
+// The pings go on during the sync sessions, so that the connection does not idle out while either side scans or
+// hashes a large sync root. A failed ping is reported to lost, and the connection is closed to end the session
+// The ping interval is read for every ping, so that it follows the reloads
+func (d *Daemon) keepAlive(ctx context.Context, conn *syncnet.IdleTimeoutConn, lost chan<- error) {
+	for {
+		timer := time.NewTimer(seconds(d.shared.Get().Protocol.PingInterval, 5))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		err := d.ping(conn)
+		if err != nil {
+			lost <- err
+			_ = conn.Close()
+			return
+		}
+	}
+}
+
+// ping Send PING request and wait for PONG, so that the connection is not closed for idle timeout
+func (d *Daemon) ping(conn *syncnet.IdleTimeoutConn) error {
+	err := syncnet.NewSyncatPingRequest().Send(conn.Control())
+	if err != nil {
+		return err
+	}
+	req, err := syncnet.Wait(conn.Control(), []syncnet.PacketType{syncnet.PONG})
+	if err != nil {
+		return err
+	}
+	return req.Handle(conn.Control(), d.store)
+}
+
+// roots Get the names and directories of the configured sync roots
+func (d *Daemon) roots() map[string]string {
+	directories := d.shared.Get().Sync.Directories
+	result := make(map[string]string, len(directories))
+	for _, dir := range directories {
+		result[filepath.Base(dir)] = dir
+	}
+	return result
+}
+
+// setConnected Update whether the daemon is connected to the server
+func (d *Daemon) setConnected(connected bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.connected = connected
+}
+
+// isPaused Check whether the sync root is paused
+func (d *Daemon) isPaused(root string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.paused[root]
+}
+
+// scanAll Scan all the sync roots, and sync the ones with local changes
+// Paused sync roots are not scanned
+func (d *Daemon) scanAll(conn *syncnet.IdleTimeoutConn) error {
+	for root, dir := range d.roots() {
+		if d.isPaused(root) {
+			continue
+		}
+		changed, err := scanner.Scan(d.store, root, dir, conn.HashAlgorithm)
+		if err != nil {
+			conn.Logger().Error("Failed to scan", err, "root", root)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		err = d.sync(conn, root)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncAll Sync all the sync roots
+func (d *Daemon) syncAll(conn *syncnet.IdleTimeoutConn) error {
+	for root := range d.roots() {
+		err := d.sync(conn, root)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncNotified Sync the sync roots changed by other clients, as notified by the server
+func (d *Daemon) syncNotified(conn *syncnet.IdleTimeoutConn) error {
+	all := d.roots()
+	for _, root := range conn.TakeNotifiedRoots() {
+		if _, ok := all[root]; !ok {
+			continue
+		}
+		err := d.sync(conn, root)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sync Run a sync session of the sync root, and record its status
+// A sync root busy with the session of another client is left to the next scan or schedule
+// Paused sync roots are not synced
+func (d *Daemon) sync(conn *syncnet.IdleTimeoutConn, root string) error {
+	if d.isPaused(root) {
+		return nil
+	}
+	d.updateRootStatus(root, func(s *RootStatus) {
+		s.Syncing = true
+	})
+	stats, err := syncnet.RunSyncSession(conn, d.store, root)
+	d.updateRootStatus(root, func(s *RootStatus) {
+		now := time.Now()
+		s.Syncing, s.LastSync, s.LastError = false, &now, ""
+		if err != nil {
+			s.LastError = err.Error()
+		} else {
+			s.LastStats = stats
+		}
+	})
+	var busy syncnet.ErrRootBusy
+	if errors.As(err, &busy) {
+		conn.Logger().Info("Sync root is busy, retrying later", "root", root)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if stats != (syncnet.SyncStats{}) {
+		conn.Logger().Info("Synced", "root", root, "uploaded", stats.Uploaded, "downloaded", stats.Downloaded,
+			"deleted", stats.Deleted, "conflicts", stats.Conflicts, "bytes", stats.RawBytes,
+			"compression_ratio", stats.CompressionRatio())
+	}
+	return nil
+}
+
+// updateRootStatus Update the status of the sync root
+func (d *Daemon) updateRootStatus(root string, update func(s *RootStatus)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := d.rootStatus[root]
+	update(&s)
+	d.rootStatus[root] = s
+}
@@ -0,0 +1,61 @@
+package client
+
+import "fmt"
+
+// ErrDaemonRunning is returned when another daemon is listening on the control socket
+type ErrDaemonRunning struct {
+	socket string
+}
+
+// Error returns the error message
+func (e ErrDaemonRunning) Error() string {
+	return fmt.Sprintf("another daemon is running on %s", e.socket)
+}
+
+// ErrUnknownCommand is returned when the command of the control API is unknown
+type ErrUnknownCommand struct {
+	command string
+}
+
+// Error returns the error message
+func (e ErrUnknownCommand) Error() string {
+	return fmt.Sprintf("unknown command: %s", e.command)
+}
+
+// ErrUnknownRoot is returned when the sync root is not configured
+type ErrUnknownRoot struct {
+	root string
+}
+
+// Error returns the error message
+func (e ErrUnknownRoot) Error() string {
+	return fmt.Sprintf("unknown sync root: %s", e.root)
+}
+
+// ErrRootPaused is returned when syncing a paused sync root
+type ErrRootPaused struct {
+	root string
+}
+
+// Error returns the error message
+func (e ErrRootPaused) Error() string {
+	return fmt.Sprintf("sync root is paused: %s", e.root)
+}
+
+// ErrControlFailed is returned when the daemon fails to handle the request of the control API
+type ErrControlFailed struct {
+	message string
+}
+
+// Error returns the error message
+func (e ErrControlFailed) Error() string {
+	return fmt.Sprintf("control failed: %s", e.message)
+}
+
+// ErrReloadUnsupported is returned when reloading the configurations of a daemon run without loader
+type ErrReloadUnsupported struct{}
+
+// Error returns the error message
+func (e ErrReloadUnsupported) Error() string {
+	return "reloading config is not supported by the daemon"
+}
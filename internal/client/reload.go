@@ -0,0 +1,91 @@
+package client
+
+import (
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"github.com/JeffersonQin/syncat/pkg/logging"
+	"github.com/JeffersonQin/syncat/pkg/syncnet"
+	"golang.org/x/exp/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Loader reads and validates the shared and the client config files again, for reloading them
+type Loader func() (config.SyncatConfig, SyncatClientConfig, error)
+
+// Reload Apply the reloaded configurations to the daemon, and get the changes
+// The settings safe to change live are applied, and the others keep their current values until restarting,
+// see config.Reload and reloadConfig
+// The applied configurations are published to config.GetConfig and GetConfig as well
+// The configurations are expected to be validated, an error is returned otherwise if the bandwidth limits or
+// the log level are invalid, in which case nothing is applied
+func (d *Daemon) Reload(sharedConfig config.SyncatConfig, clientConfig SyncatClientConfig) (config.Changes, error) {
+	d.reloadMu.Lock()
+	defer d.reloadMu.Unlock()
+	shared, changes := config.Reload(d.shared.Get(), sharedConfig)
+	applied := reloadConfig(*d.config.Load(), clientConfig, &changes)
+	limits, err := syncnet.ParseBandwidth(shared.Protocol.Bandwidth)
+	if err != nil {
+		return config.Changes{}, err
+	}
+	level, err := logging.ParseLevel(shared.Log.Level)
+	if err != nil {
+		return config.Changes{}, err
+	}
+	d.bandwidth.Set(limits)
+	logging.SetLevel(level)
+	d.shared.Set(shared)
+	d.config.Store(&applied)
+	config.SetConfig(shared)
+	SetConfig(applied)
+	select {
+	case d.reloaded <- struct{}{}:
+	default:
+		// the reload before is not applied yet, which applies this one as well
+	}
+	return changes, nil
+}
+
+// reload Reload the configurations read by the loader of the daemon, and log the changes
+// ErrReloadUnsupported is returned if the daemon is run without loader
+func (d *Daemon) reload() (config.Changes, error) {
+	if d.load == nil {
+		return config.Changes{}, ErrReloadUnsupported{}
+	}
+	slog.Info("Reloading config...")
+	sharedConfig, clientConfig, err := d.load()
+	if err != nil {
+		return config.Changes{}, err
+	}
+	changes, err := d.Reload(sharedConfig, clientConfig)
+	if err != nil {
+		return config.Changes{}, err
+	}
+	slog.Info("Config reloaded", "applied", changes.Applied, "restart_required", changes.RestartRequired)
+	return changes, nil
+}
+
+// reloadOnHangup Reload the configurations whenever SIGHUP is received, until stop is called
+// The current configurations are kept if they fail to load
+func (d *Daemon) reloadOnHangup() (stop func()) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-hangup:
+				_, err := d.reload()
+				if err != nil {
+					slog.Error("failed to reload config, keeping the current one.", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(hangup)
+		close(done)
+	}
+}
@@ -0,0 +1,144 @@
+// Package faultnet injects network faults into connections, so that the protocol can be tested under bad networks
+package faultnet
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Faults are the faults injected into one direction of a connection
+// Offsets count the bytes of the direction since the connection is wrapped, and a negative offset disables the fault
+type Faults struct {
+	// Delay is slept before every read or write
+	Delay time.Duration
+	// Fragment splits the bytes into one byte reads or writes
+	Fragment bool
+	// DropAt is the offset of the first byte silently lost, and DropLength is the number of bytes lost
+	DropAt     int64
+	DropLength int64
+	// CorruptAt is the offset of the byte whose bits are flipped, and CorruptMask is the bits flipped,
+	// all the bits if zero
+	CorruptAt   int64
+	CorruptMask byte
+	// CutAt is the offset the connection is closed at, the bytes before it are delivered
+	CutAt int64
+}
+
+// NoFaults Get the faults injecting nothing, to set the wanted faults on
+func NoFaults() Faults {
+	return Faults{DropAt: -1, CorruptAt: -1, CutAt: -1}
+}
+
+// direction is the faults of a direction with the offset reached
+type direction struct {
+	mu     sync.Mutex
+	faults Faults
+	offset int64
+}
+
+// limit Get the number of bytes the next read or write may take at most, so that it stops right before the next fault
+// 0 is returned if the connection is cut at the offset
+func (d *direction) limit(n int) int {
+	if d.faults.Fragment && n > 1 {
+		n = 1
+	}
+	for _, at := range []int64{d.faults.DropAt, d.faults.DropAt + d.faults.DropLength, d.faults.CorruptAt,
+		d.faults.CutAt} {
+		if at > d.offset && at-d.offset < int64(n) {
+			n = int(at - d.offset)
+		}
+	}
+	if d.faults.CutAt >= 0 && d.offset >= d.faults.CutAt {
+		return 0
+	}
+	return n
+}
+
+// dropped Check whether the byte at the offset is dropped
+func (d *direction) dropped() bool {
+	return d.faults.DropAt >= 0 && d.offset >= d.faults.DropAt && d.offset < d.faults.DropAt+d.faults.DropLength
+}
+
+// corrupt Flip the bits of the byte at the fault offset if it is in b, which starts at the offset
+func (d *direction) corrupt(b []byte) {
+	if d.faults.CorruptAt >= d.offset && d.faults.CorruptAt < d.offset+int64(len(b)) {
+		mask := d.faults.CorruptMask
+		if mask == 0 {
+			mask = 0xff
+		}
+		b[d.faults.CorruptAt-d.offset] ^= mask
+	}
+}
+
+// Conn is the connection with faults injected into the bytes read from and written to it
+type Conn struct {
+	net.Conn
+	read  direction
+	write direction
+}
+
+// New Wrap the connection, injecting the read faults into the bytes read and the write faults into the bytes written
+func New(conn net.Conn, read Faults, write Faults) *Conn {
+	return &Conn{
+		Conn:  conn,
+		read:  direction{faults: read},
+		write: direction{faults: write},
+	}
+}
+
+// Read reads data from the connection with the read faults injected
+// io.EOF is returned once the connection is cut
+func (c *Conn) Read(b []byte) (int, error) {
+	c.read.mu.Lock()
+	defer c.read.mu.Unlock()
+	for {
+		time.Sleep(c.read.faults.Delay)
+		n := c.read.limit(len(b))
+		if n == 0 && len(b) > 0 {
+			_ = c.Conn.Close()
+			return 0, io.EOF
+		}
+		n, err := c.Conn.Read(b[:n])
+		if n > 0 && c.read.dropped() {
+			// dropped bytes never reach the reader, read again
+			c.read.offset += int64(n)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		c.read.corrupt(b[:n])
+		c.read.offset += int64(n)
+		return n, err
+	}
+}
+
+// Write writes data to the connection with the write faults injected
+// The dropped bytes are reported as written, and io.ErrClosedPipe is returned once the connection is cut
+func (c *Conn) Write(b []byte) (int, error) {
+	c.write.mu.Lock()
+	defer c.write.mu.Unlock()
+	written := 0
+	for written < len(b) {
+		time.Sleep(c.write.faults.Delay)
+		n := c.write.limit(len(b) - written)
+		if n == 0 {
+			_ = c.Conn.Close()
+			return written, io.ErrClosedPipe
+		}
+		chunk := b[written : written+n]
+		if !c.write.dropped() {
+			chunk = append([]byte(nil), chunk...)
+			c.write.corrupt(chunk)
+			_, err := c.Conn.Write(chunk)
+			if err != nil {
+				return written, err
+			}
+		}
+		c.write.offset += int64(n)
+		written += n
+	}
+	return written, nil
+}
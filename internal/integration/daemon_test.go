@@ -0,0 +1,40 @@
+package integration
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDaemonConverges(t *testing.T) {
+	h := New(t, 1)
+	other := h.Clients[0]
+	other.WriteFile("a.txt", "from client")
+	other.Sync()
+
+	d := h.NewDaemon("daemon")
+	d.WriteFile("dir/b.txt", "from daemon")
+	started := time.Now()
+	d.Start()
+	// the daemon syncs the sync root once connected
+	s := d.WaitSynced(started, 10*time.Second)
+	if s.LastStats.Uploaded != 1 || s.LastStats.Downloaded != 1 {
+		t.Fatalf("first sync of daemon = %+v, want b.txt uploaded and a.txt downloaded", s.LastStats)
+	}
+	h.AssertConvergedWith(d.Side)
+	if info, err := os.Stat(d.Socket); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("control socket = %v, %v, want accessible only by its owner", info, err)
+	}
+
+	// the changes of other clients are pushed to the daemon
+	other.WriteFile("a.txt", "edited by client")
+	other.Sync()
+	changed := time.Now()
+	d.WaitSynced(changed, 10*time.Second)
+	if got := d.ReadFile("a.txt"); got != "edited by client" {
+		t.Fatalf("a.txt on daemon = %q, want the edit of the client", got)
+	}
+	other.Sync()
+	h.AssertConverged()
+	h.AssertConvergedWith(d.Side)
+}
@@ -0,0 +1,160 @@
+package integration
+
+import (
+	"errors"
+	"github.com/JeffersonQin/syncat/internal/faultnet"
+	"github.com/JeffersonQin/syncat/pkg/syncnet"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// faultSize is the size of the file transferred under faults, which spans several chunks of faultOptions
+const faultSize = 256 << 10
+
+// faultOptions are the options of the harness for the transfers under faults
+// Compression is disabled, so that the offsets of the faults fall on the chunks as sent
+var faultOptions = Options{Clients: 1, BufferSize: 32 << 10, DisableCompression: true}
+
+// faulty Get the wrap injecting the faults into the bytes read from and written to the connection of a client
+func faulty(read faultnet.Faults, write faultnet.Faults) func(net.Conn) net.Conn {
+	return func(conn net.Conn) net.Conn {
+		return faultnet.New(conn, read, write)
+	}
+}
+
+// randomContent Get random content of the size, the same for the same seed
+func randomContent(size int, seed int64) string {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return string(data)
+}
+
+// syncAfterFault Reconnect the client without faults and sync, retrying while the server still holds the sync root
+// for the session broken by the fault
+func syncAfterFault(t *testing.T, c *Client) syncnet.SyncStats {
+	t.Helper()
+	c.Connect()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats, err := c.TrySync()
+		var busy syncnet.ErrRootBusy
+		if errors.As(err, &busy) && time.Now().Before(deadline) {
+			time.Sleep(20 * time.Millisecond)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s failed to sync after fault: %v", c.Name, err)
+		}
+		return stats
+	}
+}
+
+func TestSyncOverFragmentedConnection(t *testing.T) {
+	h := New(t, 2)
+	faults := faultnet.NoFaults()
+	faults.Fragment = true
+	err := h.Clients[0].TryConnect(faulty(faults, faults))
+	if err != nil {
+		t.Fatalf("failed to connect through fragmented connection: %v", err)
+	}
+	h.Clients[0].WriteFile("a.txt", randomContent(5000, 1))
+	h.Clients[1].WriteFile("b.txt", randomContent(5000, 2))
+	h.Converge()
+}
+
+func TestUploadRecoversCorruptedChunk(t *testing.T) {
+	h := NewWithOptions(t, faultOptions)
+	c := h.Clients[0]
+	content := randomContent(faultSize, 1)
+	c.WriteFile("big.bin", content)
+	// the second chunk is corrupted, after AUTH, SYNC, the probe and the first chunk
+	faults := faultnet.NoFaults()
+	faults.CorruptAt = 50000
+	err := c.TryConnect(faulty(faultnet.NoFaults(), faults))
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	stats := c.Sync()
+	if stats.RawBytes <= faultSize {
+		t.Fatalf("%d bytes sent, want the corrupted chunk sent again", stats.RawBytes)
+	}
+	if h.Server.ReadFile("big.bin") != content {
+		t.Fatal("big.bin is corrupted on server")
+	}
+}
+
+func TestDownloadRecoversCorruptedChunk(t *testing.T) {
+	h := NewWithOptions(t, faultOptions)
+	c := h.Clients[0]
+	content := randomContent(faultSize, 1)
+	h.Server.WriteFile("big.bin", content)
+	faults := faultnet.NoFaults()
+	faults.CorruptAt = 50000
+	err := c.TryConnect(faulty(faults, faultnet.NoFaults()))
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	stats := c.Sync()
+	if stats.RawBytes <= faultSize {
+		t.Fatalf("%d bytes received, want the corrupted chunk received again", stats.RawBytes)
+	}
+	if c.ReadFile("big.bin") != content {
+		t.Fatal("big.bin is corrupted on client")
+	}
+}
+
+func TestUploadResumesAfterCut(t *testing.T) {
+	h := NewWithOptions(t, faultOptions)
+	c := h.Clients[0]
+	content := randomContent(faultSize, 1)
+	c.WriteFile("big.bin", content)
+	faults := faultnet.NoFaults()
+	faults.CutAt = faultSize / 2
+	err := c.TryConnect(faulty(faultnet.NoFaults(), faults))
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	_, err = c.TrySync()
+	if err == nil {
+		t.Fatal("sync succeeds over cut connection")
+	}
+	if h.Server.Exists("big.bin") {
+		t.Fatal("big.bin is partially written on server")
+	}
+	stats := syncAfterFault(t, c)
+	if stats.RawBytes >= faultSize {
+		t.Fatalf("%d bytes sent after reconnecting, want the transfer resumed", stats.RawBytes)
+	}
+	if h.Server.ReadFile("big.bin") != content {
+		t.Fatal("big.bin is corrupted on server")
+	}
+}
+
+func TestDownloadResumesAfterCut(t *testing.T) {
+	h := NewWithOptions(t, faultOptions)
+	c := h.Clients[0]
+	content := randomContent(faultSize, 1)
+	h.Server.WriteFile("big.bin", content)
+	faults := faultnet.NoFaults()
+	faults.CutAt = faultSize / 2
+	err := c.TryConnect(faulty(faults, faultnet.NoFaults()))
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	_, err = c.TrySync()
+	if err == nil {
+		t.Fatal("sync succeeds over cut connection")
+	}
+	if c.Exists("big.bin") {
+		t.Fatal("big.bin is partially written on client")
+	}
+	stats := syncAfterFault(t, c)
+	if stats.RawBytes >= faultSize {
+		t.Fatalf("%d bytes received after reconnecting, want the transfer resumed", stats.RawBytes)
+	}
+	if c.ReadFile("big.bin") != content {
+		t.Fatal("big.bin is corrupted on client")
+	}
+}
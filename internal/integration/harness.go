@@ -0,0 +1,485 @@
+// Package integration runs the server and its clients in one process, so that sync scenarios can be tested end to end
+package integration
+
+import (
+	"context"
+	"github.com/JeffersonQin/syncat/internal/client"
+	"github.com/JeffersonQin/syncat/internal/server"
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"github.com/JeffersonQin/syncat/pkg/database"
+	"github.com/JeffersonQin/syncat/pkg/scanner"
+	"github.com/JeffersonQin/syncat/pkg/syncnet"
+	"golang.org/x/exp/slog"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Root is the name of the sync root shared by the server and the clients
+const Root = "sync"
+
+// token is the token the clients authenticate with
+const token = "harness"
+
+// Harness is a server and its clients running in one process, each with its own sync root and database
+// in a temporary directory
+// The clients do not run the daemon, they are synced explicitly, so that the scenarios are deterministic,
+// while the daemons created by NewDaemon sync on their own like a deployed client
+type Harness struct {
+	t testing.TB
+	// Server is the side of the server
+	Server *Side
+	// Clients are the sides of the clients
+	Clients  []*Client
+	server   *server.Server
+	listener net.Listener
+	// dir is the temporary directory of all the sides, and options are the options of the harness
+	dir     string
+	options Options
+}
+
+// Side is the sync root and database of the server or a client
+type Side struct {
+	t testing.TB
+	// Name is the name of the side, server or client1, client2, ...
+	Name string
+	// Dir is the directory of the sync root
+	Dir string
+	// Store is the database of the side
+	Store *database.Store
+	// Config is the shared configuration of the side
+	Config config.SyncatConfig
+}
+
+// Client is a client connected to the server of the harness
+type Client struct {
+	*Side
+	h    *Harness
+	conn *syncnet.IdleTimeoutConn
+}
+
+// Daemon is a client running the daemon against the server of the harness
+type Daemon struct {
+	*Side
+	// Socket is the control socket of the daemon
+	Socket string
+	// config is the client configuration of the daemon
+	config client.SyncatClientConfig
+}
+
+// Options are the options of the harness
+type Options struct {
+	// Clients is the number of clients
+	Clients int
+	// BufferSize is the size of file chunks, 1024 if not positive, so that small files span several chunks
+	BufferSize int
+	// DisableCompression disables compressing file chunks on all the sides
+	DisableCompression bool
+}
+
+// New Start a server with the clients connected to it, which are all closed when the test ends
+func New(t testing.TB, clients int) *Harness {
+	return NewWithOptions(t, Options{Clients: clients})
+}
+
+// NewWithOptions Start a server with the clients connected to it, configured by the options
+func NewWithOptions(t testing.TB, options Options) *Harness {
+	t.Helper()
+	if !testing.Verbose() {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard)))
+	}
+	if options.BufferSize <= 0 {
+		options.BufferSize = 1024
+	}
+	dir := t.TempDir()
+	h := &Harness{t: t, dir: dir, options: options}
+	h.Server = newSide(t, dir, "server", database.ServerRole, options)
+	t.Cleanup(func() {
+		_ = h.Server.Store.Close()
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	h.listener = listener
+	h.server, err = server.NewServer(h.Server.Store, h.Server.Config, server.SyncatServerConfig{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	go func() {
+		_ = h.server.Serve(listener)
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+		h.server.Wait()
+	})
+	for i := 1; i <= options.Clients; i++ {
+		side := newSide(t, dir, "client"+strconv.Itoa(i), database.ClientRole, options)
+		c := &Client{Side: side, h: h}
+		t.Cleanup(func() {
+			c.Disconnect()
+			_ = c.Store.Close()
+		})
+		c.Connect()
+		h.Clients = append(h.Clients, c)
+	}
+	return h
+}
+
+// NewDaemon Create a client to run the daemon against the server by Start
+func (h *Harness) NewDaemon(name string) *Daemon {
+	h.t.Helper()
+	side := newSide(h.t, h.dir, name, database.ClientRole, h.options)
+	h.t.Cleanup(func() {
+		_ = side.Store.Close()
+	})
+	_, port, err := net.SplitHostPort(h.listener.Addr().String())
+	if err != nil {
+		h.t.Fatalf("failed to get port of server: %v", err)
+	}
+	clientConfig := client.SyncatClientConfig{
+		DeviceName:        name,
+		Host:              "127.0.0.1",
+		ScanInterval:      1,
+		SyncInterval:      1,
+		ReconnectDelay:    1,
+		MaxReconnectDelay: 1,
+		ControlSocket:     filepath.Join(filepath.Dir(side.Dir), "control.sock"),
+	}
+	clientConfig.Port, err = strconv.Atoi(port)
+	if err != nil {
+		h.t.Fatalf("failed to parse port of server: %v", err)
+	}
+	return &Daemon{Side: side, Socket: clientConfig.ControlSocket, config: clientConfig}
+}
+
+// Start Run the daemon, which scans and syncs every second, until the test ends
+func (d *Daemon) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		daemon, err := client.NewDaemon(d.Store, d.Config, d.config)
+		if err != nil {
+			stopped <- err
+			return
+		}
+		stopped <- daemon.Run(ctx, nil)
+	}()
+	d.t.Cleanup(func() {
+		cancel()
+		if err := <-stopped; err != nil {
+			d.t.Errorf("daemon %s failed: %v", d.Name, err)
+		}
+	})
+}
+
+// Status Get the status of the daemon from its control socket, nil if the control socket is not listening yet
+func (d *Daemon) Status() *client.Status {
+	resp, err := client.Control(d.Socket, client.ControlRequest{Command: client.CommandStatus})
+	if err != nil {
+		return nil
+	}
+	return resp.Status
+}
+
+// WaitSynced Wait until the daemon reports that the sync root is synced without error after the time,
+// failing the test if it does not within the timeout
+func (d *Daemon) WaitSynced(after time.Time, timeout time.Duration) client.RootStatus {
+	d.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		if status := d.Status(); status != nil && status.Connected {
+			for _, s := range status.Roots {
+				if s.Root == Root && s.LastSync != nil && s.LastSync.After(after) && s.LastError == "" {
+					return s
+				}
+			}
+		}
+		if time.Now().After(deadline) {
+			d.t.Fatalf("%s has not synced within %v, status: %+v", d.Name, timeout, d.Status())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// AssertConvergedWith Assert that the side has the same files and directories as the server
+func (h *Harness) AssertConvergedWith(side *Side) {
+	h.t.Helper()
+	if diff := diffFiles(h.Server.Files(), side.Files()); diff != "" {
+		h.t.Fatalf("%s has not converged with server:\n%s", side.Name, diff)
+	}
+}
+
+// newSide Create the sync root and database of a side under the directory
+func newSide(t testing.TB, dir string, name string, role database.Role, options Options) *Side {
+	t.Helper()
+	home := filepath.Join(dir, name)
+	sideConfig := config.SyncatConfig{
+		Db:   config.SyncatDBConfig{Filename: filepath.Join(home, "syncat.db")},
+		Sync: config.SyncatSyncConfig{Directories: []string{filepath.Join(home, Root)}},
+		Protocol: config.SyncatProtocolConfig{
+			BufferSize:         options.BufferSize,
+			Timeout:            10,
+			DisableCompression: options.DisableCompression,
+		},
+		Auth: config.SyncatAuthConfig{Token: token},
+	}
+	err := os.MkdirAll(sideConfig.Sync.Directories[0], os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to create sync root of %s: %v", name, err)
+	}
+	store, err := database.LoadDatabase(sideConfig.Db, role)
+	if err != nil {
+		t.Fatalf("failed to open database of %s: %v", name, err)
+	}
+	return &Side{
+		t:      t,
+		Name:   name,
+		Dir:    sideConfig.Sync.Directories[0],
+		Store:  store,
+		Config: sideConfig,
+	}
+}
+
+// Connect Connect the client to the server and authenticate, the client is registered on its first connection
+func (c *Client) Connect() {
+	c.t.Helper()
+	err := c.TryConnect(nil)
+	if err != nil {
+		c.t.Fatalf("%s failed to connect: %v", c.Name, err)
+	}
+}
+
+// TryConnect Connect the client to the server through the connection returned by wrap, and authenticate
+// wrap is given the connection dialed, so that faults can be injected into it, and is skipped if nil
+// The client is left disconnected if it fails
+func (c *Client) TryConnect(wrap func(net.Conn) net.Conn) error {
+	c.Disconnect()
+	timeout := time.Duration(c.Config.Protocol.Timeout) * time.Second
+	nc, err := net.DialTimeout("tcp", c.h.listener.Addr().String(), timeout)
+	if err != nil {
+		return err
+	}
+	if wrap != nil {
+		nc = wrap(nc)
+	}
+	conn := &syncnet.IdleTimeoutConn{
+		Conn:        nc,
+		IdleTimeout: timeout,
+		Config:      config.NewHolder(c.Config),
+	}
+	conn.StartStreams(true)
+	err = authenticate(conn, c.Store, c.Config, c.Name)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	c.conn = conn
+	return nil
+}
+
+// authenticate Send AUTH request on the connection and handle the REPLY of the server
+func authenticate(conn *syncnet.IdleTimeoutConn, store *database.Store, sharedConfig config.SyncatConfig,
+	deviceName string) error {
+	auth, err := syncnet.NewSyncatAuthRequest(store, sharedConfig, deviceName)
+	if err != nil {
+		return err
+	}
+	err = auth.Send(conn.Control())
+	if err != nil {
+		return err
+	}
+	req, err := syncnet.Wait(conn.Control(), []syncnet.PacketType{syncnet.REPLY})
+	if err != nil {
+		return err
+	}
+	return req.Handle(conn.Control(), store)
+}
+
+// Disconnect Say BYE to the server and close the connection, nothing is done if not connected
+func (c *Client) Disconnect() {
+	if c.conn == nil {
+		return
+	}
+	_ = syncnet.NewSyncatByeRequest().Send(c.conn.Control())
+	_ = c.conn.Close()
+	c.conn = nil
+}
+
+// Conn Get the connection of the client to the server
+func (c *Client) Conn() *syncnet.IdleTimeoutConn {
+	return c.conn
+}
+
+// TrySync Run a sync session of the sync root, and return its result
+func (c *Client) TrySync() (syncnet.SyncStats, error) {
+	return syncnet.RunSyncSession(c.conn, c.Store, Root)
+}
+
+// Sync Run a sync session of the sync root, failing the test if it fails
+func (c *Client) Sync() syncnet.SyncStats {
+	c.t.Helper()
+	stats, err := c.TrySync()
+	if err != nil {
+		c.t.Fatalf("%s failed to sync: %v", c.Name, err)
+	}
+	return stats
+}
+
+// SyncAll Sync every client once, in order
+func (h *Harness) SyncAll() {
+	h.t.Helper()
+	for _, c := range h.Clients {
+		c.Sync()
+	}
+}
+
+// Converge Sync every client twice, so that the changes uploaded by the later clients reach the earlier ones,
+// and assert that all the sides end up with the same files
+func (h *Harness) Converge() {
+	h.t.Helper()
+	h.SyncAll()
+	h.SyncAll()
+	h.AssertConverged()
+}
+
+// AssertConverged Assert that the server and all the clients have the same files and directories
+func (h *Harness) AssertConverged() {
+	h.t.Helper()
+	want := h.Server.Files()
+	for _, c := range h.Clients {
+		got := c.Files()
+		if diff := diffFiles(want, got); diff != "" {
+			h.t.Fatalf("%s has not converged with server:\n%s", c.Name, diff)
+		}
+	}
+}
+
+// diffFiles Describe the differences between the files of the server and a client, empty if the same
+func diffFiles(server map[string]string, client map[string]string) string {
+	var lines []string
+	for path, content := range server {
+		other, ok := client[path]
+		if !ok {
+			lines = append(lines, "  missing on client: "+path)
+		} else if other != content {
+			lines = append(lines, "  different content: "+path)
+		}
+	}
+	for path := range client {
+		if _, ok := server[path]; !ok {
+			lines = append(lines, "  missing on server: "+path)
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// path Get the local path of a path relative to the sync root, with slash as separator
+func (s *Side) path(path string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(path))
+}
+
+// WriteFile Create or overwrite the file with the content, creating its parent directories
+func (s *Side) WriteFile(path string, content string) {
+	s.t.Helper()
+	local := s.path(path)
+	err := os.MkdirAll(filepath.Dir(local), os.ModePerm)
+	if err == nil {
+		err = os.WriteFile(local, []byte(content), 0666)
+	}
+	if err != nil {
+		s.t.Fatalf("failed to write %s on %s: %v", path, s.Name, err)
+	}
+}
+
+// ReadFile Get the content of the file, failing the test if it cannot be read
+func (s *Side) ReadFile(path string) string {
+	s.t.Helper()
+	data, err := os.ReadFile(s.path(path))
+	if err != nil {
+		s.t.Fatalf("failed to read %s on %s: %v", path, s.Name, err)
+	}
+	return string(data)
+}
+
+// Mkdir Create the directory with its parents
+func (s *Side) Mkdir(path string) {
+	s.t.Helper()
+	err := os.MkdirAll(s.path(path), os.ModePerm)
+	if err != nil {
+		s.t.Fatalf("failed to create %s on %s: %v", path, s.Name, err)
+	}
+}
+
+// Remove Delete the file or the directory with all its files
+func (s *Side) Remove(path string) {
+	s.t.Helper()
+	err := os.RemoveAll(s.path(path))
+	if err != nil {
+		s.t.Fatalf("failed to remove %s on %s: %v", path, s.Name, err)
+	}
+}
+
+// Rename Move the file or directory, creating the parent directories of the destination
+func (s *Side) Rename(from string, to string) {
+	s.t.Helper()
+	err := os.MkdirAll(filepath.Dir(s.path(to)), os.ModePerm)
+	if err == nil {
+		err = os.Rename(s.path(from), s.path(to))
+	}
+	if err != nil {
+		s.t.Fatalf("failed to rename %s to %s on %s: %v", from, to, s.Name, err)
+	}
+}
+
+// Exists Check whether the file or directory exists
+func (s *Side) Exists(path string) bool {
+	_, err := os.Stat(s.path(path))
+	return err == nil
+}
+
+// Files Get the files and directories in the sync root, by their paths relative to the sync root
+// The value is the content of a file, and a directory has its path suffixed with a slash and an empty value
+// The temporary files of syncat are left out
+func (s *Side) Files() map[string]string {
+	s.t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(s.Dir, func(local string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if local == s.Dir {
+			return nil
+		}
+		if scanner.Ignored(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Dir, local)
+		if err != nil {
+			return err
+		}
+		path := filepath.ToSlash(rel)
+		if d.IsDir() {
+			files[path+"/"] = ""
+			return nil
+		}
+		data, err := os.ReadFile(local)
+		if err != nil {
+			return err
+		}
+		files[path] = string(data)
+		return nil
+	})
+	if err != nil {
+		s.t.Fatalf("failed to list files on %s: %v", s.Name, err)
+	}
+	return files
+}
@@ -0,0 +1,32 @@
+package integration
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUploadNotifiesOtherClients(t *testing.T) {
+	h := New(t, 2)
+	uploader, other := h.Clients[0], h.Clients[1]
+	uploader.WriteFile("a.txt", "hello")
+	uploader.Sync()
+	select {
+	case <-other.Conn().Notified():
+	case <-time.After(5 * time.Second):
+		t.Fatal("other client is not notified of the upload")
+	}
+	if roots := other.Conn().TakeNotifiedRoots(); len(roots) != 1 || roots[0] != Root {
+		t.Fatalf("notified roots = %v, want [%s]", roots, Root)
+	}
+	// the notifications are sent together, so the uploader would have been notified by now
+	select {
+	case <-uploader.Conn().Notified():
+		t.Fatal("uploader is notified of its own upload")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if roots := uploader.Conn().TakeNotifiedRoots(); len(roots) != 0 {
+		t.Fatalf("uploader notified roots = %v, want none", roots)
+	}
+	other.Sync()
+	h.AssertConverged()
+}
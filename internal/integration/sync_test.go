@@ -0,0 +1,195 @@
+package integration
+
+import (
+	"errors"
+	"github.com/JeffersonQin/syncat/pkg/syncnet"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestCreatePropagates(t *testing.T) {
+	h := New(t, 2)
+	h.Clients[0].WriteFile("a.txt", "hello")
+	h.Clients[0].WriteFile("docs/b.txt", "world")
+	h.Clients[0].Mkdir("empty")
+	h.Converge()
+	if got := h.Clients[1].ReadFile("docs/b.txt"); got != "world" {
+		t.Fatalf("docs/b.txt = %q, want %q", got, "world")
+	}
+	if !h.Clients[1].Exists("empty") {
+		t.Fatal("empty directory is not synced")
+	}
+}
+
+func TestEditPropagates(t *testing.T) {
+	h := New(t, 2)
+	h.Clients[0].WriteFile("a.txt", "first")
+	h.Converge()
+	h.Clients[1].WriteFile("a.txt", "second version")
+	h.Converge()
+	if got := h.Clients[0].ReadFile("a.txt"); got != "second version" {
+		t.Fatalf("a.txt = %q, want %q", got, "second version")
+	}
+}
+
+func TestDeletePropagates(t *testing.T) {
+	h := New(t, 2)
+	h.Clients[0].WriteFile("a.txt", "a")
+	h.Clients[0].WriteFile("dir/nested/b.txt", "b")
+	h.Converge()
+	h.Clients[1].Remove("a.txt")
+	h.Clients[1].Remove("dir")
+	h.Converge()
+	for _, path := range []string{"a.txt", "dir"} {
+		if h.Clients[0].Exists(path) || h.Server.Exists(path) {
+			t.Fatalf("%s is not deleted", path)
+		}
+	}
+}
+
+func TestRenamePropagates(t *testing.T) {
+	h := New(t, 2)
+	h.Clients[0].WriteFile("old/name.txt", "content")
+	h.Converge()
+	h.Clients[0].Rename("old/name.txt", "new/name.txt")
+	h.Converge()
+	if h.Clients[1].Exists("old/name.txt") {
+		t.Fatal("old/name.txt still exists after rename")
+	}
+	if got := h.Clients[1].ReadFile("new/name.txt"); got != "content" {
+		t.Fatalf("new/name.txt = %q, want %q", got, "content")
+	}
+}
+
+func TestServerChangePropagates(t *testing.T) {
+	h := New(t, 2)
+	h.Server.WriteFile("from-server.txt", "in place")
+	h.Converge()
+	if got := h.Clients[0].ReadFile("from-server.txt"); got != "in place" {
+		t.Fatalf("from-server.txt = %q, want %q", got, "in place")
+	}
+}
+
+func TestConflictKeepsBothVersions(t *testing.T) {
+	h := New(t, 2)
+	h.Clients[0].WriteFile("a.txt", "base")
+	h.Converge()
+	h.Clients[0].WriteFile("a.txt", "edited by client1")
+	h.Clients[1].WriteFile("a.txt", "edited by client2")
+	h.Clients[0].Sync()
+	if stats := h.Clients[1].Sync(); stats.Conflicts != 1 {
+		t.Fatalf("conflicts = %d, want 1", stats.Conflicts)
+	}
+	h.Converge()
+	files := h.Clients[0].Files()
+	if files["a.txt"] != "edited by client1" {
+		t.Fatalf("a.txt = %q, want the version synced first", files["a.txt"])
+	}
+	kept := false
+	for path, content := range files {
+		if strings.HasPrefix(path, "a.conflict-") && content == "edited by client2" {
+			kept = true
+		}
+	}
+	if !kept {
+		t.Fatalf("conflicting version is not kept, files: %v", files)
+	}
+}
+
+func TestDeleteLosesToEdit(t *testing.T) {
+	h := New(t, 2)
+	h.Clients[0].WriteFile("a.txt", "base")
+	h.Converge()
+	h.Clients[0].Remove("a.txt")
+	h.Clients[1].WriteFile("a.txt", "edited")
+	h.Clients[0].Sync()
+	h.Converge()
+	if got := h.Clients[0].ReadFile("a.txt"); got != "edited" {
+		t.Fatalf("a.txt = %q, want %q", got, "edited")
+	}
+}
+
+func TestLargeFileSpansWindows(t *testing.T) {
+	for _, disable := range []bool{false, true} {
+		h := NewWithOptions(t, Options{Clients: 2, BufferSize: 32 << 10, DisableCompression: disable})
+		data := make([]byte, 1<<20)
+		rand.New(rand.NewSource(1)).Read(data)
+		h.Clients[0].WriteFile("big.bin", string(data))
+		h.Clients[0].WriteFile("text.txt", strings.Repeat("compressible line\n", 20000))
+		h.Converge()
+	}
+}
+
+func TestRootBusyDuringOtherSession(t *testing.T) {
+	h := New(t, 1)
+	if !h.Server.Store.TryLockRoot(Root) {
+		t.Fatal("failed to lock sync root")
+	}
+	_, err := h.Clients[0].TrySync()
+	var busy syncnet.ErrRootBusy
+	if !errors.As(err, &busy) {
+		t.Fatalf("err = %v, want ErrRootBusy", err)
+	}
+	h.Server.Store.UnlockRoot(Root)
+	h.Clients[0].WriteFile("a.txt", "after busy")
+	h.Converge()
+}
+
+func TestRevokedClientCannotSync(t *testing.T) {
+	h := New(t, 1)
+	uuid, err := h.Clients[0].Store.QueryClientUuid()
+	if err != nil {
+		t.Fatalf("failed to query client uuid: %v", err)
+	}
+	client, exists, err := h.Server.Store.QueryClient(uuid)
+	if err != nil || !exists {
+		t.Fatalf("client %s exists = %v, %v, want registered", uuid, exists, err)
+	}
+	err = h.Server.Store.UpdateClientRevoked(client.Id, true)
+	if err != nil {
+		t.Fatalf("failed to revoke client: %v", err)
+	}
+	h.Clients[0].WriteFile("a.txt", "after revoke")
+	_, err = h.Clients[0].TrySync()
+	if err == nil {
+		t.Fatal("revoked client synced")
+	}
+	if h.Server.Exists("a.txt") {
+		t.Fatal("file of revoked client is uploaded")
+	}
+}
+
+func TestTransferOutsideSessionRefused(t *testing.T) {
+	h := New(t, 2)
+	h.Server.WriteFile("a.txt", "on server")
+	h.Clients[1].Sync()
+	c := h.Clients[0]
+	stream, err := c.Conn().OpenStream()
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	err = syncnet.NewSyncatGetRequest(Root, "a.txt").Send(stream)
+	if err != nil {
+		t.Fatalf("failed to send GET: %v", err)
+	}
+	if err := syncnet.ReceiveFile(stream, c.Store); err == nil {
+		t.Fatal("file is sent outside a sync session")
+	}
+	if c.Exists("a.txt") {
+		t.Fatal("file sent outside a sync session is written")
+	}
+
+	c.WriteFile("b.txt", "from client")
+	stream, err = c.Conn().OpenStream()
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	if _, err := syncnet.SendFile(stream, c.Store, Root, "b.txt", ""); err == nil {
+		t.Fatal("file is uploaded outside a sync session")
+	}
+	if h.Server.Exists("b.txt") {
+		t.Fatal("file uploaded outside a sync session is written")
+	}
+	h.Converge()
+}
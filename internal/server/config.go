@@ -1,10 +1,9 @@
 package server
 
 import (
-	"gopkg.in/yaml.v3"
-	"io"
-	"os"
-	"path/filepath"
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"net"
+	"sync/atomic"
 )
 
 // SyncatServerConfig is the configuration for the Syncat server
@@ -13,40 +12,126 @@ type SyncatServerConfig struct {
 	Port int `yaml:"port"`
 	// Host for server
 	Host string `yaml:"host"`
+	// Socket is the Unix domain socket listened in addition to TCP, for the clients on the same machine
+	// It is not listened if empty
+	Socket string `yaml:"socket"`
+	// MetricsAddress is the address of the HTTP listener serving the metrics, e.g. 127.0.0.1:9487
+	// The metrics are not served if it is empty
+	MetricsAddress string `yaml:"metrics_address"`
+	// MaxConnections is the maximum number of concurrent connections, unlimited if not positive
+	MaxConnections int `yaml:"max_connections"`
+	// AuthLockout is the configuration for locking out the addresses failing to authenticate
+	AuthLockout SyncatAuthLockoutConfig `yaml:"auth_lockout"`
+	// ClientBandwidth is the maximum bytes per second received from or sent to each client, unlimited if not positive
+	ClientBandwidth int `yaml:"client_bandwidth"`
 }
 
-var serverConfig SyncatServerConfig
+// SyncatAuthLockoutConfig is the configuration for locking out the addresses failing to authenticate
+type SyncatAuthLockoutConfig struct {
+	// MaxFailures is the number of failures in a row allowed before locking out, 5 if not positive
+	MaxFailures int `yaml:"max_failures"`
+	// Duration is the seconds of the first lockout, doubled for every further failure, 60 if not positive
+	Duration int `yaml:"duration"`
+	// MaxDuration is the maximum seconds of a lockout, 3600 if not positive
+	MaxDuration int `yaml:"max_duration"`
+}
 
-func LoadConfig() error {
-	// Obtain the executable path
-	ex, err := os.Executable()
-	if err != nil {
-		return err
-	}
-	exPath := filepath.Dir(ex)
-	// Obtain config file path
-	configPath := filepath.Join(exPath, "../config/server_config.yml")
-	// Open config file
-	configFile, err := os.Open(configPath)
+// serverConfig is the loaded server configuration, replaced as a whole by LoadConfig and SetConfig
+var serverConfig atomic.Pointer[SyncatServerConfig]
+
+// EnvPrefix is the prefix of the environment variables overriding the server configuration, e.g. SYNCAT_SERVER_PORT
+const EnvPrefix = config.EnvPrefix + "SERVER_"
+
+// LoadConfig Load the server configuration from the config file, overridden by the environment variables of EnvPrefix
+// Relative paths are resolved against the directory given by config.BaseDir
+func LoadConfig(path string) error {
+	loaded, err := ReadConfig(path)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		_ = configFile.Close()
-	}()
-	// Read config file
-	configBytes, err := io.ReadAll(configFile)
+	SetConfig(loaded)
+	return nil
+}
+
+// ReadConfig Read the server configuration from the config file like LoadConfig, without replacing the loaded one
+func ReadConfig(path string) (SyncatServerConfig, error) {
+	var loaded SyncatServerConfig
+	err := config.LoadFile(path, &loaded)
 	if err != nil {
-		return err
+		return SyncatServerConfig{}, err
 	}
-	// Unmarshal config file
-	err = yaml.Unmarshal(configBytes, &serverConfig)
+	err = config.ApplyEnv(EnvPrefix, &loaded)
 	if err != nil {
-		return err
+		return SyncatServerConfig{}, err
 	}
-	return nil
+	base := config.BaseDir(path)
+	loaded.Socket = config.ResolvePath(base, loaded.Socket)
+	return loaded, nil
 }
 
+// GetConfig Get a snapshot of the loaded server configuration, which is consistent even if it is reloaded concurrently
 func GetConfig() SyncatServerConfig {
-	return serverConfig
+	if c := serverConfig.Load(); c != nil {
+		return *c
+	}
+	return SyncatServerConfig{}
+}
+
+// SetConfig Replace the loaded server configuration, e.g. by the one applied on reload
+func SetConfig(c SyncatServerConfig) {
+	serverConfig.Store(&c)
+}
+
+// Validate Check the server configuration, and return config.ErrInvalidConfig with all the problems found
+func (c SyncatServerConfig) Validate() error {
+	var p config.Problems
+	p.CheckPort("port", c.Port)
+	if c.MetricsAddress != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddress); err != nil {
+			p.Add("metrics_address", "must be host:port, e.g. 127.0.0.1:9487, got %s", c.MetricsAddress)
+		}
+	}
+	if c.ClientBandwidth < 0 {
+		p.Add("client_bandwidth", "must not be negative, 0 for unlimited")
+	}
+	lockout := c.AuthLockout
+	if lockout.Duration > 0 && lockout.MaxDuration > 0 && lockout.MaxDuration < lockout.Duration {
+		p.Add("auth_lockout.max_duration", "must not be less than auth_lockout.duration (%d)", lockout.Duration)
+	}
+	return p.Err()
+}
+
+// reloadConfig Get the server configuration applied by reloading the current one with the loaded one,
+// and record the changes
+// The listeners and max_connections keep their current values until restarting,
+// while client_bandwidth and auth_lockout are applied live
+func reloadConfig(current SyncatServerConfig, loaded SyncatServerConfig, changes *config.Changes) SyncatServerConfig {
+	applied := loaded
+	if loaded.Host != current.Host {
+		changes.RequireRestart("host")
+		applied.Host = current.Host
+	}
+	if loaded.Port != current.Port {
+		changes.RequireRestart("port")
+		applied.Port = current.Port
+	}
+	if loaded.Socket != current.Socket {
+		changes.RequireRestart("socket")
+		applied.Socket = current.Socket
+	}
+	if loaded.MetricsAddress != current.MetricsAddress {
+		changes.RequireRestart("metrics_address")
+		applied.MetricsAddress = current.MetricsAddress
+	}
+	if loaded.MaxConnections != current.MaxConnections {
+		changes.RequireRestart("max_connections")
+		applied.MaxConnections = current.MaxConnections
+	}
+	if loaded.AuthLockout != current.AuthLockout {
+		changes.Apply("auth_lockout")
+	}
+	if loaded.ClientBandwidth != current.ClientBandwidth {
+		changes.Apply("client_bandwidth")
+	}
+	return applied
 }
@@ -0,0 +1,13 @@
+package server
+
+import "fmt"
+
+// ErrSocketInUse is returned when another server is listening on the Unix socket
+type ErrSocketInUse struct {
+	socket string
+}
+
+// Error returns the error message
+func (e ErrSocketInUse) Error() string {
+	return fmt.Sprintf("another server is running on %s", e.socket)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// lockoutState is the authentication failures of an address
+type lockoutState struct {
+	// failures is the number of failures in a row
+	failures int
+	// until is the time the lockout ends, zero if not locked out
+	until time.Time
+	// last is the time of the last failure
+	last time.Time
+}
+
+// lockout locks out the addresses failing to authenticate repeatedly, so that the token cannot be brute-forced
+// An address is locked out after maxFailures failures in a row, for a duration doubled on every further failure
+type lockout struct {
+	mu          sync.Mutex
+	maxFailures int
+	duration    time.Duration
+	maxDuration time.Duration
+	states      map[string]*lockoutState
+}
+
+// newLockout Create a lockout with the configuration, using the defaults for the values not configured
+func newLockout(lockoutConfig SyncatAuthLockoutConfig) *lockout {
+	l := &lockout{states: make(map[string]*lockoutState)}
+	l.configure(lockoutConfig)
+	return l
+}
+
+// configure Apply the configuration, using the defaults for the values not configured
+// The addresses locked out already stay locked out until their lockouts end
+func (l *lockout) configure(lockoutConfig SyncatAuthLockoutConfig) {
+	maxFailures := lockoutConfig.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = 5
+	}
+	duration := seconds(lockoutConfig.Duration, 60)
+	maxDuration := seconds(lockoutConfig.MaxDuration, 3600)
+	if maxDuration < duration {
+		maxDuration = duration
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maxFailures, l.duration, l.maxDuration = maxFailures, duration, maxDuration
+}
+
+// seconds Convert the configured seconds to duration, using the default value if not configured
+func seconds(value int, defaultValue int) time.Duration {
+	if value <= 0 {
+		value = defaultValue
+	}
+	return time.Duration(value) * time.Second
+}
+
+// lockedUntil Get the time the lockout of the address ends, zero if the address is allowed to connect
+func (l *lockout) lockedUntil(addr string) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune()
+	state, ok := l.states[addr]
+	if !ok || time.Now().After(state.until) {
+		return time.Time{}
+	}
+	return state.until
+}
+
+// fail Record an authentication failure of the address, and lock it out if it fails too often
+func (l *lockout) fail(addr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state, ok := l.states[addr]
+	if !ok {
+		state = &lockoutState{}
+		l.states[addr] = state
+	}
+	now := time.Now()
+	state.failures++
+	state.last = now
+	if state.failures < l.maxFailures {
+		return
+	}
+	d := l.duration
+	for i := l.maxFailures; i < state.failures && d < l.maxDuration; i++ {
+		d *= 2
+	}
+	if d > l.maxDuration {
+		d = l.maxDuration
+	}
+	state.until = now.Add(d)
+}
+
+// succeed Forget the failures of the address after it authenticates
+func (l *lockout) succeed(addr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.states, addr)
+}
+
+// prune Forget the addresses not failing for longer than the maximum lockout, so that the states do not grow forever
+// The caller must hold l.mu
+func (l *lockout) prune() {
+	now := time.Now()
+	for addr, state := range l.states {
+		if now.Sub(state.last) > l.maxDuration && now.After(state.until) {
+			delete(l.states, addr)
+		}
+	}
+}
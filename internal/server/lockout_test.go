@@ -0,0 +1,176 @@
+package server
+
+import (
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"github.com/JeffersonQin/syncat/pkg/database"
+	"github.com/JeffersonQin/syncat/pkg/syncnet"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// assertLockedFor Assert that the address is locked out for about the duration, or allowed if it is zero
+func assertLockedFor(t *testing.T, l *lockout, addr string, d time.Duration) {
+	t.Helper()
+	until := l.lockedUntil(addr)
+	if d == 0 {
+		if !until.IsZero() {
+			t.Fatalf("%s locked out until %v, want allowed", addr, until)
+		}
+		return
+	}
+	left := time.Until(until)
+	if left > d || left < d-time.Minute {
+		t.Fatalf("%s locked out for %v, want %v", addr, left, d)
+	}
+}
+
+// TestLockoutThreshold An address is locked out after the maximum failures in a row, and not before
+func TestLockoutThreshold(t *testing.T) {
+	l := newLockout(SyncatAuthLockoutConfig{MaxFailures: 3, Duration: 600, MaxDuration: 6000})
+	for i := 0; i < 2; i++ {
+		l.fail("10.0.0.1")
+	}
+	assertLockedFor(t, l, "10.0.0.1", 0)
+	l.fail("10.0.0.1")
+	assertLockedFor(t, l, "10.0.0.1", 10*time.Minute)
+	assertLockedFor(t, l, "10.0.0.2", 0)
+
+	// a success forgets the failures
+	l.fail("10.0.0.2")
+	l.fail("10.0.0.2")
+	l.succeed("10.0.0.2")
+	l.fail("10.0.0.2")
+	assertLockedFor(t, l, "10.0.0.2", 0)
+}
+
+// TestLockoutDoubling The lockout is doubled on every further failure, up to the maximum duration
+func TestLockoutDoubling(t *testing.T) {
+	l := newLockout(SyncatAuthLockoutConfig{MaxFailures: 1, Duration: 600, MaxDuration: 3000})
+	for _, d := range []time.Duration{10, 20, 40, 50, 50} {
+		l.fail("10.0.0.1")
+		assertLockedFor(t, l, "10.0.0.1", d*time.Minute)
+	}
+}
+
+// TestLockoutDefaults The values not configured take the defaults, and the maximum is at least the first lockout
+func TestLockoutDefaults(t *testing.T) {
+	l := newLockout(SyncatAuthLockoutConfig{})
+	if l.maxFailures != 5 || l.duration != time.Minute || l.maxDuration != time.Hour {
+		t.Fatalf("defaults = %d, %v, %v, want 5, 1m, 1h", l.maxFailures, l.duration, l.maxDuration)
+	}
+	l.configure(SyncatAuthLockoutConfig{Duration: 7200})
+	if l.maxDuration != 2*time.Hour {
+		t.Fatalf("max duration = %v, want raised to the duration", l.maxDuration)
+	}
+}
+
+// TestLockoutPrune The addresses not failing for longer than the maximum lockout are forgotten
+func TestLockoutPrune(t *testing.T) {
+	l := newLockout(SyncatAuthLockoutConfig{MaxFailures: 1, Duration: 60, MaxDuration: 60})
+	l.fail("10.0.0.1")
+	l.fail("10.0.0.2")
+	l.fail("10.0.0.3")
+	past := time.Now().Add(-2 * time.Minute)
+	// 10.0.0.1 failed long ago and its lockout has ended
+	l.states["10.0.0.1"].last, l.states["10.0.0.1"].until = past, past
+	// 10.0.0.2 failed long ago but is still locked out
+	l.states["10.0.0.2"].last = past
+	assertLockedFor(t, l, "10.0.0.3", time.Minute)
+	if _, ok := l.states["10.0.0.1"]; ok {
+		t.Fatalf("address with an ended lockout is kept")
+	}
+	for _, addr := range []string{"10.0.0.2", "10.0.0.3"} {
+		if _, ok := l.states[addr]; !ok {
+			t.Fatalf("address %s locked out is pruned", addr)
+		}
+	}
+}
+
+// acceptListener is the listener signalling every connection accepted
+type acceptListener struct {
+	net.Listener
+	accepted chan struct{}
+}
+
+// Accept waits for the next connection, and signals it once accepted
+func (l *acceptListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted <- struct{}{}
+	}
+	return c, err
+}
+
+// TestLockoutConcurrentConnections The connections accepted before their address is locked out cannot authenticate
+// once it is, so that opening many connections first does not allow more guesses
+func TestLockoutConcurrentConnections(t *testing.T) {
+	dir := t.TempDir()
+	open := func(name string, role database.Role) *database.Store {
+		store, err := database.LoadDatabase(config.SyncatDBConfig{Filename: filepath.Join(dir, name)}, role)
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		t.Cleanup(func() {
+			_ = store.Close()
+		})
+		return store
+	}
+	serverStore, clientStore := open("server.db", database.ServerRole), open("client.db", database.ClientRole)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	accepted := &acceptListener{Listener: listener, accepted: make(chan struct{}, 5)}
+	sharedConfig := config.SyncatConfig{
+		Protocol: config.SyncatProtocolConfig{Timeout: 10},
+		Auth:     config.SyncatAuthConfig{Token: "token"},
+	}
+	s, err := NewServer(serverStore, sharedConfig, SyncatServerConfig{AuthLockout: SyncatAuthLockoutConfig{MaxFailures: 1}})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	go func() {
+		_ = s.Serve(accepted)
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+		s.Wait()
+	})
+
+	// all the connections are accepted before the first failure
+	conns := make([]*syncnet.IdleTimeoutConn, 5)
+	for i := range conns {
+		c, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		conns[i] = &syncnet.IdleTimeoutConn{Conn: c, IdleTimeout: 5 * time.Second}
+		conns[i].StartStreams(true)
+		t.Cleanup(func() {
+			_ = c.Close()
+		})
+	}
+	for range conns {
+		<-accepted.accepted
+	}
+	wrongConfig := config.SyncatConfig{Auth: config.SyncatAuthConfig{Token: "wrong"}}
+	for i, conn := range conns {
+		auth, err := syncnet.NewSyncatAuthRequest(clientStore, wrongConfig, "guesser")
+		if err != nil {
+			t.Fatalf("failed to create AUTH request: %v", err)
+		}
+		err = auth.Send(conn.Control())
+		if err != nil {
+			t.Fatalf("failed to send AUTH: %v", err)
+		}
+		_, err = syncnet.Wait(conn.Control(), []syncnet.PacketType{syncnet.REPLY})
+		if i == 0 && err != nil {
+			t.Fatalf("first guess is not replied: %v", err)
+		}
+		if i > 0 && err == nil {
+			t.Fatalf("guess %d is replied after the address is locked out", i+1)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"errors"
+	"github.com/JeffersonQin/syncat/pkg/metrics"
+	"golang.org/x/exp/slog"
+	"net"
+	"net/http"
+)
+
+// startMetricsServer Listen on the address, and serve the metrics in Prometheus text format at /metrics until stop is
+// called, which closes the listener
+// The listener is opened before returning, so that a wrong address fails the start of the server
+func startMetricsServer(addr string) (stop func(), err error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		err := metrics.Write(w)
+		if err != nil {
+			slog.Error("Failed to write metrics", err)
+		}
+	})
+	server := &http.Server{Handler: mux}
+	go func() {
+		err := server.Serve(listener)
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server stopped", err)
+		}
+	}()
+	slog.Info("Metrics served", "url", "http://"+listener.Addr().String()+"/metrics")
+	return func() {
+		_ = server.Close()
+	}, nil
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestMetricsServerStop The metrics are served until the metrics server is stopped, which frees its address
+func TestMetricsServerStop(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+	stop, err := startMetricsServer(addr)
+	if err != nil {
+		t.Fatalf("failed to start metrics server: %v", err)
+	}
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		stop()
+		t.Fatalf("failed to get metrics: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil || !strings.Contains(string(body), "# TYPE ") {
+		stop()
+		t.Fatalf("metrics = %q, %v, want Prometheus text format", body, err)
+	}
+	stop()
+	listener, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("address of stopped metrics server is still in use: %v", err)
+	}
+	_ = listener.Close()
+}
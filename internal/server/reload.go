@@ -0,0 +1,82 @@
+package server
+
+import (
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"github.com/JeffersonQin/syncat/pkg/logging"
+	"github.com/JeffersonQin/syncat/pkg/syncnet"
+	"golang.org/x/exp/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Loader reads and validates the shared and the server config files again, for reloading them
+type Loader func() (config.SyncatConfig, SyncatServerConfig, error)
+
+// Reload Apply the reloaded configurations to the server and its open connections, and get the changes
+// The settings safe to change live are applied, and the others keep their current values until restarting,
+// see config.Reload and reloadConfig
+// The applied configurations are published to config.GetConfig and GetConfig as well
+// The configurations are expected to be validated, an error is returned otherwise if the bandwidth limits or
+// the log level are invalid, in which case nothing is applied
+func (s *Server) Reload(sharedConfig config.SyncatConfig, serverConfig SyncatServerConfig) (config.Changes, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	shared, changes := config.Reload(s.shared.Get(), sharedConfig)
+	applied := reloadConfig(*s.config.Load(), serverConfig, &changes)
+	limits, err := syncnet.ParseBandwidth(shared.Protocol.Bandwidth)
+	if err != nil {
+		return config.Changes{}, err
+	}
+	level, err := logging.ParseLevel(shared.Log.Level)
+	if err != nil {
+		return config.Changes{}, err
+	}
+	s.bandwidth.Set(limits)
+	logging.SetLevel(level)
+	s.shared.Set(shared)
+	s.config.Store(&applied)
+	s.locks.configure(applied.AuthLockout)
+	s.active.setBandwidth(applied.ClientBandwidth)
+	config.SetConfig(shared)
+	SetConfig(applied)
+	return changes, nil
+}
+
+// reloadOnHangup Reload the configurations read by load whenever SIGHUP is received, until stop is called
+// The current configurations are kept if they fail to load
+func (s *Server) reloadOnHangup(load Loader) (stop func()) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-hangup:
+				s.reload(load)
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(hangup)
+		close(done)
+	}
+}
+
+// reload Reload the configurations read by load, and log the changes
+func (s *Server) reload(load Loader) {
+	slog.Info("Reloading config...")
+	sharedConfig, serverConfig, err := load()
+	if err != nil {
+		slog.Error("failed to reload config, keeping the current one.", err)
+		return
+	}
+	changes, err := s.Reload(sharedConfig, serverConfig)
+	if err != nil {
+		slog.Error("failed to reload config, keeping the current one.", err)
+		return
+	}
+	slog.Info("Config reloaded", "applied", changes.Applied, "restart_required", changes.RestartRequired)
+}
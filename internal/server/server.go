@@ -1,39 +1,185 @@
 package server
 
 import (
+	"errors"
 	"github.com/JeffersonQin/syncat/pkg/config"
+	"github.com/JeffersonQin/syncat/pkg/database"
+	"github.com/JeffersonQin/syncat/pkg/metrics"
 	"github.com/JeffersonQin/syncat/pkg/syncnet"
-	"log"
+	"golang.org/x/exp/slog"
 	"net"
+	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-func handleConnection(conn *syncnet.IdleTimeoutConn) {
+// Server serves the clients connecting on its listeners with the database
+type Server struct {
+	store *database.Store
+	// shared holds the configuration shared with the clients, and config is the configuration of the server,
+	// both replaced by Reload
+	shared *config.Holder
+	config atomic.Pointer[SyncatServerConfig]
+	// reloadMu serializes the reloads
+	reloadMu sync.Mutex
+	active   *sessions
+	locks    *lockout
+	// bandwidth limits the transfers of all the connections together, replaced on reload
+	bandwidth *syncnet.Bandwidth
+	// slots are taken by the open connections, unlimited if nil
+	slots chan struct{}
+	// conns are the goroutines serving the connections
+	conns sync.WaitGroup
+}
+
+// NewServer Create a server serving the clients with the database and the configurations
+// The host, port, and socket of the server configuration are not used, the listeners are given to Serve instead
+// An error is returned if the bandwidth limits are invalid
+func NewServer(store *database.Store, sharedConfig config.SyncatConfig,
+	serverConfig SyncatServerConfig) (*Server, error) {
+	bandwidth, err := syncnet.NewBandwidth(sharedConfig.Protocol.Bandwidth)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		store:     store,
+		shared:    config.NewHolder(sharedConfig),
+		active:    newSessions(),
+		locks:     newLockout(serverConfig.AuthLockout),
+		bandwidth: bandwidth,
+	}
+	s.config.Store(&serverConfig)
+	if serverConfig.MaxConnections > 0 {
+		s.slots = make(chan struct{}, serverConfig.MaxConnections)
+	}
+	return s, nil
+}
+
+// Serve Accept the connections on the listener and serve each of them in its own goroutine,
+// until the listener is closed
+// The listener can be of any transport, e.g. TCP, Unix sockets, or in-memory listeners in tests
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		serverConfig := s.config.Load()
+		// the idle timeout follows the shared configuration, and the limiters are changed on reload
+		conn := &syncnet.IdleTimeoutConn{
+			Conn:         c,
+			Config:       s.shared,
+			Bandwidth:    s.bandwidth,
+			ReadLimiter:  newLimiter(serverConfig.ClientBandwidth),
+			WriteLimiter: newLimiter(serverConfig.ClientBandwidth),
+		}
+		if host, ok := remoteHost(conn); ok {
+			if until := s.locks.lockedUntil(host); !until.IsZero() {
+				reject(conn, "lockout", "until", until)
+				continue
+			}
+		}
+		if s.slots != nil {
+			select {
+			case s.slots <- struct{}{}:
+			default:
+				reject(conn, "limit", "max_connections", serverConfig.MaxConnections)
+				continue
+			}
+		}
+		conn.Logger().Info("Connection established")
+		s.conns.Add(1)
+		go func() {
+			defer s.conns.Done()
+			s.handleConnection(conn)
+			if s.slots != nil {
+				<-s.slots
+			}
+		}()
+	}
+}
+
+// newLimiter Create a limiter of the bandwidth of a connection, unlimited if not positive
+// The limiter is created even if unlimited, so that reloading the configuration can limit it
+func newLimiter(bytesPerSecond int) *syncnet.RateLimiter {
+	l := &syncnet.RateLimiter{}
+	l.SetRate(bytesPerSecond)
+	return l
+}
+
+// Wait for the connections being served to be closed
+func (s *Server) Wait() {
+	s.conns.Wait()
+}
+
+// handleConnection Authenticate the client and serve its requests until the connection is closed
+// The previous session of the client is closed, so that every client has at most one session
+// The control stream is served here, and the streams opened by the client are served in their own goroutines
+func (s *Server) handleConnection(conn *syncnet.IdleTimeoutConn) {
+	store, active, locks := s.store, s.active, s.locks
+	metrics.ConnectionsTotal.Inc()
+	metrics.ConnectionsActive.Add(1)
+	conn.StartStreams(false)
+	control := conn.Control()
+	// streams are the goroutines serving the streams of the connection
+	var streams sync.WaitGroup
 	defer func(conn *syncnet.IdleTimeoutConn) {
+		metrics.ConnectionsActive.Add(-1)
+		active.remove(conn)
 		_ = conn.Close()
-		conn.Log("Connection closed")
+		// the streams end their sync sessions once the connection is closed
+		streams.Wait()
+		conn.Logger().Info("Connection closed")
 	}(conn)
 	// wait for AUTH
-	req, err := syncnet.Wait(conn, []syncnet.PacketType{syncnet.AUTH})
+	req, err := syncnet.Wait(control, []syncnet.PacketType{syncnet.AUTH})
 	if err != nil {
-		conn.Log("Failed to wait for auth packet", err)
+		conn.Logger().Warn("Failed to wait for auth packet", "err", err)
 		return
 	}
-	err = req.Handle(conn)
+	// the address may be locked out by the failures of its other connections since this one is accepted
+	host, lockable := remoteHost(conn)
+	if lockable {
+		if until := locks.lockedUntil(host); !until.IsZero() {
+			reject(conn, "lockout", "until", until)
+			return
+		}
+	}
+	err = req.Handle(control, store)
+	var failed syncnet.ErrAuthFailed
+	if errors.As(err, &failed) && lockable {
+		locks.fail(host)
+	}
 	if err != nil {
-		conn.Log("Failed to handle auth packet", err)
+		conn.Logger().Warn("Failed to handle auth packet", "err", err)
 		return
 	}
-	// server should wait for 3 different packets: PING, SYNC, and BYE
+	if lockable {
+		locks.succeed(host)
+	}
+	// push the changes made by the client to other clients
+	conn.OnChange = func(root string) {
+		active.notify(conn, root)
+	}
+	if previous := active.add(conn); previous != nil {
+		previous.Logger().Info("Session taken over by a new connection of the client")
+		_ = previous.Close()
+	}
+	conn.Logger().Info("Client authenticated")
+	streams.Add(1)
+	go func() {
+		defer streams.Done()
+		serveStreams(conn, store, &streams)
+	}()
+	// server should wait for 2 different packets on the control stream: PING and BYE
 	// PING for maintaining the connection in case of timeout
-	// SYNC for starting a sync session
 	// BYE for closing the connection
-	// the detailed implementations are handled in requests.go
 	for {
-		req, err = syncnet.Wait(conn, []syncnet.PacketType{syncnet.PING, syncnet.SYNC, syncnet.BYE})
+		req, err = syncnet.Wait(control, []syncnet.PacketType{syncnet.PING, syncnet.BYE})
 		if err != nil {
-			conn.Log("Failed to wait for packet", err)
+			conn.Logger().Warn("Failed to wait for packet", "err", err)
 			return
 		}
 		// if the packet is BYE, then the server should close the connection
@@ -41,36 +187,152 @@ func handleConnection(conn *syncnet.IdleTimeoutConn) {
 			break
 		}
 		// otherwise handle the packet
-		err = req.Handle(conn)
+		conn.Logger().Debug("Handling packet", "type", req.GetType().String())
+		err = req.Handle(control, store)
+		if err != nil {
+			conn.Logger().Error("Failed to handle packet", err, "type", req.GetType().String())
+			return
+		}
+	}
+}
+
+// serveStreams Serve every stream opened by the client in its own goroutine, until the connection is closed
+func serveStreams(conn *syncnet.IdleTimeoutConn, store *database.Store, streams *sync.WaitGroup) {
+	for {
+		stream, err := conn.AcceptStream()
 		if err != nil {
-			conn.Log("Failed to handle packet", err)
 			return
 		}
+		streams.Add(1)
+		go func() {
+			defer streams.Done()
+			serveStream(stream, store)
+		}()
 	}
 }
 
-func StartSyncatServer() error {
+// serveStream Serve a sync session on the stream, and close the stream once the session is ended
+func serveStream(stream *syncnet.Stream, store *database.Store) {
+	defer func() {
+		// end the sync session if the client is gone during it
+		err := stream.EndSyncSession(store, false)
+		if err != nil {
+			stream.Logger().Error("Failed to end sync session", err)
+		}
+		_ = stream.Close()
+	}()
+	// server should wait for 4 different packets on a stream: SYNC, FILE, GET, and ACK
+	// SYNC for starting a sync session
+	// FILE for receiving (or resuming) a file transfer
+	// GET for sending a file to the client
+	// ACK for ending the sync session
+	// the detailed implementations are handled in requests.go
+	for {
+		req, err := syncnet.Wait(stream, []syncnet.PacketType{syncnet.SYNC, syncnet.FILE, syncnet.GET, syncnet.ACK})
+		var closed syncnet.ErrStreamClosed
+		if errors.As(err, &closed) {
+			return
+		}
+		if err != nil {
+			stream.Logger().Warn("Failed to wait for packet", "err", err)
+			return
+		}
+		stream.Logger().Debug("Handling packet", "type", req.GetType().String())
+		err = req.Handle(stream, store)
+		if err != nil {
+			stream.Logger().Error("Failed to handle packet", err, "type", req.GetType().String())
+			return
+		}
+		if req.GetType() == syncnet.ACK {
+			return
+		}
+	}
+}
+
+// StartSyncatServer Start the server, serving the clients with the database
+// The server listens on TCP, and also on the Unix socket if configured
+// The configurations read by load are reloaded on SIGHUP
+func StartSyncatServer(store *database.Store, load Loader) error {
 	serverConfig := GetConfig()
 	addr := serverConfig.Host + ":" + strconv.Itoa(serverConfig.Port)
-	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
-	listener, err := net.ListenTCP("tcp", tcpAddr)
-	if err != nil {
-		return err
+	listeners := []net.Listener{listener}
+	if serverConfig.Socket != "" {
+		listener, err = listenSocket(serverConfig.Socket)
+		if err != nil {
+			closeListeners(listeners)
+			return err
+		}
+		listeners = append(listeners, listener)
 	}
-	log.Println("Syncat server started at " + addr + "...")
-	for {
-		conn, err := listener.AcceptTCP()
+	if serverConfig.MetricsAddress != "" {
+		stopMetrics, err := startMetricsServer(serverConfig.MetricsAddress)
 		if err != nil {
+			closeListeners(listeners)
 			return err
 		}
-		idleTimeoutConn := &syncnet.IdleTimeoutConn{
-			TCPConn:     conn,
-			IdleTimeout: time.Duration(config.GetConfig().Protocol.Timeout) * time.Second,
+		defer stopMetrics()
+	}
+	server, err := NewServer(store, config.GetConfig(), serverConfig)
+	if err != nil {
+		closeListeners(listeners)
+		return err
+	}
+	stop := server.reloadOnHangup(load)
+	defer stop()
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		slog.Info("Syncat server started", "network", listener.Addr().Network(), "address", listener.Addr().String())
+		go func(listener net.Listener) {
+			errs <- server.Serve(listener)
+		}(listener)
+	}
+	err = <-errs
+	closeListeners(listeners)
+	return err
+}
+
+// closeListeners Close the listeners, which also removes the socket file of a Unix socket
+func closeListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		_ = listener.Close()
+	}
+}
+
+// listenSocket Listen on the Unix socket, replacing the socket file left by a server not running anymore
+// ErrSocketInUse is returned if another server is listening on the socket
+func listenSocket(socket string) (net.Listener, error) {
+	if _, err := os.Stat(socket); err == nil {
+		c, err := net.DialTimeout("unix", socket, time.Second)
+		if err == nil {
+			_ = c.Close()
+			return nil, ErrSocketInUse{socket}
+		}
+		err = os.Remove(socket)
+		if err != nil {
+			return nil, err
 		}
-		idleTimeoutConn.Log("Connection established")
-		go handleConnection(idleTimeoutConn)
 	}
+	return net.Listen("unix", socket)
+}
+
+// remoteHost Get the host of the remote address of the connection, which is locked out on authentication failures
+// false is returned for the peers without a host, e.g. of Unix sockets, which are not locked out,
+// since all of them would share one lockout and the socket is guarded by its file permissions instead
+func remoteHost(conn *syncnet.IdleTimeoutConn) (string, bool) {
+	host, _, err := net.SplitHostPort(conn.Remote())
+	if err != nil || host == "" {
+		return "", false
+	}
+	return host, true
+}
+
+// reject Close the connection right after accepting it, for the reason
+func reject(conn *syncnet.IdleTimeoutConn, reason string, args ...any) {
+	metrics.ConnectionsRejected.Inc(reason)
+	conn.Logger().Warn("Connection rejected", append([]any{"reason", reason}, args...)...)
+	_ = conn.Close()
 }
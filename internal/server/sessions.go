@@ -0,0 +1,65 @@
+package server
+
+import (
+	"github.com/JeffersonQin/syncat/pkg/syncnet"
+	"sync"
+)
+
+// sessions are the connections of the authenticated clients, one for each client
+// They are notified when a sync root is changed by another client, so that they do not have to poll
+type sessions struct {
+	mu    sync.Mutex
+	conns map[int64]*syncnet.IdleTimeoutConn
+}
+
+// newSessions Create an empty registry of sessions
+func newSessions() *sessions {
+	return &sessions{conns: make(map[int64]*syncnet.IdleTimeoutConn)}
+}
+
+// add Register the connection of an authenticated client
+// The previous session of the client is returned, which should be closed, nil if none
+func (s *sessions) add(conn *syncnet.IdleTimeoutConn) *syncnet.IdleTimeoutConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.conns[conn.PeerId]
+	s.conns[conn.PeerId] = conn
+	return previous
+}
+
+// remove Unregister the connection when it is closed, unless it has been taken over by a newer session
+func (s *sessions) remove(conn *syncnet.IdleTimeoutConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns[conn.PeerId] == conn {
+		delete(s.conns, conn.PeerId)
+	}
+}
+
+// notify Push the change of the sync root to all the clients except the one changing it
+// Every client is notified in its own goroutine, so that a stalled client does not delay the others
+func (s *sessions) notify(origin *syncnet.IdleTimeoutConn, root string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		if conn == origin {
+			continue
+		}
+		go func(conn *syncnet.IdleTimeoutConn) {
+			err := syncnet.NewSyncatNotifyRequest(root).Send(conn.Control())
+			if err != nil {
+				conn.Logger().Warn("Failed to notify change", "root", root, "err", err)
+			}
+		}(conn)
+	}
+}
+
+// setBandwidth Change the bandwidth limit of the connections of all the clients, unlimited if not positive
+func (s *sessions) setBandwidth(bytesPerSecond int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.ReadLimiter.SetRate(bytesPerSecond)
+		conn.WriteLimiter.SetRate(bytesPerSecond)
+	}
+}
@@ -0,0 +1,132 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Algorithm is the name of a compression algorithm applied to the chunks of file transfers
+type Algorithm string
+
+const (
+	// None leaves the chunks uncompressed, used with peers not aware of compression
+	None Algorithm = ""
+	// Gzip is the gzip algorithm
+	Gzip Algorithm = "gzip"
+)
+
+// Supported is the list of supported algorithms, in the order of preference
+var Supported = []Algorithm{Gzip}
+
+// Parse the name of an algorithm
+// The empty name refers to None
+func Parse(name string) (Algorithm, error) {
+	if name == "" {
+		return None, nil
+	}
+	for _, a := range Supported {
+		if string(a) == name {
+			return a, nil
+		}
+	}
+	return None, ErrUnsupportedAlgorithm{name}
+}
+
+// Names Get the names of the algorithms
+func Names(algorithms []Algorithm) []string {
+	names := make([]string, len(algorithms))
+	for i, a := range algorithms {
+		names[i] = string(a)
+	}
+	return names
+}
+
+// Negotiate Choose the most preferred supported algorithm among the ones offered by the peer
+// None is chosen if nothing offered is supported, since compression is optional
+func Negotiate(offered []string) Algorithm {
+	for _, a := range Supported {
+		for _, name := range offered {
+			if string(a) == name {
+				return a
+			}
+		}
+	}
+	return None
+}
+
+// gzipWriters are reused, since a gzip writer allocates a lot
+var gzipWriters = sync.Pool{
+	New: func() any {
+		return gzip.NewWriter(io.Discard)
+	},
+}
+
+// Compress Compress the data
+func (a Algorithm) Compress(data []byte) ([]byte, error) {
+	switch a {
+	case None:
+		return data, nil
+	case Gzip:
+		var buf bytes.Buffer
+		w := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(w)
+		w.Reset(&buf)
+		_, err := w.Write(data)
+		if err != nil {
+			return nil, err
+		}
+		err = w.Close()
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, ErrUnsupportedAlgorithm{string(a)}
+}
+
+// Decompress Decompress the data, which must not exceed limit bytes after decompression
+// The limit guards against data crafted to decompress to a huge size
+func (a Algorithm) Decompress(data []byte, limit int) ([]byte, error) {
+	switch a {
+	case None:
+		if len(data) > limit {
+			return nil, ErrTooLarge{limit}
+		}
+		return data, nil
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = r.Close()
+		}()
+		result, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(result) > limit {
+			return nil, ErrTooLarge{limit}
+		}
+		return result, nil
+	}
+	return nil, ErrUnsupportedAlgorithm{string(a)}
+}
+
+// compressedExtensions are the extensions of the file types compressed already, which are not worth compressing
+var compressedExtensions = map[string]bool{
+	".7z": true, ".aac": true, ".avi": true, ".br": true, ".bz2": true, ".docx": true, ".flac": true,
+	".gif": true, ".gz": true, ".heic": true, ".jar": true, ".jpeg": true, ".jpg": true, ".lz4": true,
+	".m4a": true, ".mkv": true, ".mov": true, ".mp3": true, ".mp4": true, ".ogg": true, ".pdf": true,
+	".png": true, ".pptx": true, ".rar": true, ".tgz": true, ".webm": true, ".webp": true, ".xlsx": true,
+	".xz": true, ".zip": true, ".zst": true,
+}
+
+// Compressible Check whether the file is worth compressing judging by its extension
+func Compressible(path string) bool {
+	return !compressedExtensions[strings.ToLower(filepath.Ext(path))]
+}
@@ -0,0 +1,23 @@
+package compression
+
+import "fmt"
+
+// ErrUnsupportedAlgorithm is returned when the compression algorithm is not supported
+type ErrUnsupportedAlgorithm struct {
+	algorithm string
+}
+
+// Error returns the error message
+func (e ErrUnsupportedAlgorithm) Error() string {
+	return fmt.Sprintf("unsupported compression algorithm: %s", e.algorithm)
+}
+
+// ErrTooLarge is returned when the decompressed data exceeds the expected size
+type ErrTooLarge struct {
+	limit int
+}
+
+// Error returns the error message
+func (e ErrTooLarge) Error() string {
+	return fmt.Sprintf("decompressed data exceeds %d bytes", e.limit)
+}
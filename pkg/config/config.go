@@ -1,11 +1,6 @@
 package config
 
-import (
-	"gopkg.in/yaml.v3"
-	"io"
-	"os"
-	"path/filepath"
-)
+import "path/filepath"
 
 // SyncatDBConfig is the configuration for the database connection
 type SyncatDBConfig struct {
@@ -26,6 +21,43 @@ type SyncatProtocolConfig struct {
 	Timeout int `yaml:"timeout"`
 	// Ping interval for TCP server
 	PingInterval int `yaml:"ping_interval"`
+	// DisableCompression disables compressing file chunks, which is negotiated with the peer otherwise
+	DisableCompression bool `yaml:"disable_compression"`
+	// Bandwidth limits of the transfers
+	Bandwidth SyncatBandwidthConfig `yaml:"bandwidth"`
+}
+
+// SyncatBandwidthLimit is the limit of bytes per second, unlimited if 0
+// Upload is the bytes sent to the peer, and download is the bytes received from the peer
+type SyncatBandwidthLimit struct {
+	// Upload limit in bytes per second
+	Upload int `yaml:"upload"`
+	// Download limit in bytes per second
+	Download int `yaml:"download"`
+	// Schedules override the limit during their time of day, the first matching one is taken
+	Schedules []SyncatBandwidthSchedule `yaml:"schedules"`
+}
+
+// SyncatBandwidthSchedule is the limit during a time of day, wrapping around midnight if From is after To
+// A schedule with the same From and To covers the whole day
+type SyncatBandwidthSchedule struct {
+	// From is the start of the schedule in HH:MM, local time
+	From string `yaml:"from"`
+	// To is the end of the schedule in HH:MM, local time, exclusive
+	To string `yaml:"to"`
+	// Upload limit in bytes per second during the schedule
+	Upload int `yaml:"upload"`
+	// Download limit in bytes per second during the schedule
+	Download int `yaml:"download"`
+}
+
+// SyncatBandwidthConfig is the configuration for bandwidth limits
+// The global limit is shared by all the connections, and the limit of a sync root by the transfers of that root
+type SyncatBandwidthConfig struct {
+	// Global limit
+	SyncatBandwidthLimit `yaml:",inline"`
+	// Roots are the limits of the sync roots, by name
+	Roots map[string]SyncatBandwidthLimit `yaml:"roots"`
 }
 
 type SyncatAuthConfig struct {
@@ -33,6 +65,14 @@ type SyncatAuthConfig struct {
 	Token string `yaml:"token"`
 }
 
+// SyncatLogConfig is the configuration for logging
+type SyncatLogConfig struct {
+	// Level is the minimum level logged: debug, info, warn, or error
+	Level string `yaml:"level"`
+	// Format is the format of the output: json or text
+	Format string `yaml:"format"`
+}
+
 type SyncatConfig struct {
 	// Database configuration
 	Db SyncatDBConfig `yaml:"db"`
@@ -42,45 +82,65 @@ type SyncatConfig struct {
 	Protocol SyncatProtocolConfig `yaml:"protocol"`
 	// Authentication configuration
 	Auth SyncatAuthConfig `yaml:"auth"`
+	// Logging configuration
+	Log SyncatLogConfig `yaml:"log"`
 }
 
-var config SyncatConfig
+// config is the loaded configuration, replaced as a whole by LoadConfig and SetConfig
+var config Holder
 
-func LoadConfig() error {
-	// Obtain the executable path
-	ex, err := os.Executable()
-	if err != nil {
-		return err
-	}
-	exPath := filepath.Dir(ex)
-	// Obtain config file path
-	configPath := filepath.Join(exPath, "../config/config.yml")
-	// Open config file
-	configFile, err := os.Open(configPath)
+// LoadConfig Load the shared configuration from the config file, overridden by the environment variables of EnvPrefix
+// Relative paths are resolved against the directory given by BaseDir
+func LoadConfig(path string) error {
+	loaded, err := ReadConfig(path)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		_ = configFile.Close()
-	}()
-	// Read config file
-	configBytes, err := io.ReadAll(configFile)
+	config.Set(loaded)
+	return nil
+}
+
+// ReadConfig Read the shared configuration from the config file like LoadConfig, without replacing the loaded one
+func ReadConfig(path string) (SyncatConfig, error) {
+	var loaded SyncatConfig
+	err := LoadFile(path, &loaded)
 	if err != nil {
-		return err
+		return SyncatConfig{}, err
 	}
-	// Unmarshal config file
-	err = yaml.Unmarshal(configBytes, &config)
+	err = ApplyEnv(EnvPrefix, &loaded)
 	if err != nil {
-		return err
+		return SyncatConfig{}, err
 	}
-	// Obtain config file path
-	config.Db.Filename = filepath.Join(exPath, "..", config.Db.Filename)
-	for i := range config.Sync.Directories {
-		config.Sync.Directories[i] = filepath.Join(exPath, "..", config.Sync.Directories[i])
+	base := BaseDir(path)
+	loaded.Db.Filename = ResolvePath(base, loaded.Db.Filename)
+	for i := range loaded.Sync.Directories {
+		loaded.Sync.Directories[i] = ResolvePath(base, loaded.Sync.Directories[i])
 	}
-	return nil
+	return loaded, nil
 }
 
+// GetConfig Get a snapshot of the loaded configuration, which is consistent even if it is reloaded concurrently
 func GetConfig() SyncatConfig {
-	return config
+	return config.Get()
+}
+
+// SetConfig Replace the loaded configuration, e.g. by the one applied on reload
+func SetConfig(c SyncatConfig) {
+	config.Set(c)
+}
+
+// GetSyncDirectory Get the local directory of a sync root by its name in the loaded configuration
+func GetSyncDirectory(root string) (string, bool) {
+	return config.Get().GetSyncDirectory(root)
+}
+
+// GetSyncDirectory Get the local directory of a sync root by its name
+// The name of a sync root is the base name of the configured directory
+func (c SyncatConfig) GetSyncDirectory(root string) (string, bool) {
+	for _, dir := range c.Sync.Directories {
+		if filepath.Base(dir) == root {
+			return dir, true
+		}
+	}
+	return "", false
 }
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrConfigNotFound is returned when the config file is not found in any of the default locations
+type ErrConfigNotFound struct {
+	name     string
+	searched []string
+}
+
+// Error returns the error message
+func (e ErrConfigNotFound) Error() string {
+	return fmt.Sprintf("config file %s not found, searched: %s", e.name, strings.Join(e.searched, ", "))
+}
+
+// ErrInvalidEnv is returned when the value of an environment variable overriding the configuration is invalid
+type ErrInvalidEnv struct {
+	name  string
+	value string
+}
+
+// Error returns the error message
+func (e ErrInvalidEnv) Error() string {
+	return fmt.Sprintf("invalid value of %s: %q", e.name, e.value)
+}
+
+// ErrInvalidConfig is returned when validation finds problems in the configuration
+type ErrInvalidConfig struct {
+	problems Problems
+}
+
+// Problems returns the problems found by validation
+func (e ErrInvalidConfig) Problems() Problems {
+	return e.problems
+}
+
+// Error returns the error message
+func (e ErrInvalidConfig) Error() string {
+	lines := make([]string, 0, len(e.problems))
+	for _, problem := range e.problems {
+		lines = append(lines, fmt.Sprintf("  %s: %s", problem.Field, problem.Message))
+	}
+	return fmt.Sprintf("invalid configuration, %d problem(s) found:\n%s", len(e.problems), strings.Join(lines, "\n"))
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding the shared configuration, e.g. SYNCAT_AUTH_TOKEN
+// The variables are named after the yaml keys, joined by underscores and upper cased
+const EnvPrefix = "SYNCAT_"
+
+// Locate Get the path of the config file of the name, e.g. config.yml
+// The path given on the command line is taken first, then the path in the environment variable env,
+// then the file of the name in the directory of the config file beside if it is not empty and the file exists,
+// and at last the first existing file in DefaultLocations
+// ErrConfigNotFound is returned if none of the default locations exists
+func Locate(name string, given string, env string, beside string) (string, error) {
+	if given != "" {
+		return given, nil
+	}
+	if path := os.Getenv(env); path != "" {
+		return path, nil
+	}
+	var locations []string
+	if beside != "" {
+		locations = append(locations, filepath.Join(filepath.Dir(beside), name))
+	}
+	locations = append(locations, DefaultLocations(name)...)
+	for _, location := range locations {
+		if _, err := os.Stat(location); err == nil {
+			return location, nil
+		}
+	}
+	return "", ErrConfigNotFound{name, locations}
+}
+
+// DefaultLocations Get the default locations of the config file of the name, in the order they are searched
+// These are syncat/<name> in the user config directory, e.g. $XDG_CONFIG_HOME or ~/.config,
+// then in each of $XDG_CONFIG_DIRS, /etc/xdg if not set, and at last the config directory of the installation,
+// which is config/<name> beside the directory of the executable
+func DefaultLocations(name string) []string {
+	var dirs []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, dir)
+	}
+	configDirs := os.Getenv("XDG_CONFIG_DIRS")
+	if configDirs == "" {
+		configDirs = "/etc/xdg"
+	}
+	for _, dir := range filepath.SplitList(configDirs) {
+		// relative paths are invalid in XDG variables
+		if filepath.IsAbs(dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	locations := make([]string, 0, len(dirs)+1)
+	for _, dir := range dirs {
+		locations = append(locations, filepath.Join(dir, "syncat", name))
+	}
+	if dir, err := installConfigDir(); err == nil {
+		locations = append(locations, filepath.Join(dir, name))
+	}
+	return locations
+}
+
+// installConfigDir Get the config directory of the installation, which is config beside the directory of the executable
+func installConfigDir() (string, error) {
+	ex, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(ex), "..", "config"), nil
+}
+
+// BaseDir Get the directory the relative paths of the config file are resolved against,
+// which is the directory of the config file
+// The config files in the config directory of the installation keep resolving against the installation instead,
+// which the paths in the templates are relative to
+func BaseDir(path string) string {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return filepath.Dir(path)
+	}
+	if install, err := installConfigDir(); err == nil {
+		if install, err = filepath.Abs(install); err == nil && install == dir {
+			return filepath.Dir(dir)
+		}
+	}
+	return dir
+}
+
+// ResolvePath Resolve the path in the config file against the base directory, unless it is empty or absolute
+func ResolvePath(base string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(base, path)
+}
+
+// LoadFile Read the yaml config file into the target
+func LoadFile(path string, target any) error {
+	configFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = configFile.Close()
+	}()
+	configBytes, err := io.ReadAll(configFile)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(configBytes, target)
+}
+
+// ApplyEnv Override the fields of the target configuration by the environment variables with the prefix
+// The variable of a field is the prefix followed by the upper cased yaml keys of the field, joined by underscores,
+// e.g. SYNCAT_PROTOCOL_TIMEOUT for protocol.timeout with prefix SYNCAT_
+// Strings, integers, booleans, and lists of strings separated by the path list separator are supported,
+// and the other fields, e.g. maps, can only be configured in the config file
+// ErrInvalidEnv is returned if the value of a variable cannot be parsed
+func ApplyEnv(prefix string, target any) error {
+	return applyEnv(prefix, reflect.ValueOf(target).Elem())
+}
+
+// applyEnv Override the fields of the struct value by the environment variables with the prefix
+func applyEnv(prefix string, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if !field.IsExported() || key == "-" {
+			continue
+		}
+		value := v.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			nested := prefix + strings.ToUpper(key) + "_"
+			if options == "inline" {
+				nested = prefix
+			}
+			err := applyEnv(nested, value)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		name := prefix + strings.ToUpper(key)
+		env, ok := os.LookupEnv(name)
+		if !ok || key == "" {
+			continue
+		}
+		switch field.Type.Kind() {
+		case reflect.String:
+			value.SetString(env)
+		case reflect.Int:
+			n, err := strconv.Atoi(env)
+			if err != nil {
+				return ErrInvalidEnv{name, env}
+			}
+			value.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(env)
+			if err != nil {
+				return ErrInvalidEnv{name, env}
+			}
+			value.SetBool(b)
+		case reflect.Slice:
+			if field.Type.Elem().Kind() == reflect.String {
+				value.Set(reflect.ValueOf(filepath.SplitList(env)))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+)
+
+// Holder holds a configuration replaced as a whole on reload, so that concurrent readers always get a consistent
+// snapshot of it
+// The slices and maps of a snapshot are shared by all its readers, and must not be modified
+type Holder struct {
+	current atomic.Pointer[SyncatConfig]
+}
+
+// NewHolder Create a holder of the configuration
+func NewHolder(c SyncatConfig) *Holder {
+	h := &Holder{}
+	h.Set(c)
+	return h
+}
+
+// Get Get a snapshot of the configuration, the zero configuration if nothing is held
+func (h *Holder) Get() SyncatConfig {
+	if c := h.current.Load(); c != nil {
+		return *c
+	}
+	return SyncatConfig{}
+}
+
+// Set Replace the configuration
+func (h *Holder) Set(c SyncatConfig) {
+	h.current.Store(&c)
+}
+
+// Changes are the settings changed by reloading the configuration
+type Changes struct {
+	// Applied are the changes applied live
+	Applied []string `json:"applied,omitempty"`
+	// RestartRequired are the changes taking effect only after restarting, the settings keep their values till then
+	RestartRequired []string `json:"restart_required,omitempty"`
+}
+
+// Apply Record the change of the field applied live, with the details formatted by fmt.Sprint if any
+func (c *Changes) Apply(field string, details ...any) {
+	c.Applied = append(c.Applied, describeChange(field, details))
+}
+
+// RequireRestart Record the change of the field taking effect only after restarting,
+// with the details formatted by fmt.Sprint if any
+func (c *Changes) RequireRestart(field string, details ...any) {
+	c.RestartRequired = append(c.RestartRequired, describeChange(field, details))
+}
+
+// describeChange Describe the change of the field, e.g. sync.directories: sync4 added
+func describeChange(field string, details []any) string {
+	if len(details) == 0 {
+		return field
+	}
+	return field + ": " + fmt.Sprint(details...)
+}
+
+// Reload Get the configuration applied by reloading the current configuration with the loaded one, and the changes
+// The settings safe to change live are taken from the loaded configuration: the protocol settings, the bandwidth
+// limits, the token, the log level, and the added sync roots
+// The database, the log format, and the sync roots removed or moved to another directory keep their current values
+// until restarting, since the open sessions and files depend on them
+func Reload(current SyncatConfig, loaded SyncatConfig) (SyncatConfig, Changes) {
+	var changes Changes
+	applied := loaded
+	if loaded.Db != current.Db {
+		changes.RequireRestart("db.filename")
+		applied.Db = current.Db
+	}
+	applied.Sync.Directories = reloadDirectories(current.Sync.Directories, loaded.Sync.Directories, &changes)
+	if loaded.Protocol.BufferSize != current.Protocol.BufferSize {
+		changes.Apply("protocol.buffer_size")
+	}
+	if loaded.Protocol.Timeout != current.Protocol.Timeout {
+		changes.Apply("protocol.timeout")
+	}
+	if loaded.Protocol.PingInterval != current.Protocol.PingInterval {
+		changes.Apply("protocol.ping_interval")
+	}
+	if loaded.Protocol.DisableCompression != current.Protocol.DisableCompression {
+		changes.Apply("protocol.disable_compression", "for the connections authenticated from now on")
+	}
+	if !reflect.DeepEqual(loaded.Protocol.Bandwidth, current.Protocol.Bandwidth) {
+		changes.Apply("protocol.bandwidth")
+	}
+	if loaded.Auth != current.Auth {
+		changes.Apply("auth.token", "for the connections authenticated from now on")
+	}
+	if loaded.Log.Level != current.Log.Level {
+		changes.Apply("log.level")
+	}
+	if loaded.Log.Format != current.Log.Format {
+		changes.RequireRestart("log.format")
+		applied.Log.Format = current.Log.Format
+	}
+	return applied, changes
+}
+
+// reloadDirectories Get the sync directories applied by reloading the current ones with the loaded ones
+// The current directories are kept, and the directories of the added sync roots are appended
+func reloadDirectories(current []string, loaded []string, changes *Changes) []string {
+	currentDirs := make(map[string]string, len(current))
+	for _, dir := range current {
+		currentDirs[filepath.Base(dir)] = dir
+	}
+	loadedRoots := make(map[string]bool, len(loaded))
+	applied := append([]string(nil), current...)
+	for _, dir := range loaded {
+		root := filepath.Base(dir)
+		loadedRoots[root] = true
+		currentDir, ok := currentDirs[root]
+		switch {
+		case !ok:
+			applied = append(applied, dir)
+			changes.Apply("sync.directories", root, " added")
+		case currentDir != dir:
+			changes.RequireRestart("sync.directories", root, " moved to ", dir)
+		}
+	}
+	for _, dir := range current {
+		if root := filepath.Base(dir); !loadedRoots[root] {
+			changes.RequireRestart("sync.directories", root, " removed")
+		}
+	}
+	return applied
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestReloadDirectories The added sync roots are applied, and the moved and removed ones keep their directories
+func TestReloadDirectories(t *testing.T) {
+	current := []string{"/data/docs", "/data/photos", "/data/music"}
+	tests := []struct {
+		name    string
+		loaded  []string
+		want    []string
+		changes Changes
+	}{
+		{"unchanged", []string{"/data/photos", "/data/docs", "/data/music"}, current, Changes{}},
+		{"added", append(append([]string(nil), current...), "/data/notes"),
+			[]string{"/data/docs", "/data/photos", "/data/music", "/data/notes"},
+			Changes{Applied: []string{"sync.directories: notes added"}}},
+		{"moved", []string{"/data/docs", "/backup/photos", "/data/music"}, current,
+			Changes{RestartRequired: []string{"sync.directories: photos moved to /backup/photos"}}},
+		{"removed", []string{"/data/docs", "/data/music"}, current,
+			Changes{RestartRequired: []string{"sync.directories: photos removed"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var changes Changes
+			got := reloadDirectories(current, tt.loaded, &changes)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("directories = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(changes, tt.changes) {
+				t.Fatalf("changes = %+v, want %+v", changes, tt.changes)
+			}
+		})
+	}
+}
+
+// TestReload The settings safe to change live are applied, and the others keep their current values
+func TestReload(t *testing.T) {
+	current := SyncatConfig{
+		Db:       SyncatDBConfig{Filename: "/data/syncat.db"},
+		Sync:     SyncatSyncConfig{Directories: []string{"/data/docs"}},
+		Protocol: SyncatProtocolConfig{BufferSize: 4096, Timeout: 10, PingInterval: 5},
+		Auth:     SyncatAuthConfig{Token: "old"},
+		Log:      SyncatLogConfig{Level: "info", Format: "json"},
+	}
+
+	applied, changes := Reload(current, current)
+	if !reflect.DeepEqual(applied, current) || !reflect.DeepEqual(changes, Changes{}) {
+		t.Fatalf("Reload of the same config = %+v, %+v, want it unchanged", applied, changes)
+	}
+
+	loaded := SyncatConfig{
+		Db:   SyncatDBConfig{Filename: "/other/syncat.db"},
+		Sync: SyncatSyncConfig{Directories: []string{"/data/docs", "/data/notes"}},
+		Protocol: SyncatProtocolConfig{
+			BufferSize:   8192,
+			Timeout:      20,
+			PingInterval: 5,
+			Bandwidth:    SyncatBandwidthConfig{SyncatBandwidthLimit: SyncatBandwidthLimit{Upload: 1000}},
+		},
+		Auth: SyncatAuthConfig{Token: "new"},
+		Log:  SyncatLogConfig{Level: "debug", Format: "text"},
+	}
+	applied, changes = Reload(current, loaded)
+	want := loaded
+	want.Db, want.Log.Format = current.Db, current.Log.Format
+	if !reflect.DeepEqual(applied, want) {
+		t.Fatalf("applied = %+v, want %+v", applied, want)
+	}
+	wantChanges := Changes{
+		Applied: []string{
+			"sync.directories: notes added",
+			"protocol.buffer_size",
+			"protocol.timeout",
+			"protocol.bandwidth",
+			"auth.token: for the connections authenticated from now on",
+			"log.level",
+		},
+		RestartRequired: []string{"db.filename", "log.format"},
+	}
+	if !reflect.DeepEqual(changes, wantChanges) {
+		t.Fatalf("changes = %+v, want %+v", changes, wantChanges)
+	}
+}
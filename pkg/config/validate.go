@@ -0,0 +1,178 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxBufferSize is the largest protocol.buffer_size, the file chunks carried by packets are further capped by the
+// flow control of the streams
+const MaxBufferSize = 16 << 20
+
+// Problem is a problem of a setting found by validation
+type Problem struct {
+	// Field is the path of the setting in the config file, e.g. sync.directories[1]
+	Field string
+	// Message tells what is wrong with the setting, and how to fix it
+	Message string
+}
+
+// Problems are the problems found by validation, collected so that all of them are reported at once
+type Problems []Problem
+
+// Add Record a problem of the field, with the message formatted by fmt.Sprintf
+func (p *Problems) Add(field string, format string, args ...any) {
+	*p = append(*p, Problem{field, fmt.Sprintf(format, args...)})
+}
+
+// Err Get ErrInvalidConfig with the problems, nil if there is none
+func (p Problems) Err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return ErrInvalidConfig{p}
+}
+
+// Report Write the result of validating the config file of the path, and return whether it is valid
+// Each problem is written on its own line, prefixed by the path of the file and the field
+func Report(w io.Writer, path string, err error) bool {
+	if err == nil {
+		fmt.Fprintf(w, "%s: ok\n", path)
+		return true
+	}
+	var invalid ErrInvalidConfig
+	if !errors.As(err, &invalid) {
+		fmt.Fprintf(w, "%s: %v\n", path, err)
+		return false
+	}
+	for _, problem := range invalid.Problems() {
+		fmt.Fprintf(w, "%s: %s: %s\n", path, problem.Field, problem.Message)
+	}
+	return false
+}
+
+// IsPlaceholder Check whether the value is a placeholder of the templates, e.g. <your_token>
+func IsPlaceholder(value string) bool {
+	return strings.HasPrefix(value, "<") && strings.HasSuffix(value, ">")
+}
+
+// CheckDirectory Record a problem of the field if the directory does not exist or is not a directory
+func (p *Problems) CheckDirectory(field string, dir string) {
+	info, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		p.Add(field, "directory %s does not exist, create it or correct the path", dir)
+	case err != nil:
+		p.Add(field, "directory %s cannot be accessed: %v", dir, err)
+	case !info.IsDir():
+		p.Add(field, "%s is not a directory", dir)
+	}
+}
+
+// CheckPort Record a problem of the field if the port is not a valid TCP port
+func (p *Problems) CheckPort(field string, port int) {
+	if port <= 0 || port > 65535 {
+		p.Add(field, "must be a port between 1 and 65535, got %d", port)
+	}
+}
+
+// Validate Check the shared configuration, and return ErrInvalidConfig with all the problems found
+func (c SyncatConfig) Validate() error {
+	var p Problems
+	if c.Db.Filename == "" {
+		p.Add("db.filename", "must not be empty")
+	}
+	if len(c.Sync.Directories) == 0 {
+		p.Add("sync.directories", "must list at least one directory to sync")
+	}
+	roots := make(map[string]int, len(c.Sync.Directories))
+	for i, dir := range c.Sync.Directories {
+		field := fmt.Sprintf("sync.directories[%d]", i)
+		p.CheckDirectory(field, dir)
+		root := filepath.Base(dir)
+		if j, ok := roots[root]; ok {
+			p.Add(field, "sync root name %s is also the name of sync.directories[%d], rename one of the directories",
+				root, j)
+		}
+		roots[root] = i
+	}
+	c.Protocol.validate(&p, roots)
+	switch {
+	case c.Auth.Token == "":
+		p.Add("auth.token", "must not be empty, set a secret shared by the server and the clients")
+	case IsPlaceholder(c.Auth.Token):
+		p.Add("auth.token", "is the placeholder %s of the template, set a secret shared by the server and the clients",
+			c.Auth.Token)
+	}
+	switch strings.ToLower(c.Log.Level) {
+	case "", "debug", "info", "warn", "warning", "error":
+	default:
+		p.Add("log.level", "must be debug, info, warn, or error, got %s", c.Log.Level)
+	}
+	switch strings.ToLower(c.Log.Format) {
+	case "", "json", "text":
+	default:
+		p.Add("log.format", "must be json or text, got %s", c.Log.Format)
+	}
+	return p.Err()
+}
+
+// validate Record the problems of the protocol configuration, with the indexes of the sync roots by name
+func (c SyncatProtocolConfig) validate(p *Problems, roots map[string]int) {
+	if c.BufferSize <= 0 || c.BufferSize > MaxBufferSize {
+		p.Add("protocol.buffer_size", "must be between 1 and %d bytes, got %d", MaxBufferSize, c.BufferSize)
+	}
+	if c.Timeout <= 0 {
+		p.Add("protocol.timeout", "must be a positive number of seconds, got %d", c.Timeout)
+	}
+	if c.PingInterval <= 0 {
+		p.Add("protocol.ping_interval", "must be a positive number of seconds, got %d", c.PingInterval)
+	} else if c.Timeout > 0 && c.PingInterval >= c.Timeout {
+		p.Add("protocol.ping_interval", "must be less than protocol.timeout (%d), "+
+			"or idle connections are closed between pings", c.Timeout)
+	}
+	c.Bandwidth.SyncatBandwidthLimit.validate(p, "protocol.bandwidth")
+	names := make([]string, 0, len(c.Bandwidth.Roots))
+	for root := range c.Bandwidth.Roots {
+		names = append(names, root)
+	}
+	sort.Strings(names)
+	for _, root := range names {
+		field := "protocol.bandwidth.roots." + root
+		if _, ok := roots[root]; !ok {
+			p.Add(field, "%s is not the name of any directory in sync.directories", root)
+		}
+		c.Bandwidth.Roots[root].validate(p, field)
+	}
+}
+
+// validate Record the problems of the bandwidth limit at the field
+func (l SyncatBandwidthLimit) validate(p *Problems, field string) {
+	if l.Upload < 0 {
+		p.Add(field+".upload", "must not be negative, 0 for unlimited")
+	}
+	if l.Download < 0 {
+		p.Add(field+".download", "must not be negative, 0 for unlimited")
+	}
+	for i, s := range l.Schedules {
+		scheduleField := fmt.Sprintf("%s.schedules[%d]", field, i)
+		if _, err := time.Parse("15:04", s.From); err != nil {
+			p.Add(scheduleField+".from", "must be a time of day in HH:MM, got %q", s.From)
+		}
+		if _, err := time.Parse("15:04", s.To); err != nil {
+			p.Add(scheduleField+".to", "must be a time of day in HH:MM, got %q", s.To)
+		}
+		if s.Upload < 0 {
+			p.Add(scheduleField+".upload", "must not be negative, 0 for unlimited")
+		}
+		if s.Download < 0 {
+			p.Add(scheduleField+".download", "must not be negative, 0 for unlimited")
+		}
+	}
+}
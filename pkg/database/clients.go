@@ -0,0 +1,171 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Results of the last sync session of a client
+const (
+	// SyncCompleted is recorded when the client acknowledges the end of the sync session
+	SyncCompleted = "completed"
+	// SyncInterrupted is recorded when the client is gone during the sync session
+	SyncInterrupted = "interrupted"
+)
+
+// Client is a client registered on the server, as recorded in clients table
+type Client struct {
+	// Id is the id of the client, which is the cid for last_sync table
+	Id int64
+	// Uuid is the uuid allocated to the client
+	Uuid string
+	// Name is the human name given by the administrator
+	Name string
+	// DeviceName is the name of the device chosen by the user of the client
+	DeviceName string
+	// Hostname is the hostname of the client
+	Hostname string
+	// Os is the operating system and architecture of the client
+	Os string
+	// Version is the version of syncat the client runs
+	Version string
+	// FirstSeen is the time the client is registered
+	FirstSeen time.Time
+	// LastSeen is the time the client last authenticated, zero if never
+	LastSeen time.Time
+	// LastSyncTime is the time the last sync session of the client ended, zero if never synced
+	LastSyncTime time.Time
+	// LastSyncRoot is the sync root of the last sync session
+	LastSyncRoot string
+	// LastSyncResult is the result of the last sync session, SyncCompleted or SyncInterrupted
+	LastSyncResult string
+	// Revoked indicates whether the client is no longer allowed to authenticate
+	Revoked bool
+}
+
+// Columns selected for scanning a client, in the order of scanClients
+const clientColumns = "`id`, `uuid`, `name`, `device_name`, `hostname`, `os`, `version`, `first_seen`, `last_seen`, " +
+	"`last_sync_time`, `last_sync_root`, `last_sync_result`, `revoked`"
+
+// scanClients Scan all the rows selected with clientColumns
+func scanClients(rows *sql.Rows) ([]Client, error) {
+	defer func() {
+		_ = rows.Close()
+	}()
+	var clients []Client
+	for rows.Next() {
+		var c Client
+		var lastSeen, lastSyncTime sql.NullTime
+		err := rows.Scan(&c.Id, &c.Uuid, &c.Name, &c.DeviceName, &c.Hostname, &c.Os, &c.Version, &c.FirstSeen,
+			&lastSeen, &lastSyncTime, &c.LastSyncRoot, &c.LastSyncResult, &c.Revoked)
+		if err != nil {
+			return nil, err
+		}
+		c.LastSeen, c.LastSyncTime = lastSeen.Time, lastSyncTime.Time
+		clients = append(clients, c)
+	}
+	return clients, rows.Err()
+}
+
+// ClientInfo is the identity metadata reported by the client at authentication
+type ClientInfo struct {
+	// DeviceName is the name of the device chosen by the user of the client
+	DeviceName string
+	// Hostname is the hostname of the client
+	Hostname string
+	// Os is the operating system and architecture of the client
+	Os string
+	// Version is the version of syncat the client runs
+	Version string
+}
+
+// DisplayName Get the name to show for the client
+// The name given by the administrator is preferred, then the device name, the hostname, and the uuid
+func (c Client) DisplayName() string {
+	for _, name := range []string{c.Name, c.DeviceName, c.Hostname} {
+		if name != "" {
+			return name
+		}
+	}
+	return c.Uuid
+}
+
+// QueryClients Query all the clients registered on the server, ordered by id
+func (s *Store) QueryClients() ([]Client, error) {
+	rows, err := s.db.Query("SELECT " + clientColumns + " FROM `clients` ORDER BY `id`")
+	if err != nil {
+		return nil, err
+	}
+	return scanClients(rows)
+}
+
+// QueryClient Query the client by uuid on server
+// The second return value indicates whether the client exists
+func (s *Store) QueryClient(uuid string) (Client, bool, error) {
+	rows, err := s.db.Query("SELECT "+clientColumns+" FROM `clients` WHERE `uuid` = ? LIMIT 1", uuid)
+	if err != nil {
+		return Client{}, false, err
+	}
+	clients, err := scanClients(rows)
+	if err != nil || len(clients) == 0 {
+		return Client{}, false, err
+	}
+	return clients[0], true, nil
+}
+
+// QueryClientByRef Query the client referred by id, uuid, or name
+// ErrClientNotFound is returned if no client matches, and ErrAmbiguousClient if the name is shared by several clients
+func (s *Store) QueryClientByRef(ref string) (Client, error) {
+	rows, err := s.db.Query("SELECT "+clientColumns+" FROM `clients` "+
+		"WHERE CAST(`id` AS TEXT) = ? OR `uuid` = ? OR (`name` != '' AND `name` = ?) ORDER BY `id`", ref, ref, ref)
+	if err != nil {
+		return Client{}, err
+	}
+	clients, err := scanClients(rows)
+	if err != nil {
+		return Client{}, err
+	}
+	if len(clients) == 0 {
+		return Client{}, ErrClientNotFound{ref}
+	}
+	if len(clients) > 1 {
+		return Client{}, ErrAmbiguousClient{ref, len(clients)}
+	}
+	return clients[0], nil
+}
+
+// UpdateClientName Give the client a human name
+func (s *Store) UpdateClientName(cid int64, name string) error {
+	_, err := s.db.Exec("UPDATE `clients` SET `name` = ? WHERE `id` = ?", name, cid)
+	return err
+}
+
+// UpdateClientRevoked Revoke the client, or allow it to authenticate again
+func (s *Store) UpdateClientRevoked(cid int64, revoked bool) error {
+	_, err := s.db.Exec("UPDATE `clients` SET `revoked` = ? WHERE `id` = ?", revoked, cid)
+	return err
+}
+
+// UpdateClientSeen Record the time the client authenticated, with the identity metadata it reported
+func (s *Store) UpdateClientSeen(cid int64, info ClientInfo) error {
+	_, err := s.db.Exec("UPDATE `clients` SET `last_seen` = ?, `device_name` = ?, `hostname` = ?, `os` = ?, "+
+		"`version` = ? WHERE `id` = ?", time.Now(), info.DeviceName, info.Hostname, info.Os, info.Version, cid)
+	return err
+}
+
+// UpdateClientSyncResult Record the result of the sync session of the client that just ended
+func (s *Store) UpdateClientSyncResult(cid int64, root string, result string) error {
+	_, err := s.db.Exec("UPDATE `clients` SET `last_sync_time` = ?, `last_sync_root` = ?, `last_sync_result` = ? "+
+		"WHERE `id` = ?", time.Now(), root, result, cid)
+	return err
+}
+
+// DeleteLastSyncOfClient Delete all the last sync status with the client, and return the number deleted
+// The status is recorded again as the client syncs
+func (s *Store) DeleteLastSyncOfClient(cid int64) (int64, error) {
+	result, err := s.db.Exec("DELETE FROM `last_sync` WHERE `cid` = ?", cid)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,51 @@
+package database
+
+import "time"
+
+// Conflict is a conflict detected by the client, as recorded in conflicts table
+type Conflict struct {
+	// Id is the id of the conflict
+	Id int64
+	// Root is the name of the sync root
+	Root string
+	// Path is the path of the conflicting file, which now has the version of the server
+	Path string
+	// ConflictPath is the path the local copy of the file is moved to
+	ConflictPath string
+	// ServerUuid is the version of the server taken at Path
+	ServerUuid string
+	// Detected is the time the conflict is detected
+	Detected time.Time
+	// Resolved indicates whether the conflict has been resolved by the user
+	Resolved bool
+}
+
+// InsertConflict Record a conflict detected by the client
+// The local copy of the file has been moved to conflictPath, and the version of the server is synced to path
+func (s *Store) InsertConflict(root string, path string, conflictPath string, serverUuid string) error {
+	_, err := s.db.Exec("INSERT INTO `conflicts` (`root`, `path`, `conflict_path`, `server_uuid`, `detected`) "+
+		"VALUES (?, ?, ?, ?, ?)", root, path, conflictPath, serverUuid, time.Now())
+	return err
+}
+
+// QueryUnresolvedConflicts Query the conflicts not resolved yet, ordered by the time detected
+func (s *Store) QueryUnresolvedConflicts() ([]Conflict, error) {
+	rows, err := s.db.Query("SELECT `id`, `root`, `path`, `conflict_path`, `server_uuid`, `detected`, `resolved` " +
+		"FROM `conflicts` WHERE `resolved` = 0 ORDER BY `detected`, `id`")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var conflicts []Conflict
+	for rows.Next() {
+		var c Conflict
+		err = rows.Scan(&c.Id, &c.Root, &c.Path, &c.ConflictPath, &c.ServerUuid, &c.Detected, &c.Resolved)
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, c)
+	}
+	return conflicts, rows.Err()
+}
@@ -7,106 +7,97 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
-// Global database instance
-var db *sql.DB
+// Role is the side a database belongs to, since clients and servers keep different schemas
+type Role int
+
+const (
+	// ServerRole is the database of the server, with the registry of clients
+	ServerRole Role = iota + 1
+	// ClientRole is the database of a client, with the identity allocated by the server
+	ClientRole
+)
+
+// String returns the name of the role
+func (r Role) String() string {
+	switch r {
+	case ServerRole:
+		return "server"
+	case ClientRole:
+		return "client"
+	}
+	return "unknown"
+}
+
+// Store is a connection to a syncat database
+// Every client and server owns its own Store, so that several of them can run in one process
+type Store struct {
+	db   timedDB
+	role Role
+	// mu guards lockedRoots
+	mu sync.Mutex
+	// lockedRoots are the sync roots in a sync session
+	lockedRoots map[string]bool
+}
 
 // Get the database connection string
 func getDbConnectionString(dbConfig config.SyncatDBConfig) string {
 	return "file:" + dbConfig.Filename + "?cache=shared&mode=rwc"
 }
 
-// SQL statements for creating tables
-var createTableSql = []string{ /* file entry table */ `
-	CREATE TABLE IF NOT EXISTS "entries" (
-		"id"		INTEGER PRIMARY KEY AUTOINCREMENT,
-		"path" 		VARCHAR(512) NOT NULL,
-		"hash_md5" 	VARCHAR(32) NOT NULL,
-		"timestamp" DATETIME NOT NULL,
-		"size" 		INTEGER NOT NULL,
-	    "is_dir"    INTEGER NOT NULL,
-		"deleted" 	INTEGER NOT NULL,
-		"uuid"		VARCHAR(36) NOT NULL
-	)
-	`,
-	/*
-	 * client table. server will use this table to store clients,
-	 * and clients will use this table to store their own uuid allocated by the server.
-	 * for clients, only the first row with id = 1 will be used.
-	 */`
-	CREATE TABLE IF NOT EXISTS "clients" (
-		"id"		INTEGER PRIMARY KEY AUTOINCREMENT,
-		"uuid"		VARCHAR(36) NOT NULL
-	)
-	`,
-	/*
-	 * last sync table. server will use this table to store the last syncing status of each client.
-	 * for clients, using cid = 1 will store the last syncing status of the server.
-	 * this table will be helpful when deciding which files to sync.
-	 */`
-	CREATE TABLE IF NOT EXISTS "last_sync" (
-		"fid"		INTEGER NOT NULL,
-		"cid"		INTEGER NOT NULL,
-		"path" 		VARCHAR(512) NOT NULL,
-		"hash_md5" 	VARCHAR(32) NOT NULL,
-		"timestamp" DATETIME NOT NULL,
-		"size" 		INTEGER NOT NULL,
-	    "is_dir"    INTEGER NOT NULL,
-		"deleted" 	INTEGER NOT NULL,
-		"uuid"		VARCHAR(36) NOT NULL,
-	    PRIMARY KEY (fid, cid)
-	)
-	`,
-}
-
-// LoadDatabase Load database
-func LoadDatabase() error {
-	dbConfig := config.GetConfig().Db
+// LoadDatabase Load the database configured for the role, and migrate its schema to the latest version
+func LoadDatabase(dbConfig config.SyncatDBConfig, role Role) (*Store, error) {
 	// Create db file if not exists
 	err := os.MkdirAll(filepath.Dir(dbConfig.Filename), os.ModePerm)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	f, err := os.OpenFile(dbConfig.Filename, os.O_WRONLY|os.O_CREATE, 0666)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// Close db file
 	err = f.Close()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// Connect to the database
-	db, err = sql.Open("sqlite3", getDbConnectionString(dbConfig))
+	db, err := sql.Open("sqlite3", getDbConnectionString(dbConfig))
 	if err != nil {
-		return err
+		return nil, err
 	}
+	s := &Store{db: timedDB{db}, role: role, lockedRoots: make(map[string]bool)}
 	// Ping
-	err = db.Ping()
+	err = s.db.Ping()
 	if err != nil {
-		_ = db.Close()
-		return err
+		_ = s.db.Close()
+		return nil, err
 	}
-	// Create the tables if not exist
-	for _, s := range createTableSql {
-		_, err = db.Exec(s)
-		if err != nil {
-			_ = db.Close()
-			return err
-		}
+	// Migrate the schema to the latest version
+	err = s.migrate()
+	if err != nil {
+		_ = s.db.Close()
+		return nil, err
 	}
-	return nil
+	return s, nil
+}
+
+// Close Close the database
+func (s *Store) Close() error {
+	return s.db.Close()
 }
 
-// CloseDatabase Close database
-func CloseDatabase() error {
-	return db.Close()
+// Role Get the side the database belongs to
+func (s *Store) Role() Role {
+	return s.role
 }
 
 // QueryExistsClientUuid Query if the client uuid exists on server
-func QueryExistsClientUuid(uuid string) (bool, error) {
-	row, err := db.Query("SELECT 1 FROM `clients` WHERE `uuid` = ? LIMIT 1", uuid)
+func (s *Store) QueryExistsClientUuid(uuid string) (bool, error) {
+	row, err := s.db.Query("SELECT 1 FROM `clients` WHERE `uuid` = ? LIMIT 1", uuid)
 	if err != nil {
 		return false, err
 	}
@@ -117,11 +108,11 @@ func QueryExistsClientUuid(uuid string) (bool, error) {
 }
 
 // AllocateNewClientUuid Allocate a new uuid for the client on the server
-func AllocateNewClientUuid() (string, error) {
+func (s *Store) AllocateNewClientUuid() (string, error) {
 	var uuidStr string
 	for {
 		uuidStr = uuid.NewString()
-		exists, err := QueryExistsClientUuid(uuidStr)
+		exists, err := s.QueryExistsClientUuid(uuidStr)
 		if err != nil {
 			return "", err
 		}
@@ -129,16 +120,17 @@ func AllocateNewClientUuid() (string, error) {
 			break
 		}
 	}
-	_, err := db.Exec("INSERT INTO `clients` (`uuid`) VALUES (?)", uuidStr)
+	_, err := s.db.Exec("INSERT INTO `clients` (`uuid`, `first_seen`) VALUES (?, ?)", uuidStr, time.Now())
 	if err != nil {
 		return "", err
 	}
 	return uuidStr, nil
 }
 
-// QueryClientUuid Query the client's own uuid
-func QueryClientUuid() (string, error) {
-	row, err := db.Query("SELECT `uuid` FROM `clients` WHERE `id` = 1 LIMIT 1")
+// QueryClientUuid Query the client's own uuid allocated by the server
+// Empty uuid is returned if the client has not been registered
+func (s *Store) QueryClientUuid() (string, error) {
+	row, err := s.db.Query("SELECT `client_uuid` FROM `server_identity` WHERE `id` = 1 LIMIT 1")
 	if err != nil {
 		return "", err
 	}
@@ -156,8 +148,88 @@ func QueryClientUuid() (string, error) {
 	return uuidStr, nil
 }
 
-// UpdateClientUuid Update the client's own uuid
-func UpdateClientUuid(uuid string) error {
-	_, err := db.Exec("UPDATE `clients` SET `uuid` = ? WHERE `id` = 1", uuid)
+// UpdateClientUuid Update the client's own uuid allocated by the server
+func (s *Store) UpdateClientUuid(uuid string) error {
+	_, err := s.db.Exec("INSERT INTO `server_identity` (`id`, `client_uuid`, `updated`) VALUES (1, ?, ?) "+
+		"ON CONFLICT (`id`) DO UPDATE SET `client_uuid` = excluded.`client_uuid`, `updated` = excluded.`updated`",
+		uuid, time.Now())
+	return err
+}
+
+// Transfer is a staged transfer, as recorded in transfers table
+type Transfer struct {
+	// Uuid is the id of the transfer
+	Uuid string
+	// Root is the sync root of the file
+	Root string
+	// Path is the path of the file relative to the sync root
+	Path string
+	// Timestamp is the time the transfer is created or last makes progress
+	Timestamp time.Time
+}
+
+// QueryStaleTransfers Query the staged transfers without progress since the time
+func (s *Store) QueryStaleTransfers(before time.Time) ([]Transfer, error) {
+	rows, err := s.db.Query("SELECT `uuid`, `root`, `path`, `timestamp` FROM `transfers`")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var transfers []Transfer
+	for rows.Next() {
+		var t Transfer
+		err = rows.Scan(&t.Uuid, &t.Root, &t.Path, &t.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		// compared here, since the timestamps are stored as text with the time zone of the writer
+		if t.Timestamp.Before(before) {
+			transfers = append(transfers, t)
+		}
+	}
+	return transfers, rows.Err()
+}
+
+// QueryTransferOffset Query the verified offset of a staged transfer
+// The second return value indicates whether the transfer exists
+func (s *Store) QueryTransferOffset(uuid string) (uint64, bool, error) {
+	row, err := s.db.Query("SELECT `offset` FROM `transfers` WHERE `uuid` = ? LIMIT 1", uuid)
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() {
+		_ = row.Close()
+	}()
+	if !row.Next() {
+		return 0, false, nil
+	}
+	var offset uint64
+	err = row.Scan(&offset)
+	if err != nil {
+		return 0, false, err
+	}
+	return offset, true, nil
+}
+
+// CreateTransfer Record a new staged transfer starting from offset 0
+func (s *Store) CreateTransfer(uuid string, root string, path string, hash string, hashAlgo string,
+	size uint64) error {
+	_, err := s.db.Exec("INSERT INTO `transfers` (`uuid`, `root`, `path`, `hash`, `hash_algo`, `size`, `offset`, "+
+		"`timestamp`) VALUES (?, ?, ?, ?, ?, ?, 0, ?)", uuid, root, path, hash, hashAlgo, size, time.Now())
+	return err
+}
+
+// UpdateTransferOffset Update the verified offset of a staged transfer
+func (s *Store) UpdateTransferOffset(uuid string, offset uint64) error {
+	_, err := s.db.Exec("UPDATE `transfers` SET `offset` = ?, `timestamp` = ? WHERE `uuid` = ?",
+		offset, time.Now(), uuid)
+	return err
+}
+
+// DeleteTransfer Delete the record of a staged transfer
+func (s *Store) DeleteTransfer(uuid string) error {
+	_, err := s.db.Exec("DELETE FROM `transfers` WHERE `uuid` = ?", uuid)
 	return err
 }
@@ -0,0 +1,298 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Entry is a file or directory in a sync root, as recorded in entries or last_sync table
+type Entry struct {
+	// Id is the id of the entry, which is the fid for last_sync table
+	Id int64
+	// Root is the name of the sync root
+	Root string
+	// Path is the path relative to the sync root, with slash as separator
+	Path string
+	// Hash is the hex digest of the content
+	Hash string
+	// HashAlgo is the algorithm of the hash
+	HashAlgo string
+	// Timestamp is the modification time
+	Timestamp time.Time
+	// Size is the size of the content
+	Size uint64
+	// IsDir indicates whether the entry is a directory
+	IsDir bool
+	// Deleted indicates whether the entry has been deleted
+	Deleted bool
+	// Uuid is the version of the entry
+	Uuid string
+}
+
+// executor is implemented by both the database and its transactions, so that the queries can run inside or outside a transaction
+type executor interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// Columns selected for scanning an entry, in the order of scanEntries
+const entryColumns = "`root`, `path`, `hash`, `hash_algo`, `timestamp`, `size`, `is_dir`, `deleted`, `uuid`"
+
+// prefixRange Get the range of paths starting with the prefix, as [lower, upper)
+// Comparing with a range instead of LIKE keeps the comparison case-sensitive, and lets the index be used
+func prefixRange(prefix string) (string, string) {
+	// 0xff never appears in UTF-8, so it is greater than any byte following the prefix
+	return prefix, prefix + "\xff"
+}
+
+// scanEntries Scan all the rows selected with id column followed by entryColumns
+func scanEntries(rows *sql.Rows) ([]Entry, error) {
+	defer func() {
+		_ = rows.Close()
+	}()
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		err := rows.Scan(&e.Id, &e.Root, &e.Path, &e.Hash, &e.HashAlgo, &e.Timestamp, &e.Size, &e.IsDir,
+			&e.Deleted, &e.Uuid)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// scanEntry Scan the first row selected with id column followed by entryColumns
+// The second return value indicates whether any row is selected
+func scanEntry(rows *sql.Rows) (Entry, bool, error) {
+	entries, err := scanEntries(rows)
+	if err != nil || len(entries) == 0 {
+		return Entry{}, false, err
+	}
+	return entries[0], true, nil
+}
+
+// QueryEntry Query the entry by its path in the sync root
+// The second return value indicates whether the entry exists
+func (s *Store) QueryEntry(root string, path string) (Entry, bool, error) {
+	rows, err := s.db.Query("SELECT `id`, "+entryColumns+" FROM `entries` WHERE `root` = ? AND `path` = ? LIMIT 1",
+		root, path)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	return scanEntry(rows)
+}
+
+// QueryEntriesByPrefix Query the entries in the sync root whose path starts with the prefix, ordered by path
+// All the entries in the sync root are returned if the prefix is empty
+func (s *Store) QueryEntriesByPrefix(root string, prefix string) ([]Entry, error) {
+	lower, upper := prefixRange(prefix)
+	rows, err := s.db.Query("SELECT `id`, "+entryColumns+" FROM `entries` "+
+		"WHERE `root` = ? AND `path` >= ? AND `path` < ? ORDER BY `path`", root, lower, upper)
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+// upsertEntry Insert the entry, or update the entry with the same path in the sync root, and return its id
+func upsertEntry(ex executor, e Entry) (int64, error) {
+	rows, err := ex.Query("INSERT INTO `entries` ("+entryColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) "+
+		"ON CONFLICT (`root`, `path`) DO UPDATE SET `hash` = excluded.`hash`, `hash_algo` = excluded.`hash_algo`, "+
+		"`timestamp` = excluded.`timestamp`, `size` = excluded.`size`, `is_dir` = excluded.`is_dir`, "+
+		"`deleted` = excluded.`deleted`, `uuid` = excluded.`uuid` RETURNING `id`",
+		e.Root, e.Path, e.Hash, e.HashAlgo, e.Timestamp, e.Size, e.IsDir, e.Deleted, e.Uuid)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var id int64
+	if rows.Next() {
+		err = rows.Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return id, rows.Err()
+}
+
+// UpsertEntry Insert the entry, or update the entry with the same path in the sync root, and return its id
+func (s *Store) UpsertEntry(e Entry) (int64, error) {
+	return upsertEntry(s.db, e)
+}
+
+// InsertEntries Insert or update all the entries in one transaction
+func (s *Store) InsertEntries(entries []Entry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	for _, e := range entries {
+		_, err = upsertEntry(tx, e)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// MarkEntryDeleted Mark the entry as deleted with a new version
+// The entry is kept as a tombstone, so that the deletion can be synced
+func (s *Store) MarkEntryDeleted(root string, path string, uuid string, timestamp time.Time) error {
+	_, err := s.db.Exec("UPDATE `entries` SET `deleted` = 1, `uuid` = ?, `timestamp` = ? "+
+		"WHERE `root` = ? AND `path` = ?", uuid, timestamp, root, path)
+	return err
+}
+
+// UpdateEntryHash Replace the hash of the entry with the one computed by another algorithm over the same content
+// The last sync status recording the same content is updated as well
+func (s *Store) UpdateEntryHash(root string, path string, oldHash string, oldHashAlgo string,
+	hash string, hashAlgo string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	for _, table := range []string{"entries", "last_sync"} {
+		_, err = tx.Exec("UPDATE `"+table+"` SET `hash` = ?, `hash_algo` = ? "+
+			"WHERE `root` = ? AND `path` = ? AND `hash` = ? AND `hash_algo` = ?",
+			hash, hashAlgo, root, path, oldHash, oldHashAlgo)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// lastSyncScope Get the condition selecting the last sync status with the client, and its arguments
+// A client only syncs with the server, so its last_sync table is not scoped by client, and cid is ignored
+func (s *Store) lastSyncScope(cid int64) (string, []any) {
+	if s.role == ClientRole {
+		return "", nil
+	}
+	return "`cid` = ? AND ", []any{cid}
+}
+
+// QueryLastSync Query the last sync status of the entry with the client
+// The second return value indicates whether the status exists
+func (s *Store) QueryLastSync(cid int64, root string, path string) (Entry, bool, error) {
+	scope, args := s.lastSyncScope(cid)
+	rows, err := s.db.Query("SELECT `fid`, "+entryColumns+" FROM `last_sync` "+
+		"WHERE "+scope+"`root` = ? AND `path` = ? LIMIT 1", append(args, root, path)...)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	return scanEntry(rows)
+}
+
+// QueryLastSyncByPrefix Query the last sync status with the client of the entries in the sync root
+// whose path starts with the prefix, ordered by path
+// All the entries in the sync root are returned if the prefix is empty
+func (s *Store) QueryLastSyncByPrefix(cid int64, root string, prefix string) ([]Entry, error) {
+	scope, args := s.lastSyncScope(cid)
+	lower, upper := prefixRange(prefix)
+	rows, err := s.db.Query("SELECT `fid`, "+entryColumns+" FROM `last_sync` "+
+		"WHERE "+scope+"`root` = ? AND `path` >= ? AND `path` < ? ORDER BY `path`",
+		append(args, root, lower, upper)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+// Columns updated when the last sync status of an entry is recorded again
+const lastSyncUpdate = "`fid` = excluded.`fid`, `hash` = excluded.`hash`, `hash_algo` = excluded.`hash_algo`, " +
+	"`timestamp` = excluded.`timestamp`, `size` = excluded.`size`, `is_dir` = excluded.`is_dir`, " +
+	"`deleted` = excluded.`deleted`, `uuid` = excluded.`uuid`"
+
+// upsertLastSync Insert or update the last sync status of the entry with the client
+// The id of the entry is recorded as fid
+func (s *Store) upsertLastSync(ex executor, cid int64, e Entry) error {
+	if s.role == ClientRole {
+		_, err := ex.Exec("INSERT INTO `last_sync` (`fid`, "+entryColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) "+
+			"ON CONFLICT (`root`, `path`) DO UPDATE SET "+lastSyncUpdate,
+			e.Id, e.Root, e.Path, e.Hash, e.HashAlgo, e.Timestamp, e.Size, e.IsDir, e.Deleted, e.Uuid)
+		return err
+	}
+	_, err := ex.Exec("INSERT INTO `last_sync` (`fid`, `cid`, "+entryColumns+") "+
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (`cid`, `root`, `path`) DO UPDATE SET "+lastSyncUpdate,
+		e.Id, cid, e.Root, e.Path, e.Hash, e.HashAlgo, e.Timestamp, e.Size, e.IsDir, e.Deleted, e.Uuid)
+	return err
+}
+
+// UpsertLastSync Insert or update the last sync status of the entry with the client
+// The id of the entry is recorded as fid
+func (s *Store) UpsertLastSync(cid int64, e Entry) error {
+	return s.upsertLastSync(s.db, cid, e)
+}
+
+// InsertLastSyncs Insert or update the last sync status with the client of all the entries in one transaction
+func (s *Store) InsertLastSyncs(cid int64, entries []Entry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	for _, e := range entries {
+		err = s.upsertLastSync(tx, cid, e)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// MarkLastSyncDeleted Mark the last sync status of the entry with the client as deleted with the version
+func (s *Store) MarkLastSyncDeleted(cid int64, root string, path string, uuid string, timestamp time.Time) error {
+	scope, args := s.lastSyncScope(cid)
+	_, err := s.db.Exec("UPDATE `last_sync` SET `deleted` = 1, `uuid` = ?, `timestamp` = ? "+
+		"WHERE "+scope+"`root` = ? AND `path` = ?", append([]any{uuid, timestamp}, append(args, root, path)...)...)
+	return err
+}
+
+// CommitFileEntry Record a received file in entries, and the last sync status with the peer in last_sync,
+// and finish its transfer, all in the same transaction
+// move is called inside the transaction to put the file into place, so that the database
+// is only updated when the file is in place, and the file is only in place when the database can be updated
+// The function returned by move is called to put the files back when the transaction fails to commit
+func (s *Store) CommitFileEntry(cid int64, e Entry, transferId string, move func() (func(), error)) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	e.Id, err = upsertEntry(tx, e)
+	if err != nil {
+		return err
+	}
+	err = s.upsertLastSync(tx, cid, e)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM `transfers` WHERE `uuid` = ?", transferId)
+	if err != nil {
+		return err
+	}
+	restore, err := move()
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		restore()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package database
+
+import "fmt"
+
+// ErrDatabaseTooNew is returned when the database is migrated by a newer version of syncat
+type ErrDatabaseTooNew struct {
+	version   int
+	supported int
+}
+
+// Error returns the error message
+func (e ErrDatabaseTooNew) Error() string {
+	return fmt.Sprintf("database schema version %d is newer than the supported version %d", e.version, e.supported)
+}
+
+// ErrRoleMismatch is returned when the database belongs to the other side
+type ErrRoleMismatch struct {
+	role Role
+}
+
+// Error returns the error message
+func (e ErrRoleMismatch) Error() string {
+	return fmt.Sprintf("database does not belong to a %s", e.role)
+}
+
+// ErrClientNotFound is returned when no client is referred by the id, uuid, or name
+type ErrClientNotFound struct {
+	ref string
+}
+
+// Error returns the error message
+func (e ErrClientNotFound) Error() string {
+	return fmt.Sprintf("no client matches %s", e.ref)
+}
+
+// ErrAmbiguousClient is returned when the name is shared by several clients
+type ErrAmbiguousClient struct {
+	ref   string
+	count int
+}
+
+// Error returns the error message
+func (e ErrAmbiguousClient) Error() string {
+	return fmt.Sprintf("%d clients match %s, use the id or uuid instead", e.count, e.ref)
+}
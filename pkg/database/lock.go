@@ -0,0 +1,21 @@
+package database
+
+// TryLockRoot Lock the sync root for a sync session
+// Only one session is allowed on a sync root at a time, so that the changes of the clients are applied in turn
+// False is returned if the sync root is already locked
+func (s *Store) TryLockRoot(root string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lockedRoots[root] {
+		return false
+	}
+	s.lockedRoots[root] = true
+	return true
+}
+
+// UnlockRoot Unlock the sync root when the sync session ends
+func (s *Store) UnlockRoot(root string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.lockedRoots, root)
+}
@@ -0,0 +1,423 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migration is a forward change of the database schema
+// The schema version of the database is the number of migrations applied, tracked by PRAGMA user_version
+type migration struct {
+	// Description of the migration
+	description string
+	// Apply the migration inside the transaction, to the database of the role
+	up func(tx *sql.Tx, role Role) error
+}
+
+// migrations are applied in order, and must never be modified or reordered once released.
+// Changes to the schema must be made by appending new migrations.
+var migrations = []migration{
+	{"create tables", createTables},
+	{"pluggable hash columns", migrateHashColumns},
+	{"sync root column and path indexes", migrateRootColumns},
+	{"separate client and server schemas", splitSchemas},
+	{"conflicts of clients", createConflicts},
+	{"paused sync roots of clients", createPausedRoots},
+	{"management columns of clients", migrateClientManagement},
+	{"identity metadata of clients", migrateClientIdentity},
+}
+
+// Application ids recorded in the database header, telling which side the database belongs to
+const (
+	// "SYNS"
+	serverApplicationId = 0x53594e53
+	// "SYNC"
+	clientApplicationId = 0x53594e43
+)
+
+// SQL statements for creating tables
+var createTableSql = []string{ /* file entry table */ `
+	CREATE TABLE IF NOT EXISTS "entries" (
+		"id"		INTEGER PRIMARY KEY AUTOINCREMENT,
+		"path" 		VARCHAR(512) NOT NULL,
+		"hash_md5" 	VARCHAR(32) NOT NULL,
+		"timestamp" DATETIME NOT NULL,
+		"size" 		INTEGER NOT NULL,
+	    "is_dir"    INTEGER NOT NULL,
+		"deleted" 	INTEGER NOT NULL,
+		"uuid"		VARCHAR(36) NOT NULL
+	)
+	`,
+	/*
+	 * client table. server will use this table to store clients,
+	 * and clients will use this table to store their own uuid allocated by the server.
+	 * for clients, only the first row with id = 1 will be used.
+	 */`
+	CREATE TABLE IF NOT EXISTS "clients" (
+		"id"		INTEGER PRIMARY KEY AUTOINCREMENT,
+		"uuid"		VARCHAR(36) NOT NULL
+	)
+	`,
+	/*
+	 * last sync table. server will use this table to store the last syncing status of each client.
+	 * for clients, using cid = 1 will store the last syncing status of the server.
+	 * this table will be helpful when deciding which files to sync.
+	 */`
+	CREATE TABLE IF NOT EXISTS "last_sync" (
+		"fid"		INTEGER NOT NULL,
+		"cid"		INTEGER NOT NULL,
+		"path" 		VARCHAR(512) NOT NULL,
+		"hash_md5" 	VARCHAR(32) NOT NULL,
+		"timestamp" DATETIME NOT NULL,
+		"size" 		INTEGER NOT NULL,
+	    "is_dir"    INTEGER NOT NULL,
+		"deleted" 	INTEGER NOT NULL,
+		"uuid"		VARCHAR(36) NOT NULL,
+	    PRIMARY KEY (fid, cid)
+	)
+	`,
+	/*
+	 * transfer table. the receiving side of a file transfer stages the partial file
+	 * and records the verified offset here, so that an interrupted transfer can be resumed.
+	 */`
+	CREATE TABLE IF NOT EXISTS "transfers" (
+		"uuid"		VARCHAR(36) PRIMARY KEY,
+		"root"		VARCHAR(256) NOT NULL,
+		"path" 		VARCHAR(512) NOT NULL,
+		"hash_md5" 	VARCHAR(32) NOT NULL,
+		"size" 		INTEGER NOT NULL,
+		"offset" 	INTEGER NOT NULL,
+		"timestamp" DATETIME NOT NULL
+	)
+	`,
+}
+
+// migrate Apply the migrations not yet applied to the database, each in its own transaction
+// A database migrated by a newer version of syncat is refused, since its schema is unknown
+func (s *Store) migrate() error {
+	version, err := s.QuerySchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return ErrDatabaseTooNew{version, len(migrations)}
+	}
+	err = s.checkRole()
+	if err != nil {
+		return err
+	}
+	for ; version < len(migrations); version++ {
+		err = s.applyMigration(version+1, migrations[version])
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", version+1, migrations[version].description, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration Apply the migration and bump the schema version in the same transaction
+func (s *Store) applyMigration(version int, m migration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	err = m.up(tx.Tx, s.role)
+	if err != nil {
+		return err
+	}
+	// PRAGMA does not accept bound parameters
+	_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// QuerySchemaVersion Query the schema version of the database
+func (s *Store) QuerySchemaVersion() (int, error) {
+	var version int
+	err := s.db.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// checkRole Check whether the database belongs to the role of the store
+// Databases not yet migrated to separate schemas do not record their role, and are accepted
+func (s *Store) checkRole() error {
+	var applicationId int
+	err := s.db.QueryRow("PRAGMA application_id").Scan(&applicationId)
+	if err != nil {
+		return err
+	}
+	if applicationId != 0 && applicationId != roleApplicationId(s.role) {
+		return ErrRoleMismatch{s.role}
+	}
+	return nil
+}
+
+// roleApplicationId Get the application id recorded for the role
+func roleApplicationId(role Role) int {
+	if role == ClientRole {
+		return clientApplicationId
+	}
+	return serverApplicationId
+}
+
+// execAll Execute the statements in order inside the transaction, stopping at the first error
+func execAll(tx *sql.Tx, statements []string) error {
+	for _, s := range statements {
+		_, err := tx.Exec(s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createTables Create the tables if not exist
+// Databases created before the migrations were introduced already have the tables, and are left untouched
+func createTables(tx *sql.Tx, _ Role) error {
+	return execAll(tx, createTableSql)
+}
+
+// Tables that used to store the hash in "hash_md5" column, before the hash algorithm became pluggable
+var hashTables = []string{"entries", "last_sync", "transfers"}
+
+// migrateHashColumns Migrate the tables created before the hash algorithm became pluggable
+// "hash_md5" column is renamed to "hash", and "hash_algo" column is added with md5 as the algorithm.
+// The md5 hashes are kept, and will be replaced lazily when the entries are accessed with another algorithm.
+// Databases created before the migrations were introduced may already have the new columns.
+func migrateHashColumns(tx *sql.Tx, _ Role) error {
+	for _, table := range hashTables {
+		exists, err := queryExistsColumn(tx, table, "hash_md5")
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		_, err = tx.Exec("ALTER TABLE `" + table + "` RENAME COLUMN `hash_md5` TO `hash`")
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("ALTER TABLE `" + table + "` ADD COLUMN `hash_algo` VARCHAR(16) NOT NULL DEFAULT 'md5'")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryExistsColumn Query if the column exists in the table
+func queryExistsColumn(tx *sql.Tx, table string, column string) (bool, error) {
+	row, err := tx.Query("SELECT 1 FROM pragma_table_info(?) WHERE `name` = ? LIMIT 1", table, column)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = row.Close()
+	}()
+	return row.Next(), nil
+}
+
+// SQL statements for separating the sync root from the path, and indexing the entries by path
+var rootColumnSql = []string{
+	"ALTER TABLE `entries` ADD COLUMN `root` VARCHAR(256) NOT NULL DEFAULT ''",
+	"ALTER TABLE `last_sync` ADD COLUMN `root` VARCHAR(256) NOT NULL DEFAULT ''",
+	// paths used to be recorded as "root/path"
+	"UPDATE `entries` SET `root` = substr(`path`, 1, instr(`path`, '/') - 1), " +
+		"`path` = substr(`path`, instr(`path`, '/') + 1) WHERE instr(`path`, '/') > 0",
+	"UPDATE `last_sync` SET `root` = substr(`path`, 1, instr(`path`, '/') - 1), " +
+		"`path` = substr(`path`, instr(`path`, '/') + 1) WHERE instr(`path`, '/') > 0",
+	// nothing prevented duplicated paths before, keep the latest ones
+	"DELETE FROM `entries` WHERE `id` NOT IN (SELECT MAX(`id`) FROM `entries` GROUP BY `root`, `path`)",
+	"DELETE FROM `last_sync` WHERE `fid` NOT IN (SELECT `id` FROM `entries`)",
+	`CREATE UNIQUE INDEX "entries_root_path" ON "entries" ("root", "path")`,
+	`CREATE UNIQUE INDEX "last_sync_cid_root_path" ON "last_sync" ("cid", "root", "path")`,
+}
+
+// migrateRootColumns Record the sync root of entries in its own column, and index the entries by path
+func migrateRootColumns(tx *sql.Tx, _ Role) error {
+	return execAll(tx, rootColumnSql)
+}
+
+/*
+ * SQL statements for the server schema.
+ * clients table becomes the registry of clients, with unique uuid and the time of registration.
+ */
+var serverSchemaSql = []string{`
+	CREATE TABLE "clients_registry" (
+		"id"		INTEGER PRIMARY KEY AUTOINCREMENT,
+		"uuid"		VARCHAR(36) NOT NULL UNIQUE,
+		"registered" DATETIME NOT NULL
+	)
+	`,
+	"INSERT INTO `clients_registry` (`id`, `uuid`, `registered`) SELECT `id`, `uuid`, CURRENT_TIMESTAMP FROM `clients`",
+	"DROP TABLE `clients`",
+	"ALTER TABLE `clients_registry` RENAME TO `clients`",
+}
+
+/*
+ * SQL statements for the client schema.
+ * server_identity table holds the uuid allocated to the client by the server, which used to be the row with id = 1
+ * in clients table. last_sync table only records the last syncing status with the server, which used to use cid = 1.
+ */
+var clientSchemaSql = []string{`
+	CREATE TABLE "server_identity" (
+		"id"			INTEGER PRIMARY KEY CHECK ("id" = 1),
+		"client_uuid"	VARCHAR(36) NOT NULL,
+		"updated"		DATETIME NOT NULL
+	)
+	`,
+	"INSERT INTO `server_identity` (`id`, `client_uuid`, `updated`) " +
+		"SELECT 1, `uuid`, CURRENT_TIMESTAMP FROM `clients` WHERE `id` = 1",
+	"DROP TABLE `clients`",
+	`
+	CREATE TABLE "server_sync" (
+		"fid"		INTEGER PRIMARY KEY,
+		"root"		VARCHAR(256) NOT NULL,
+		"path" 		VARCHAR(512) NOT NULL,
+		"hash" 		VARCHAR(128) NOT NULL,
+		"hash_algo"	VARCHAR(16) NOT NULL DEFAULT 'md5',
+		"timestamp" DATETIME NOT NULL,
+		"size" 		INTEGER NOT NULL,
+	    "is_dir"    INTEGER NOT NULL,
+		"deleted" 	INTEGER NOT NULL,
+		"uuid"		VARCHAR(36) NOT NULL
+	)
+	`,
+	"INSERT INTO `server_sync` (`fid`, `root`, `path`, `hash`, `hash_algo`, `timestamp`, `size`, `is_dir`, " +
+		"`deleted`, `uuid`) SELECT `fid`, `root`, `path`, `hash`, `hash_algo`, `timestamp`, `size`, `is_dir`, " +
+		"`deleted`, `uuid` FROM `last_sync` WHERE `cid` = 1",
+	"DROP TABLE `last_sync`",
+	"ALTER TABLE `server_sync` RENAME TO `last_sync`",
+	`CREATE UNIQUE INDEX "last_sync_root_path" ON "last_sync" ("root", "path")`,
+}
+
+// splitSchemas Separate the schemas of client and server, which used to share clients and last_sync tables
+// with different meanings of their rows
+func splitSchemas(tx *sql.Tx, role Role) error {
+	client, err := looksLikeClient(tx)
+	if err != nil {
+		return err
+	}
+	statements := serverSchemaSql
+	if role == ClientRole {
+		// a client has at most its own row in clients table, refuse to drop the registry of a server
+		var count int
+		err = tx.QueryRow("SELECT COUNT(*) FROM `clients`").Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 1 {
+			return ErrRoleMismatch{role}
+		}
+		statements = clientSchemaSql
+	} else if client {
+		// refuse to turn the identity of a client into the registry of a server
+		return ErrRoleMismatch{role}
+	}
+	err = execAll(tx, statements)
+	if err != nil {
+		return err
+	}
+	// PRAGMA does not accept bound parameters
+	_, err = tx.Exec(fmt.Sprintf("PRAGMA application_id = %d", roleApplicationId(role)))
+	return err
+}
+
+// looksLikeClient Check whether the database not yet migrated to separate schemas belongs to a client
+// A client records its own uuid as the row with id = 1 in clients table, and the last sync status with the server
+// with cid = 1 in last_sync table. A server whose only client has synced looks the same, and is taken as a client
+func looksLikeClient(tx *sql.Tx) (bool, error) {
+	var clients, others int
+	err := tx.QueryRow("SELECT COUNT(*), COUNT(NULLIF(`id`, 1)) FROM `clients`").Scan(&clients, &others)
+	if err != nil || clients != 1 || others != 0 {
+		return false, err
+	}
+	var synced int
+	err = tx.QueryRow("SELECT COUNT(*), COUNT(NULLIF(`cid`, 1)) FROM `last_sync`").Scan(&synced, &others)
+	if err != nil {
+		return false, err
+	}
+	return synced > 0 && others == 0, nil
+}
+
+// SQL statements for creating the table of conflicts detected by a client
+var conflictsSql = []string{`
+	CREATE TABLE "conflicts" (
+		"id"			INTEGER PRIMARY KEY AUTOINCREMENT,
+		"root"			VARCHAR(256) NOT NULL,
+		"path"			VARCHAR(512) NOT NULL,
+		"conflict_path"	VARCHAR(512) NOT NULL,
+		"server_uuid"	VARCHAR(36) NOT NULL,
+		"detected"		DATETIME NOT NULL,
+		"resolved"		INTEGER NOT NULL DEFAULT 0
+	)
+	`,
+	`CREATE INDEX "conflicts_root_path" ON "conflicts" ("root", "path")`,
+}
+
+// createConflicts Create the table recording the conflicts detected by a client
+// Conflicts are resolved by the user, so the server does not keep them
+func createConflicts(tx *sql.Tx, role Role) error {
+	if role != ClientRole {
+		return nil
+	}
+	return execAll(tx, conflictsSql)
+}
+
+// SQL statements for creating the table of sync roots paused on a client
+var pausedRootsSql = []string{`
+	CREATE TABLE "paused_roots" (
+		"root"		VARCHAR(256) PRIMARY KEY,
+		"paused"	DATETIME NOT NULL
+	)
+	`,
+}
+
+// createPausedRoots Create the table recording the sync roots paused on a client
+func createPausedRoots(tx *sql.Tx, role Role) error {
+	if role != ClientRole {
+		return nil
+	}
+	return execAll(tx, pausedRootsSql)
+}
+
+// SQL statements for adding the columns managing the clients registered on a server
+var clientManagementSql = []string{
+	"ALTER TABLE `clients` ADD COLUMN `name` VARCHAR(256) NOT NULL DEFAULT ''",
+	"ALTER TABLE `clients` ADD COLUMN `last_seen` DATETIME",
+	"ALTER TABLE `clients` ADD COLUMN `last_sync_time` DATETIME",
+	"ALTER TABLE `clients` ADD COLUMN `last_sync_root` VARCHAR(256) NOT NULL DEFAULT ''",
+	"ALTER TABLE `clients` ADD COLUMN `last_sync_result` VARCHAR(32) NOT NULL DEFAULT ''",
+	"ALTER TABLE `clients` ADD COLUMN `revoked` INTEGER NOT NULL DEFAULT 0",
+}
+
+// migrateClientManagement Add the columns for managing the clients registered on a server
+func migrateClientManagement(tx *sql.Tx, role Role) error {
+	if role != ServerRole {
+		return nil
+	}
+	return execAll(tx, clientManagementSql)
+}
+
+// SQL statements for adding the identity metadata reported by the clients at authentication
+// The time of registration is the time the client is first seen
+var clientIdentitySql = []string{
+	"ALTER TABLE `clients` RENAME COLUMN `registered` TO `first_seen`",
+	"ALTER TABLE `clients` ADD COLUMN `device_name` VARCHAR(256) NOT NULL DEFAULT ''",
+	"ALTER TABLE `clients` ADD COLUMN `hostname` VARCHAR(256) NOT NULL DEFAULT ''",
+	"ALTER TABLE `clients` ADD COLUMN `os` VARCHAR(64) NOT NULL DEFAULT ''",
+	"ALTER TABLE `clients` ADD COLUMN `version` VARCHAR(64) NOT NULL DEFAULT ''",
+}
+
+// migrateClientIdentity Add the identity metadata of the clients registered on a server
+func migrateClientIdentity(tx *sql.Tx, role Role) error {
+	if role != ServerRole {
+		return nil
+	}
+	return execAll(tx, clientIdentitySql)
+}
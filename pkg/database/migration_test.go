@@ -0,0 +1,184 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"path/filepath"
+	"testing"
+)
+
+// baselineDatabase Create a database with the schema before the migrations were introduced, run the statements
+// on it, and return its configuration
+func baselineDatabase(t *testing.T, statements ...string) config.SyncatDBConfig {
+	t.Helper()
+	dbConfig := config.SyncatDBConfig{Filename: filepath.Join(t.TempDir(), "syncat.db")}
+	db, err := sql.Open("sqlite3", getDbConnectionString(dbConfig))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	for _, s := range append(createTableSql, statements...) {
+		_, err = db.Exec(s)
+		if err != nil {
+			t.Fatalf("failed to execute %q: %v", s, err)
+		}
+	}
+	return dbConfig
+}
+
+// loadDatabase Load the database for the role, and close it when the test ends
+func loadDatabase(t *testing.T, dbConfig config.SyncatDBConfig, role Role) *Store {
+	t.Helper()
+	s, err := LoadDatabase(dbConfig, role)
+	if err != nil {
+		t.Fatalf("failed to load %s database: %v", role, err)
+	}
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	return s
+}
+
+// TestMigrateNewDatabase A new database is migrated to the latest version, and loading it again changes nothing
+func TestMigrateNewDatabase(t *testing.T) {
+	for _, role := range []Role{ServerRole, ClientRole} {
+		dbConfig := config.SyncatDBConfig{Filename: filepath.Join(t.TempDir(), "syncat.db")}
+		s, err := LoadDatabase(dbConfig, role)
+		if err != nil {
+			t.Fatalf("failed to load new %s database: %v", role, err)
+		}
+		_ = s.Close()
+		s = loadDatabase(t, dbConfig, role)
+		version, err := s.QuerySchemaVersion()
+		if err != nil || version != len(migrations) {
+			t.Fatalf("%s schema version = %d, %v, want %d", role, version, err, len(migrations))
+		}
+	}
+}
+
+// TestMigrateRefusesNewerDatabase A database migrated by a newer version of syncat is refused
+func TestMigrateRefusesNewerDatabase(t *testing.T) {
+	dbConfig := baselineDatabase(t, fmt.Sprintf("PRAGMA user_version = %d", len(migrations)+1))
+	s, err := LoadDatabase(dbConfig, ServerRole)
+	if err == nil {
+		_ = s.Close()
+	}
+	var tooNew ErrDatabaseTooNew
+	if !errors.As(err, &tooNew) {
+		t.Fatalf("err = %v, want ErrDatabaseTooNew", err)
+	}
+}
+
+// TestMigrateFromBaseline The rows of a database created before the migrations were introduced are kept
+// through all the migrations
+func TestMigrateFromBaseline(t *testing.T) {
+	entries := []string{
+		"INSERT INTO `entries` (`id`, `path`, `hash_md5`, `timestamp`, `size`, `is_dir`, `deleted`, `uuid`) " +
+			"VALUES (1, 'sync/a.txt', 'md5-of-a', '2020-01-01 00:00:00', 1, 0, 0, 'version-a')",
+		"INSERT INTO `entries` (`id`, `path`, `hash_md5`, `timestamp`, `size`, `is_dir`, `deleted`, `uuid`) " +
+			"VALUES (2, 'sync/dir', '', '2020-01-01 00:00:00', 0, 1, 0, 'version-dir')",
+	}
+
+	t.Run("server", func(t *testing.T) {
+		dbConfig := baselineDatabase(t, append(entries,
+			"INSERT INTO `clients` (`id`, `uuid`) VALUES (1, 'client-1'), (2, 'client-2')",
+			"INSERT INTO `last_sync` (`fid`, `cid`, `path`, `hash_md5`, `timestamp`, `size`, `is_dir`, `deleted`, "+
+				"`uuid`) VALUES (1, 2, 'sync/a.txt', 'md5-of-a', '2020-01-01 00:00:00', 1, 0, 0, 'version-a')",
+		)...)
+		s := loadDatabase(t, dbConfig, ServerRole)
+		assertMigratedEntries(t, s)
+		clients, err := s.QueryClients()
+		if err != nil || len(clients) != 2 || clients[1].Uuid != "client-2" {
+			t.Fatalf("clients = %+v, %v, want both clients kept", clients, err)
+		}
+		e, ok, err := s.QueryLastSync(2, "sync", "a.txt")
+		if err != nil || !ok || e.Uuid != "version-a" {
+			t.Fatalf("last sync of client 2 = %+v, %v, %v, want version-a", e, ok, err)
+		}
+	})
+
+	t.Run("client", func(t *testing.T) {
+		dbConfig := baselineDatabase(t, append(entries,
+			"INSERT INTO `clients` (`id`, `uuid`) VALUES (1, 'own-uuid')",
+			"INSERT INTO `last_sync` (`fid`, `cid`, `path`, `hash_md5`, `timestamp`, `size`, `is_dir`, `deleted`, "+
+				"`uuid`) VALUES (1, 1, 'sync/a.txt', 'md5-of-a', '2020-01-01 00:00:00', 1, 0, 0, 'version-a')",
+		)...)
+		s := loadDatabase(t, dbConfig, ClientRole)
+		assertMigratedEntries(t, s)
+		uuid, err := s.QueryClientUuid()
+		if err != nil || uuid != "own-uuid" {
+			t.Fatalf("client uuid = %q, %v, want own-uuid", uuid, err)
+		}
+		e, ok, err := s.QueryLastSync(0, "sync", "a.txt")
+		if err != nil || !ok || e.Uuid != "version-a" {
+			t.Fatalf("last sync with server = %+v, %v, %v, want version-a", e, ok, err)
+		}
+	})
+}
+
+// assertMigratedEntries Assert that the entries of baselineDatabase are at the latest version with their data
+func assertMigratedEntries(t *testing.T, s *Store) {
+	t.Helper()
+	version, err := s.QuerySchemaVersion()
+	if err != nil || version != len(migrations) {
+		t.Fatalf("schema version = %d, %v, want %d", version, err, len(migrations))
+	}
+	e, ok, err := s.QueryEntry("sync", "a.txt")
+	if err != nil || !ok {
+		t.Fatalf("entry sync/a.txt = %v, %v, want it split into root and path", ok, err)
+	}
+	if e.Hash != "md5-of-a" || e.HashAlgo != "md5" || e.Uuid != "version-a" {
+		t.Fatalf("entry sync/a.txt = %+v, want the md5 hash and version kept", e)
+	}
+	e, ok, err = s.QueryEntry("sync", "dir")
+	if err != nil || !ok || !e.IsDir {
+		t.Fatalf("entry sync/dir = %+v, %v, %v, want a directory", e, ok, err)
+	}
+}
+
+// TestMigrateRefusesOtherRole A database not yet migrated to separate schemas is refused by the other side
+func TestMigrateRefusesOtherRole(t *testing.T) {
+	entry := "INSERT INTO `entries` (`id`, `path`, `hash_md5`, `timestamp`, `size`, `is_dir`, `deleted`, `uuid`) " +
+		"VALUES (1, 'sync/a.txt', 'md5-of-a', '2020-01-01 00:00:00', 1, 0, 0, 'version-a')"
+	lastSync := "INSERT INTO `last_sync` (`fid`, `cid`, `path`, `hash_md5`, `timestamp`, `size`, `is_dir`, " +
+		"`deleted`, `uuid`) VALUES (1, %d, 'sync/a.txt', 'md5-of-a', '2020-01-01 00:00:00', 1, 0, 0, 'version-a')"
+	tests := []struct {
+		name       string
+		role       Role
+		statements []string
+	}{
+		{"server as client", ClientRole, []string{
+			entry,
+			"INSERT INTO `clients` (`id`, `uuid`) VALUES (1, 'client-1'), (2, 'client-2')",
+			fmt.Sprintf(lastSync, 2),
+		}},
+		{"client as server", ServerRole, []string{
+			entry,
+			"INSERT INTO `clients` (`id`, `uuid`) VALUES (1, 'own-uuid')",
+			fmt.Sprintf(lastSync, 1),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbConfig := baselineDatabase(t, tt.statements...)
+			s, err := LoadDatabase(dbConfig, tt.role)
+			if err == nil {
+				_ = s.Close()
+			}
+			var mismatch ErrRoleMismatch
+			if !errors.As(err, &mismatch) {
+				t.Fatalf("err = %v, want ErrRoleMismatch", err)
+			}
+			// the database is left as it was, and still opens for its own role
+			other := ServerRole
+			if tt.role == ServerRole {
+				other = ClientRole
+			}
+			loadDatabase(t, dbConfig, other)
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package database
+
+import "time"
+
+// QueryPausedRoots Query the sync roots paused on the client
+func (s *Store) QueryPausedRoots() ([]string, error) {
+	rows, err := s.db.Query("SELECT `root` FROM `paused_roots` ORDER BY `root`")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var roots []string
+	for rows.Next() {
+		var root string
+		err = rows.Scan(&root)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+	return roots, rows.Err()
+}
+
+// UpdateRootPaused Pause or resume the sync root on the client
+func (s *Store) UpdateRootPaused(root string, paused bool) error {
+	if !paused {
+		_, err := s.db.Exec("DELETE FROM `paused_roots` WHERE `root` = ?", root)
+		return err
+	}
+	_, err := s.db.Exec("INSERT INTO `paused_roots` (`root`, `paused`) VALUES (?, ?) ON CONFLICT (`root`) DO NOTHING",
+		root, time.Now())
+	return err
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"database/sql"
+	"github.com/JeffersonQin/syncat/pkg/metrics"
+	"time"
+)
+
+// timedDB is the database connection recording the latency of every statement and transaction in the metrics
+type timedDB struct {
+	*sql.DB
+}
+
+// observe Record the latency of the database operation started at the time
+func observe(operation string, start time.Time) {
+	metrics.DatabaseDuration.Observe(time.Since(start).Seconds(), operation)
+}
+
+// Exec Execute the statement and record its latency
+func (d timedDB) Exec(query string, args ...any) (sql.Result, error) {
+	defer observe("exec", time.Now())
+	return d.DB.Exec(query, args...)
+}
+
+// Query Execute the query and record its latency, excluding reading the rows
+func (d timedDB) Query(query string, args ...any) (*sql.Rows, error) {
+	defer observe("query", time.Now())
+	return d.DB.Query(query, args...)
+}
+
+// QueryRow Execute the query and record its latency, excluding scanning the row
+func (d timedDB) QueryRow(query string, args ...any) *sql.Row {
+	defer observe("query", time.Now())
+	return d.DB.QueryRow(query, args...)
+}
+
+// Begin Start a transaction, whose latency is recorded when it is committed
+func (d timedDB) Begin() (*timedTx, error) {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &timedTx{Tx: tx, start: time.Now()}, nil
+}
+
+// timedTx is the transaction recording its latency from begin to commit
+type timedTx struct {
+	*sql.Tx
+	start time.Time
+}
+
+// Commit Commit the transaction and record its latency
+func (t *timedTx) Commit() error {
+	defer observe("transaction", t.start)
+	return t.Tx.Commit()
+}
@@ -0,0 +1,13 @@
+package hashing
+
+import "fmt"
+
+// ErrUnsupportedAlgorithm is returned when the hash algorithm is not supported
+type ErrUnsupportedAlgorithm struct {
+	algorithm string
+}
+
+// Error returns the error message
+func (e ErrUnsupportedAlgorithm) Error() string {
+	return fmt.Sprintf("unsupported hash algorithm: %s", e.algorithm)
+}
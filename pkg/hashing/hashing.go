@@ -0,0 +1,116 @@
+package hashing
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
+	"hash"
+	"io"
+	"os"
+)
+
+// Algorithm is the name of a content hash algorithm, recorded with every hash in database and protocol
+type Algorithm string
+
+const (
+	// MD5 is the legacy algorithm, kept for the entries recorded before the hash became pluggable
+	MD5 Algorithm = "md5"
+	// SHA256 is the SHA-256 algorithm
+	SHA256 Algorithm = "sha256"
+	// BLAKE2b256 is the BLAKE2b algorithm with 256-bit digest
+	BLAKE2b256 Algorithm = "blake2b-256"
+	// BLAKE2s256 is the BLAKE2s algorithm with 256-bit digest
+	BLAKE2s256 Algorithm = "blake2s-256"
+)
+
+// Supported is the list of supported algorithms, in the order of preference
+var Supported = []Algorithm{BLAKE2b256, SHA256, BLAKE2s256, MD5}
+
+// Parse the name of an algorithm
+// The empty name refers to MD5, which is used by peers not aware of the pluggable hash
+func Parse(name string) (Algorithm, error) {
+	if name == "" {
+		return MD5, nil
+	}
+	for _, a := range Supported {
+		if string(a) == name {
+			return a, nil
+		}
+	}
+	return "", ErrUnsupportedAlgorithm{name}
+}
+
+// Names Get the names of the algorithms
+func Names(algorithms []Algorithm) []string {
+	names := make([]string, len(algorithms))
+	for i, a := range algorithms {
+		names[i] = string(a)
+	}
+	return names
+}
+
+// Negotiate Choose the most preferred supported algorithm among the ones offered by the peer
+// ErrUnsupportedAlgorithm is returned if none of them is supported, or the peer offers nothing
+func Negotiate(offered []string) (Algorithm, error) {
+	for _, a := range Supported {
+		for _, name := range offered {
+			if string(a) == name {
+				return a, nil
+			}
+		}
+	}
+	return "", ErrUnsupportedAlgorithm{fmt.Sprint(offered)}
+}
+
+// New Create a new hash.Hash of the algorithm
+func (a Algorithm) New() (hash.Hash, error) {
+	switch a {
+	case MD5:
+		return md5.New(), nil
+	case SHA256:
+		return sha256.New(), nil
+	case BLAKE2b256:
+		return blake2b.New256(nil)
+	case BLAKE2s256:
+		return blake2s.New256(nil)
+	}
+	return nil, ErrUnsupportedAlgorithm{string(a)}
+}
+
+// Reader Calculate the hex digest of everything read from the reader
+func (a Algorithm) Reader(r io.Reader) (string, error) {
+	h, err := a.New()
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Bytes Calculate the hex digest of the bytes
+func (a Algorithm) Bytes(b []byte) (string, error) {
+	h, err := a.New()
+	if err != nil {
+		return "", err
+	}
+	_, _ = h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// File Calculate the hex digest of the content of the file
+func (a Algorithm) File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return a.Reader(f)
+}
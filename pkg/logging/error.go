@@ -0,0 +1,23 @@
+package logging
+
+import "fmt"
+
+// ErrInvalidLevel is returned when the configured log level is unknown
+type ErrInvalidLevel struct {
+	level string
+}
+
+// Error returns the error message
+func (e ErrInvalidLevel) Error() string {
+	return fmt.Sprintf("invalid log level: %s, expected debug, info, warn, or error", e.level)
+}
+
+// ErrInvalidFormat is returned when the configured log format is unknown
+type ErrInvalidFormat struct {
+	format string
+}
+
+// Error returns the error message
+func (e ErrInvalidFormat) Error() string {
+	return fmt.Sprintf("invalid log format: %s, expected json or text", e.format)
+}
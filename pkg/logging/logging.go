@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"golang.org/x/exp/slog"
+	"os"
+	"strings"
+)
+
+// Formats of the log output
+const (
+	// JSONFormat writes a JSON object per line, which is the default
+	JSONFormat = "json"
+	// TextFormat writes key=value pairs per line, which is easier to read in a terminal
+	TextFormat = "text"
+)
+
+// level is the minimum level logged, shared by all the loggers
+var level slog.LevelVar
+
+// ParseLevel Parse the name of the level, info if empty
+// ErrInvalidLevel is returned if the name is not a level
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, ErrInvalidLevel{name}
+}
+
+// Setup Set the default logger to write the records of the configured level and format to stderr
+// The output of the log package is redirected to the logger as well
+func Setup(logConfig config.SyncatLogConfig) error {
+	l, err := ParseLevel(logConfig.Level)
+	if err != nil {
+		return err
+	}
+	opts := slog.HandlerOptions{Level: &level}
+	var handler slog.Handler
+	switch strings.ToLower(logConfig.Format) {
+	case "", JSONFormat:
+		handler = opts.NewJSONHandler(os.Stderr)
+	case TextFormat:
+		handler = opts.NewTextHandler(os.Stderr)
+	default:
+		return ErrInvalidFormat{logConfig.Format}
+	}
+	level.Set(l)
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// SetLevel Change the minimum level logged by the logger set up, e.g. when the configuration is reloaded
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
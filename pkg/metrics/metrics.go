@@ -0,0 +1,218 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// kind is the type of metric family in Prometheus text format
+type kind string
+
+const (
+	counterKind   kind = "counter"
+	gaugeKind     kind = "gauge"
+	histogramKind kind = "histogram"
+)
+
+// series is the samples of a metric family with the same label values
+type series struct {
+	labelValues []string
+	// value is the value of counters and gauges
+	value float64
+	// counts are the cumulative counts of histograms in each bucket
+	counts []uint64
+	// sum and count are the sum and count of the observations of histograms
+	sum   float64
+	count uint64
+}
+
+// family is a metric with its series
+type family struct {
+	name   string
+	help   string
+	kind   kind
+	labels []string
+	// buckets are the upper bounds of histogram buckets, in increasing order
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*series
+}
+
+// registry are all the metric families written by Write, in the order they are created
+var registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+// newFamily Create a metric family and register it
+func newFamily(name string, help string, kind kind, buckets []float64, labels []string) *family {
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.families = append(registry.families, f)
+	return f
+}
+
+// with Get the series of the label values, creating it if absent
+// The caller must hold f.mu
+func (f *family) with(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic("metrics: " + f.name + " expects " + strconv.Itoa(len(f.labels)) + " label values")
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.kind == histogramKind {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Counter is a value that only goes up, e.g. the number of connections accepted
+type Counter struct {
+	f *family
+}
+
+// NewCounter Create and register a counter with the label names
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return &Counter{newFamily(name, help, counterKind, nil, labels)}
+}
+
+// Add Add the value to the counter of the label values
+func (c *Counter) Add(value float64, labelValues ...string) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.with(labelValues).value += value
+}
+
+// Inc Increment the counter of the label values by one
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Gauge is a value that goes up and down, e.g. the number of open connections
+type Gauge struct {
+	f *family
+}
+
+// NewGauge Create and register a gauge with the label names
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	return &Gauge{newFamily(name, help, gaugeKind, nil, labels)}
+}
+
+// Add Add the value to the gauge of the label values, which can be negative
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.with(labelValues).value += value
+}
+
+// Histogram counts observations in buckets, e.g. the durations of sync sessions
+type Histogram struct {
+	f *family
+}
+
+// NewHistogram Create and register a histogram with the upper bounds of buckets and the label names
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{newFamily(name, help, histogramKind, buckets, labels)}
+}
+
+// Observe Record the observation in the histogram of the label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s := h.f.with(labelValues)
+	for i, bound := range h.f.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// Write Write all the metrics in Prometheus text format
+func Write(w io.Writer) error {
+	registry.mu.Lock()
+	families := append([]*family(nil), registry.families...)
+	registry.mu.Unlock()
+	b := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(b)
+	}
+	return b.Flush()
+}
+
+// write Write the family with its series ordered by label values
+func (f *family) write(b *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b.WriteString("# HELP " + f.name + " " + escape(f.help, false) + "\n")
+	b.WriteString("# TYPE " + f.name + " " + string(f.kind) + "\n")
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != histogramKind {
+			writeSample(b, f.name, f.labels, s.labelValues, "", "", s.value)
+			continue
+		}
+		for i, bound := range f.buckets {
+			writeSample(b, f.name+"_bucket", f.labels, s.labelValues, "le", formatFloat(bound), float64(s.counts[i]))
+		}
+		writeSample(b, f.name+"_bucket", f.labels, s.labelValues, "le", "+Inf", float64(s.count))
+		writeSample(b, f.name+"_sum", f.labels, s.labelValues, "", "", s.sum)
+		writeSample(b, f.name+"_count", f.labels, s.labelValues, "", "", float64(s.count))
+	}
+}
+
+// writeSample Write a line of sample, with an extra label if extraName is not empty
+func writeSample(b *bufio.Writer, name string, labels []string, labelValues []string,
+	extraName string, extraValue string, value float64) {
+	b.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		pairs := make([]string, 0, len(labels)+1)
+		for i, label := range labels {
+			pairs = append(pairs, label+"=\""+escape(labelValues[i], true)+"\"")
+		}
+		if extraName != "" {
+			pairs = append(pairs, extraName+"=\""+extraValue+"\"")
+		}
+		b.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	b.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// escape Escape the help text or the label value
+func escape(s string, quote bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quote {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return s
+}
+
+// formatFloat Format the value in the shortest representation
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
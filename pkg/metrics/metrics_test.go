@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestWriteTextFormat The metrics are written in Prometheus text format, with their series ordered by label values
+// and the help texts and label values escaped
+func TestWriteTextFormat(t *testing.T) {
+	counter := NewCounter("test_requests_total", "Requests handled,\nby \\ type", "type")
+	counter.Inc("get")
+	counter.Add(2, `say "hi"`+"\n"+`\`)
+	gauge := NewGauge("test_open", "Open things")
+	gauge.Add(3)
+	gauge.Add(-1)
+	histogram := NewHistogram("test_duration_seconds", "Durations", []float64{1, 0.5}, "result")
+	histogram.Observe(0.2, "ok")
+	histogram.Observe(0.7, "ok")
+	histogram.Observe(3, "ok")
+
+	var b bytes.Buffer
+	err := Write(&b)
+	if err != nil {
+		t.Fatalf("failed to write metrics: %v", err)
+	}
+	want := `# HELP test_requests_total Requests handled,\nby \\ type
+# TYPE test_requests_total counter
+test_requests_total{type="get"} 1
+test_requests_total{type="say \"hi\"\n\\"} 2
+# HELP test_open Open things
+# TYPE test_open gauge
+test_open 2
+# HELP test_duration_seconds Durations
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{result="ok",le="0.5"} 1
+test_duration_seconds_bucket{result="ok",le="1"} 2
+test_duration_seconds_bucket{result="ok",le="+Inf"} 3
+test_duration_seconds_sum{result="ok"} 3.9
+test_duration_seconds_count{result="ok"} 3
+`
+	if !strings.Contains(b.String(), want) {
+		t.Fatalf("metrics written:\n%s\nwant them to contain:\n%s", b.String(), want)
+	}
+}
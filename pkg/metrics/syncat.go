@@ -0,0 +1,31 @@
+package metrics
+
+// Metrics of syncat, collected by the shared packages and exposed by the server
+var (
+	// ConnectionsTotal is the number of connections accepted by the server
+	ConnectionsTotal = NewCounter("syncat_connections_total", "Number of connections accepted.")
+	// ConnectionsActive is the number of connections currently open on the server
+	ConnectionsActive = NewGauge("syncat_connections_active", "Number of connections currently open.")
+	// ConnectionsRejected is the number of connections closed right after accepted, by reason (limit or lockout)
+	ConnectionsRejected = NewCounter("syncat_connections_rejected_total", "Number of connections rejected.", "reason")
+	// AuthFailures is the number of rejected authentications, by reason
+	AuthFailures = NewCounter("syncat_auth_failures_total", "Number of rejected authentications.", "reason")
+	// Packets is the number of packets, by direction (received or sent) and packet type
+	Packets = NewCounter("syncat_packets_total", "Number of packets received or sent.", "direction", "type")
+	// Bytes is the number of bytes transferred on the connections, by direction (received or sent)
+	Bytes = NewCounter("syncat_bytes_total", "Number of bytes received or sent.", "direction")
+	// SyncSessionDuration is the duration of sync sessions in seconds, by result (completed or interrupted)
+	SyncSessionDuration = NewHistogram("syncat_sync_session_duration_seconds", "Duration of sync sessions.",
+		[]float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600}, "result")
+	// Conflicts is the number of conflicts found in sync sessions
+	Conflicts = NewCounter("syncat_conflicts_total", "Number of conflicts found in sync sessions.")
+	// DatabaseDuration is the latency of database operations in seconds, by operation (exec, query or transaction)
+	DatabaseDuration = NewHistogram("syncat_database_duration_seconds", "Latency of database operations.",
+		[]float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}, "operation")
+)
+
+// Directions of packets and bytes
+const (
+	Received = "received"
+	Sent     = "sent"
+)
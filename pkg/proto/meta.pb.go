@@ -0,0 +1,315 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.28.1
+// 	protoc        v3.21.12
+// source: pkg/proto/meta.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SyncatAction_Kind int32
+
+const (
+	SyncatAction_ACCEPT   SyncatAction_Kind = 0
+	SyncatAction_UPLOAD   SyncatAction_Kind = 1
+	SyncatAction_DOWNLOAD SyncatAction_Kind = 2
+	SyncatAction_DELETE   SyncatAction_Kind = 3
+	SyncatAction_MKDIR    SyncatAction_Kind = 4
+	SyncatAction_CONFLICT SyncatAction_Kind = 5
+)
+
+// Enum value maps for SyncatAction_Kind.
+var (
+	SyncatAction_Kind_name = map[int32]string{
+		0: "ACCEPT",
+		1: "UPLOAD",
+		2: "DOWNLOAD",
+		3: "DELETE",
+		4: "MKDIR",
+		5: "CONFLICT",
+	}
+	SyncatAction_Kind_value = map[string]int32{
+		"ACCEPT":   0,
+		"UPLOAD":   1,
+		"DOWNLOAD": 2,
+		"DELETE":   3,
+		"MKDIR":    4,
+		"CONFLICT": 5,
+	}
+)
+
+func (x SyncatAction_Kind) Enum() *SyncatAction_Kind {
+	p := new(SyncatAction_Kind)
+	*p = x
+	return p
+}
+
+func (x SyncatAction_Kind) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (SyncatAction_Kind) Descriptor() protoreflect.EnumDescriptor {
+	return file_pkg_proto_meta_proto_enumTypes[0].Descriptor()
+}
+
+func (SyncatAction_Kind) Type() protoreflect.EnumType {
+	return &file_pkg_proto_meta_proto_enumTypes[0]
+}
+
+func (x SyncatAction_Kind) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use SyncatAction_Kind.Descriptor instead.
+func (SyncatAction_Kind) EnumDescriptor() ([]byte, []int) {
+	return file_pkg_proto_meta_proto_rawDescGZIP(), []int{0, 0}
+}
+
+type SyncatAction struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Kind  SyncatAction_Kind `protobuf:"varint,1,opt,name=kind,proto3,enum=top.gyrojeff.syncat.proto.SyncatAction_Kind" json:"kind,omitempty"`
+	Entry *SyncatEntry      `protobuf:"bytes,2,opt,name=entry,proto3" json:"entry,omitempty"`
+}
+
+func (x *SyncatAction) Reset() {
+	*x = SyncatAction{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pkg_proto_meta_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SyncatAction) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncatAction) ProtoMessage() {}
+
+func (x *SyncatAction) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_proto_meta_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SyncatAction.ProtoReflect.Descriptor instead.
+func (*SyncatAction) Descriptor() ([]byte, []int) {
+	return file_pkg_proto_meta_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SyncatAction) GetKind() SyncatAction_Kind {
+	if x != nil {
+		return x.Kind
+	}
+	return SyncatAction_ACCEPT
+}
+
+func (x *SyncatAction) GetEntry() *SyncatEntry {
+	if x != nil {
+		return x.Entry
+	}
+	return nil
+}
+
+type SyncatMetaRequestBody struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Root    string          `protobuf:"bytes,1,opt,name=root,proto3" json:"root,omitempty"`
+	Actions []*SyncatAction `protobuf:"bytes,2,rep,name=actions,proto3" json:"actions,omitempty"`
+	Busy    bool            `protobuf:"varint,3,opt,name=busy,proto3" json:"busy,omitempty"`
+}
+
+func (x *SyncatMetaRequestBody) Reset() {
+	*x = SyncatMetaRequestBody{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pkg_proto_meta_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SyncatMetaRequestBody) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncatMetaRequestBody) ProtoMessage() {}
+
+func (x *SyncatMetaRequestBody) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_proto_meta_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SyncatMetaRequestBody.ProtoReflect.Descriptor instead.
+func (*SyncatMetaRequestBody) Descriptor() ([]byte, []int) {
+	return file_pkg_proto_meta_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SyncatMetaRequestBody) GetRoot() string {
+	if x != nil {
+		return x.Root
+	}
+	return ""
+}
+
+func (x *SyncatMetaRequestBody) GetActions() []*SyncatAction {
+	if x != nil {
+		return x.Actions
+	}
+	return nil
+}
+
+func (x *SyncatMetaRequestBody) GetBusy() bool {
+	if x != nil {
+		return x.Busy
+	}
+	return false
+}
+
+var File_pkg_proto_meta_proto protoreflect.FileDescriptor
+
+var file_pkg_proto_meta_proto_rawDesc = []byte{
+	0x0a, 0x14, 0x70, 0x6b, 0x67, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x6d, 0x65, 0x74, 0x61,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x19, 0x74, 0x6f, 0x70, 0x2e, 0x67, 0x79, 0x72, 0x6f,
+	0x6a, 0x65, 0x66, 0x66, 0x2e, 0x73, 0x79, 0x6e, 0x63, 0x61, 0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x1a, 0x15, 0x70, 0x6b, 0x67, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x65, 0x6e, 0x74,
+	0x72, 0x79, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xe1, 0x01, 0x0a, 0x0c, 0x53, 0x79, 0x6e,
+	0x63, 0x61, 0x74, 0x41, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x40, 0x0a, 0x04, 0x6b, 0x69, 0x6e,
+	0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x2c, 0x2e, 0x74, 0x6f, 0x70, 0x2e, 0x67, 0x79,
+	0x72, 0x6f, 0x6a, 0x65, 0x66, 0x66, 0x2e, 0x73, 0x79, 0x6e, 0x63, 0x61, 0x74, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x2e, 0x53, 0x79, 0x6e, 0x63, 0x61, 0x74, 0x41, 0x63, 0x74, 0x69, 0x6f, 0x6e,
+	0x2e, 0x4b, 0x69, 0x6e, 0x64, 0x52, 0x04, 0x6b, 0x69, 0x6e, 0x64, 0x12, 0x3c, 0x0a, 0x05, 0x65,
+	0x6e, 0x74, 0x72, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x26, 0x2e, 0x74, 0x6f, 0x70,
+	0x2e, 0x67, 0x79, 0x72, 0x6f, 0x6a, 0x65, 0x66, 0x66, 0x2e, 0x73, 0x79, 0x6e, 0x63, 0x61, 0x74,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x53, 0x79, 0x6e, 0x63, 0x61, 0x74, 0x45, 0x6e, 0x74,
+	0x72, 0x79, 0x52, 0x05, 0x65, 0x6e, 0x74, 0x72, 0x79, 0x22, 0x51, 0x0a, 0x04, 0x4b, 0x69, 0x6e,
+	0x64, 0x12, 0x0a, 0x0a, 0x06, 0x41, 0x43, 0x43, 0x45, 0x50, 0x54, 0x10, 0x00, 0x12, 0x0a, 0x0a,
+	0x06, 0x55, 0x50, 0x4c, 0x4f, 0x41, 0x44, 0x10, 0x01, 0x12, 0x0c, 0x0a, 0x08, 0x44, 0x4f, 0x57,
+	0x4e, 0x4c, 0x4f, 0x41, 0x44, 0x10, 0x02, 0x12, 0x0a, 0x0a, 0x06, 0x44, 0x45, 0x4c, 0x45, 0x54,
+	0x45, 0x10, 0x03, 0x12, 0x09, 0x0a, 0x05, 0x4d, 0x4b, 0x44, 0x49, 0x52, 0x10, 0x04, 0x12, 0x0c,
+	0x0a, 0x08, 0x43, 0x4f, 0x4e, 0x46, 0x4c, 0x49, 0x43, 0x54, 0x10, 0x05, 0x22, 0x82, 0x01, 0x0a,
+	0x15, 0x53, 0x79, 0x6e, 0x63, 0x61, 0x74, 0x4d, 0x65, 0x74, 0x61, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x42, 0x6f, 0x64, 0x79, 0x12, 0x12, 0x0a, 0x04, 0x72, 0x6f, 0x6f, 0x74, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x72, 0x6f, 0x6f, 0x74, 0x12, 0x41, 0x0a, 0x07, 0x61, 0x63,
+	0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x27, 0x2e, 0x74, 0x6f,
+	0x70, 0x2e, 0x67, 0x79, 0x72, 0x6f, 0x6a, 0x65, 0x66, 0x66, 0x2e, 0x73, 0x79, 0x6e, 0x63, 0x61,
+	0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x53, 0x79, 0x6e, 0x63, 0x61, 0x74, 0x41, 0x63,
+	0x74, 0x69, 0x6f, 0x6e, 0x52, 0x07, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x12, 0x0a,
+	0x04, 0x62, 0x75, 0x73, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x04, 0x62, 0x75, 0x73,
+	0x79, 0x42, 0x10, 0x5a, 0x0e, 0x2e, 0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x3b, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_pkg_proto_meta_proto_rawDescOnce sync.Once
+	file_pkg_proto_meta_proto_rawDescData = file_pkg_proto_meta_proto_rawDesc
+)
+
+func file_pkg_proto_meta_proto_rawDescGZIP() []byte {
+	file_pkg_proto_meta_proto_rawDescOnce.Do(func() {
+		file_pkg_proto_meta_proto_rawDescData = protoimpl.X.CompressGZIP(file_pkg_proto_meta_proto_rawDescData)
+	})
+	return file_pkg_proto_meta_proto_rawDescData
+}
+
+var file_pkg_proto_meta_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_pkg_proto_meta_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_pkg_proto_meta_proto_goTypes = []interface{}{
+	(SyncatAction_Kind)(0),        // 0: top.gyrojeff.syncat.proto.SyncatAction.Kind
+	(*SyncatAction)(nil),          // 1: top.gyrojeff.syncat.proto.SyncatAction
+	(*SyncatMetaRequestBody)(nil), // 2: top.gyrojeff.syncat.proto.SyncatMetaRequestBody
+	(*SyncatEntry)(nil),           // 3: top.gyrojeff.syncat.proto.SyncatEntry
+}
+var file_pkg_proto_meta_proto_depIdxs = []int32{
+	0, // 0: top.gyrojeff.syncat.proto.SyncatAction.kind:type_name -> top.gyrojeff.syncat.proto.SyncatAction.Kind
+	3, // 1: top.gyrojeff.syncat.proto.SyncatAction.entry:type_name -> top.gyrojeff.syncat.proto.SyncatEntry
+	1, // 2: top.gyrojeff.syncat.proto.SyncatMetaRequestBody.actions:type_name -> top.gyrojeff.syncat.proto.SyncatAction
+	3, // [3:3] is the sub-list for method output_type
+	3, // [3:3] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_pkg_proto_meta_proto_init() }
+func file_pkg_proto_meta_proto_init() {
+	if File_pkg_proto_meta_proto != nil {
+		return
+	}
+	file_pkg_proto_entry_proto_init()
+	if !protoimpl.UnsafeEnabled {
+		file_pkg_proto_meta_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SyncatAction); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pkg_proto_meta_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SyncatMetaRequestBody); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_pkg_proto_meta_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_pkg_proto_meta_proto_goTypes,
+		DependencyIndexes: file_pkg_proto_meta_proto_depIdxs,
+		EnumInfos:         file_pkg_proto_meta_proto_enumTypes,
+		MessageInfos:      file_pkg_proto_meta_proto_msgTypes,
+	}.Build()
+	File_pkg_proto_meta_proto = out.File
+	file_pkg_proto_meta_proto_rawDesc = nil
+	file_pkg_proto_meta_proto_goTypes = nil
+	file_pkg_proto_meta_proto_depIdxs = nil
+}
@@ -0,0 +1,154 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.28.1
+// 	protoc        v3.21.12
+// source: pkg/proto/resume.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SyncatResumeRequestBody struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	TransferId string `protobuf:"bytes,1,opt,name=transferId,proto3" json:"transferId,omitempty"`
+	Offset     uint64 `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+}
+
+func (x *SyncatResumeRequestBody) Reset() {
+	*x = SyncatResumeRequestBody{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pkg_proto_resume_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SyncatResumeRequestBody) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncatResumeRequestBody) ProtoMessage() {}
+
+func (x *SyncatResumeRequestBody) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_proto_resume_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SyncatResumeRequestBody.ProtoReflect.Descriptor instead.
+func (*SyncatResumeRequestBody) Descriptor() ([]byte, []int) {
+	return file_pkg_proto_resume_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SyncatResumeRequestBody) GetTransferId() string {
+	if x != nil {
+		return x.TransferId
+	}
+	return ""
+}
+
+func (x *SyncatResumeRequestBody) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+var File_pkg_proto_resume_proto protoreflect.FileDescriptor
+
+var file_pkg_proto_resume_proto_rawDesc = []byte{
+	0x0a, 0x16, 0x70, 0x6b, 0x67, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x72, 0x65, 0x73, 0x75,
+	0x6d, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x19, 0x74, 0x6f, 0x70, 0x2e, 0x67, 0x79,
+	0x72, 0x6f, 0x6a, 0x65, 0x66, 0x66, 0x2e, 0x73, 0x79, 0x6e, 0x63, 0x61, 0x74, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x22, 0x51, 0x0a, 0x17, 0x53, 0x79, 0x6e, 0x63, 0x61, 0x74, 0x52, 0x65, 0x73,
+	0x75, 0x6d, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x42, 0x6f, 0x64, 0x79, 0x12, 0x1e,
+	0x0a, 0x0a, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x65, 0x72, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x0a, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x65, 0x72, 0x49, 0x64, 0x12, 0x16,
+	0x0a, 0x06, 0x6f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x06,
+	0x6f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x42, 0x10, 0x5a, 0x0e, 0x2e, 0x2f, 0x70, 0x6b, 0x67, 0x2f,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x3b, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_pkg_proto_resume_proto_rawDescOnce sync.Once
+	file_pkg_proto_resume_proto_rawDescData = file_pkg_proto_resume_proto_rawDesc
+)
+
+func file_pkg_proto_resume_proto_rawDescGZIP() []byte {
+	file_pkg_proto_resume_proto_rawDescOnce.Do(func() {
+		file_pkg_proto_resume_proto_rawDescData = protoimpl.X.CompressGZIP(file_pkg_proto_resume_proto_rawDescData)
+	})
+	return file_pkg_proto_resume_proto_rawDescData
+}
+
+var file_pkg_proto_resume_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_pkg_proto_resume_proto_goTypes = []interface{}{
+	(*SyncatResumeRequestBody)(nil), // 0: top.gyrojeff.syncat.proto.SyncatResumeRequestBody
+}
+var file_pkg_proto_resume_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_pkg_proto_resume_proto_init() }
+func file_pkg_proto_resume_proto_init() {
+	if File_pkg_proto_resume_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_pkg_proto_resume_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SyncatResumeRequestBody); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_pkg_proto_resume_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_pkg_proto_resume_proto_goTypes,
+		DependencyIndexes: file_pkg_proto_resume_proto_depIdxs,
+		MessageInfos:      file_pkg_proto_resume_proto_msgTypes,
+	}.Build()
+	File_pkg_proto_resume_proto = out.File
+	file_pkg_proto_resume_proto_rawDesc = nil
+	file_pkg_proto_resume_proto_goTypes = nil
+	file_pkg_proto_resume_proto_depIdxs = nil
+}
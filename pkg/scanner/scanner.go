@@ -0,0 +1,146 @@
+package scanner
+
+import (
+	"github.com/JeffersonQin/syncat/pkg/database"
+	"github.com/JeffersonQin/syncat/pkg/hashing"
+	"github.com/google/uuid"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TempPrefix is the name prefix of the temporary files created by syncat inside sync roots
+const TempPrefix = ".syncat-"
+
+// Ignored Check whether the file is managed by syncat itself, and must not be synced
+func Ignored(name string) bool {
+	return strings.HasPrefix(name, TempPrefix)
+}
+
+// Scan Walk the directory of the sync root, and record its current state in entries
+// Files are only hashed when their size or modification time changes, or when their recorded hash was computed
+// by another algorithm. Every change gets a new version, and missing entries are kept as deleted.
+// The directory is created if it is missing and none of its files is recorded.
+// The returned value indicates whether any change is found
+func Scan(store *database.Store, root string, dir string, algorithm hashing.Algorithm) (bool, error) {
+	existing, err := store.QueryEntriesByPrefix(root, "")
+	if err != nil {
+		return false, err
+	}
+	recorded := make(map[string]database.Entry, len(existing))
+	anyLive := false
+	for _, e := range existing {
+		recorded[e.Path] = e
+		anyLive = anyLive || !e.Deleted
+	}
+	_, err = os.Stat(dir)
+	if err != nil {
+		// a missing directory with recorded files is more likely an unmounted disk than a deletion,
+		// never record all its files as deleted
+		if !os.IsNotExist(err) || anyLive {
+			return false, err
+		}
+		err = os.MkdirAll(dir, os.ModePerm)
+		if err != nil {
+			return false, err
+		}
+	}
+	seen := make(map[string]bool, len(existing))
+	var updates []database.Entry
+	changed := false
+	err = filepath.WalkDir(dir, func(localPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if localPath == dir {
+			return nil
+		}
+		if Ignored(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// links and special files are not synced
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, localPath)
+		if err != nil {
+			return err
+		}
+		path := filepath.ToSlash(rel)
+		seen[path] = true
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		e, exists := recorded[path]
+		alive := exists && !e.Deleted
+		if d.IsDir() {
+			if alive && e.IsDir {
+				return nil
+			}
+			changed = true
+			updates = append(updates, database.Entry{
+				Root:      root,
+				Path:      path,
+				HashAlgo:  string(algorithm),
+				Timestamp: info.ModTime(),
+				IsDir:     true,
+				Uuid:      uuid.NewString(),
+			})
+			return nil
+		}
+		size := uint64(info.Size())
+		unchanged := alive && !e.IsDir && e.Size == size && e.Timestamp.Equal(info.ModTime())
+		if unchanged && e.HashAlgo == string(algorithm) {
+			return nil
+		}
+		hash, err := algorithm.File(localPath)
+		if err != nil {
+			return err
+		}
+		if unchanged {
+			// the content is recorded with another algorithm, rehash lazily without a new version
+			return store.UpdateEntryHash(root, path, e.Hash, e.HashAlgo, hash, string(algorithm))
+		}
+		entry := database.Entry{
+			Root:      root,
+			Path:      path,
+			Hash:      hash,
+			HashAlgo:  string(algorithm),
+			Timestamp: info.ModTime(),
+			Size:      size,
+			Uuid:      uuid.NewString(),
+		}
+		if alive && !e.IsDir && e.HashAlgo == entry.HashAlgo && e.Hash == entry.Hash {
+			// only touched, keep the version
+			entry.Uuid = e.Uuid
+		} else {
+			changed = true
+		}
+		updates = append(updates, entry)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	for _, e := range existing {
+		if e.Deleted || seen[e.Path] {
+			continue
+		}
+		changed = true
+		e.Deleted = true
+		e.Timestamp = now
+		e.Uuid = uuid.NewString()
+		updates = append(updates, e)
+	}
+	if len(updates) == 0 {
+		return changed, nil
+	}
+	return changed, store.InsertEntries(updates)
+}
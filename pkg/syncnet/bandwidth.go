@@ -0,0 +1,162 @@
+package syncnet
+
+import (
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"sync"
+	"time"
+)
+
+// schedule is a parsed SyncatBandwidthSchedule, with the times as offsets from midnight
+type schedule struct {
+	from     time.Duration
+	to       time.Duration
+	upload   int
+	download int
+}
+
+// contains Check whether the time of day is in the schedule
+// A schedule from and to the same time covers the whole day
+func (s schedule) contains(offset time.Duration) bool {
+	if s.from == s.to {
+		return true
+	}
+	if s.from < s.to {
+		return s.from <= offset && offset < s.to
+	}
+	return offset >= s.from || offset < s.to
+}
+
+// limit is a parsed SyncatBandwidthLimit with its limiters, whose rates follow the schedules
+type limit struct {
+	upload    int
+	download  int
+	schedules []schedule
+	uploads   *RateLimiter
+	downloads *RateLimiter
+}
+
+// newLimit Parse the configured limit
+func newLimit(limitConfig config.SyncatBandwidthLimit) (*limit, error) {
+	l := &limit{
+		upload:    limitConfig.Upload,
+		download:  limitConfig.Download,
+		uploads:   &RateLimiter{},
+		downloads: &RateLimiter{},
+	}
+	for _, s := range limitConfig.Schedules {
+		from, err := parseTimeOfDay(s.From)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseTimeOfDay(s.To)
+		if err != nil {
+			return nil, err
+		}
+		l.schedules = append(l.schedules, schedule{from, to, s.Upload, s.Download})
+	}
+	return l, nil
+}
+
+// parseTimeOfDay Parse the time in HH:MM as the offset from midnight
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, ErrInvalidTimeOfDay{value}
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// limiter Get the limiter of the direction, with its rate updated to the time
+func (l *limit) limiter(now time.Time, upload bool) *RateLimiter {
+	offset := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute +
+		time.Duration(now.Second())*time.Second
+	up, down := l.upload, l.download
+	for _, s := range l.schedules {
+		if s.contains(offset) {
+			up, down = s.upload, s.download
+			break
+		}
+	}
+	if upload {
+		l.uploads.SetRate(up)
+		return l.uploads
+	}
+	l.downloads.SetRate(down)
+	return l.downloads
+}
+
+// BandwidthLimits are the parsed bandwidth limits, applied to the connections by Bandwidth.Set
+type BandwidthLimits struct {
+	global *limit
+	roots  map[string]*limit
+}
+
+// ParseBandwidth Parse the bandwidth limits without applying them, so that they are applied along with the other
+// settings only once all of them are valid
+// ErrInvalidTimeOfDay is returned if a schedule is malformed
+func ParseBandwidth(bandwidthConfig config.SyncatBandwidthConfig) (BandwidthLimits, error) {
+	global, err := newLimit(bandwidthConfig.SyncatBandwidthLimit)
+	if err != nil {
+		return BandwidthLimits{}, err
+	}
+	roots := make(map[string]*limit, len(bandwidthConfig.Roots))
+	for root, rootConfig := range bandwidthConfig.Roots {
+		roots[root], err = newLimit(rootConfig)
+		if err != nil {
+			return BandwidthLimits{}, err
+		}
+	}
+	return BandwidthLimits{global, roots}, nil
+}
+
+// Bandwidth are the bandwidth limits shared by the connections of one side, e.g. all the clients of a server
+// The zero Bandwidth, or a nil one, does not limit anything
+type Bandwidth struct {
+	mu     sync.Mutex
+	limits BandwidthLimits
+}
+
+// NewBandwidth Create the bandwidth limits of a side with the configuration
+// ErrInvalidTimeOfDay is returned if a schedule is malformed
+func NewBandwidth(bandwidthConfig config.SyncatBandwidthConfig) (*Bandwidth, error) {
+	limits, err := ParseBandwidth(bandwidthConfig)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bandwidth{}
+	b.Set(limits)
+	return b, nil
+}
+
+// Set Replace the bandwidth limits, which applies to the transfers going on as well
+func (b *Bandwidth) Set(limits BandwidthLimits) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limits = limits
+}
+
+// wait Block until up to n bytes of the sync root are allowed to transfer in the direction,
+// and get the number of bytes allowed, which is at most one second of transfer of the strictest limit
+// The global limit applies to all the transfers, and the limit of the sync root only to its transfers
+func (b *Bandwidth) wait(root string, upload bool, n int) int {
+	if b == nil {
+		return n
+	}
+	now := time.Now()
+	var limiters []*RateLimiter
+	b.mu.Lock()
+	if b.limits.global != nil {
+		limiters = append(limiters, b.limits.global.limiter(now, upload))
+	}
+	if l, ok := b.limits.roots[root]; ok && root != "" {
+		limiters = append(limiters, l.limiter(now, upload))
+	}
+	b.mu.Unlock()
+	for _, l := range limiters {
+		n = l.slice(n)
+	}
+	for _, l := range limiters {
+		l.Wait(n)
+	}
+	return n
+}
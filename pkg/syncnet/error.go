@@ -12,6 +12,16 @@ func (e ErrInvalidPacket) Error() string {
 	return fmt.Sprintf("invalid packet length: %d", e.length)
 }
 
+// ErrPacketTooLarge is returned when the packet exceeds MaxPacketLength
+type ErrPacketTooLarge struct {
+	length uint64
+}
+
+// Error returns the error message
+func (e ErrPacketTooLarge) Error() string {
+	return fmt.Sprintf("packet too large: %d bytes, at most %d", e.length, MaxPacketLength)
+}
+
 // ErrInvalidPacketType is returned when the packet type is invalid
 type ErrInvalidPacketType struct {
 	packetType byte
@@ -41,3 +51,135 @@ type ErrAuthFailed struct {
 func (e ErrAuthFailed) Error() string {
 	return fmt.Sprintf("auth failed: %s", e.message)
 }
+
+// ErrUnknownRoot is returned when the sync root is not configured
+type ErrUnknownRoot struct {
+	root string
+}
+
+// Error returns the error message
+func (e ErrUnknownRoot) Error() string {
+	return fmt.Sprintf("unknown sync root: %s", e.root)
+}
+
+// ErrInvalidPath is returned when the path escapes the sync root
+type ErrInvalidPath struct {
+	path string
+}
+
+// Error returns the error message
+func (e ErrInvalidPath) Error() string {
+	return fmt.Sprintf("invalid path: %s", e.path)
+}
+
+// ErrInvalidTransferId is returned when the id of a transfer is not derived from the file it transfers
+type ErrInvalidTransferId struct {
+	transferId string
+}
+
+// Error returns the error message
+func (e ErrInvalidTransferId) Error() string {
+	return fmt.Sprintf("invalid transfer id: %s", e.transferId)
+}
+
+// ErrTransferCorrupted is returned when the transferred file cannot be verified
+type ErrTransferCorrupted struct {
+	transferId string
+}
+
+// Error returns the error message
+func (e ErrTransferCorrupted) Error() string {
+	return fmt.Sprintf("transfer corrupted: %s", e.transferId)
+}
+
+// ErrRootBusy is returned when the sync root is in the sync session of another client
+type ErrRootBusy struct {
+	root string
+}
+
+// Error returns the error message
+func (e ErrRootBusy) Error() string {
+	return fmt.Sprintf("sync root is busy: %s", e.root)
+}
+
+// ErrInvalidTimeOfDay is returned when the time of a bandwidth schedule is not in HH:MM
+type ErrInvalidTimeOfDay struct {
+	value string
+}
+
+// Error returns the error message
+func (e ErrInvalidTimeOfDay) Error() string {
+	return fmt.Sprintf("invalid time of day: %s, expected HH:MM", e.value)
+}
+
+// ErrStreamClosed is returned when the stream is closed
+type ErrStreamClosed struct {
+	stream uint32
+}
+
+// Error returns the error message
+func (e ErrStreamClosed) Error() string {
+	return fmt.Sprintf("stream closed: %d", e.stream)
+}
+
+// ErrFlowControl is returned when the peer sends beyond the window of the stream
+type ErrFlowControl struct {
+	stream uint32
+}
+
+// Error returns the error message
+func (e ErrFlowControl) Error() string {
+	return fmt.Sprintf("flow control violated on stream: %d", e.stream)
+}
+
+// ErrTooManyStreams is returned when the streams open on the connection exceed the limit
+type ErrTooManyStreams struct {
+	limit int
+}
+
+// Error returns the error message
+func (e ErrTooManyStreams) Error() string {
+	return fmt.Sprintf("too many streams, at most %d", e.limit)
+}
+
+// ErrTruncatedPacket is returned when the connection ends in the middle of a packet
+type ErrTruncatedPacket struct {
+	expected int
+	received int
+}
+
+// Error returns the error message
+func (e ErrTruncatedPacket) Error() string {
+	return fmt.Sprintf("truncated packet: received %d of %d bytes", e.received, e.expected)
+}
+
+// ErrMalformedPacket is returned when the body of the packet cannot be parsed
+type ErrMalformedPacket struct {
+	packetType PacketType
+	err        error
+}
+
+// Error returns the error message
+func (e ErrMalformedPacket) Error() string {
+	return fmt.Sprintf("malformed %s packet: %v", e.packetType, e.err)
+}
+
+// ErrNoSyncSession is returned when a file of the sync root is transferred outside a sync session of the sync root
+type ErrNoSyncSession struct {
+	root string
+}
+
+// Error returns the error message
+func (e ErrNoSyncSession) Error() string {
+	return fmt.Sprintf("no sync session of sync root: %s", e.root)
+}
+
+// ErrClientRevoked is returned when a client revoked after authentication starts a sync session
+type ErrClientRevoked struct {
+	uuid string
+}
+
+// Error returns the error message
+func (e ErrClientRevoked) Error() string {
+	return fmt.Sprintf("client is revoked: %s", e.uuid)
+}
@@ -0,0 +1,298 @@
+package syncnet
+
+import (
+	"bytes"
+	"errors"
+	"github.com/JeffersonQin/syncat/internal/faultnet"
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"github.com/JeffersonQin/syncat/pkg/database"
+	"github.com/JeffersonQin/syncat/pkg/hashing"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// headerLength is the length of the header of every packet
+const headerLength = TypeLength + StreamLength + SizeLength
+
+// pipe Get the two ends of an in-memory connection, with the faults injected into the bytes written by the first end
+// and read by the second end
+func pipe(t *testing.T, write faultnet.Faults, read faultnet.Faults) (*IdleTimeoutConn, *IdleTimeoutConn) {
+	a, b := net.Pipe()
+	sender := &IdleTimeoutConn{Conn: faultnet.New(a, faultnet.NoFaults(), write), IdleTimeout: 5 * time.Second}
+	receiver := &IdleTimeoutConn{Conn: faultnet.New(b, read, faultnet.NoFaults()), IdleTimeout: 5 * time.Second}
+	t.Cleanup(func() {
+		_ = a.Close()
+		_ = b.Close()
+	})
+	return sender, receiver
+}
+
+// sendAll Send the requests on the control stream of the connection in the background, and close it afterwards
+// The requests failing to send are ignored, since the faults may cut the connection
+func sendAll(conn *IdleTimeoutConn, requests ...SyncatRequest) {
+	go func() {
+		stream := newStream(conn, ControlStream)
+		for _, req := range requests {
+			if req.Send(stream) != nil {
+				break
+			}
+		}
+		_ = conn.Close()
+	}()
+}
+
+// testRequests Get a request of each shape, without body, with a small body, and with a file chunk
+func testRequests(t *testing.T) []SyncatRequest {
+	data := bytes.Repeat([]byte("chunk of file "), 20)
+	file, err := NewSyncatFileRequest("transfer", "sync", "a.txt", uint64(len(data)), "hash", hashing.SHA256,
+		time.Unix(1, 0), 0, data)
+	if err != nil {
+		t.Fatalf("failed to create FILE request: %v", err)
+	}
+	return []SyncatRequest{NewSyncatPingRequest(), NewSyncatSyncRequest("sync", nil), file}
+}
+
+// packetLength Get the length of the request on the wire
+func packetLength(t *testing.T, req SyncatRequest) int {
+	var body proto.Message
+	switch r := req.(type) {
+	case *SyncatSyncRequest:
+		body = &r.SyncatSyncRequestBody
+	case *SyncatFileRequest:
+		body = &r.SyncatFileRequestBody
+	default:
+		return headerLength
+	}
+	data, err := proto.Marshal(body)
+	if err != nil {
+		t.Fatalf("failed to marshal %s: %v", req.GetType(), err)
+	}
+	return headerLength + len(data)
+}
+
+// assertSameRequest Assert that the request received is parsed into the request sent
+func assertSameRequest(t *testing.T, got SyncatRequest, want SyncatRequest) {
+	t.Helper()
+	if got.GetType() != want.GetType() || got.GetStream() != ControlStream {
+		t.Fatalf("received %s on stream %d, want %s on control stream", got.GetType(), got.GetStream(), want.GetType())
+	}
+	var err error
+	equal := true
+	switch r := got.(type) {
+	case *SyncatSyncRequest:
+		err = r.unmarshal(&r.SyncatSyncRequestBody)
+		equal = proto.Equal(&r.SyncatSyncRequestBody, &want.(*SyncatSyncRequest).SyncatSyncRequestBody)
+	case *SyncatFileRequest:
+		err = r.unmarshal(&r.SyncatFileRequestBody)
+		equal = proto.Equal(&r.SyncatFileRequestBody, &want.(*SyncatFileRequest).SyncatFileRequestBody)
+	}
+	if err != nil || !equal {
+		t.Fatalf("body of %s is not received intact: %v", got.GetType(), err)
+	}
+}
+
+func TestRouteConnReassemblesFragments(t *testing.T) {
+	faults := faultnet.NoFaults()
+	faults.Fragment, faults.Delay = true, time.Microsecond
+	sender, receiver := pipe(t, faults, faults)
+	requests := testRequests(t)
+	sendAll(sender, requests...)
+	for _, want := range requests {
+		got, err := RouteConn(receiver)
+		if err != nil {
+			t.Fatalf("failed to route %s: %v", want.GetType(), err)
+		}
+		assertSameRequest(t, got, want)
+	}
+	if _, err := RouteConn(receiver); err != io.EOF {
+		t.Fatalf("err = %v after the last packet, want io.EOF", err)
+	}
+}
+
+func TestRouteConnCutReturnsTruncatedPacket(t *testing.T) {
+	file := testRequests(t)[2]
+	length := packetLength(t, file)
+	for _, cut := range []int{1, TypeLength + StreamLength, headerLength - 1, headerLength, headerLength + 1, length - 1} {
+		faults := faultnet.NoFaults()
+		faults.CutAt = int64(cut)
+		sender, receiver := pipe(t, faultnet.NoFaults(), faults)
+		sendAll(sender, file)
+		_, err := RouteConn(receiver)
+		var truncated ErrTruncatedPacket
+		if !errors.As(err, &truncated) {
+			t.Fatalf("cut at %d: err = %v, want ErrTruncatedPacket", cut, err)
+		}
+	}
+}
+
+func TestRouteConnCutBetweenPackets(t *testing.T) {
+	requests := testRequests(t)
+	faults := faultnet.NoFaults()
+	faults.CutAt = int64(packetLength(t, requests[0]) + packetLength(t, requests[1]))
+	sender, receiver := pipe(t, faultnet.NoFaults(), faults)
+	sendAll(sender, requests...)
+	for _, want := range requests[:2] {
+		got, err := RouteConn(receiver)
+		if err != nil {
+			t.Fatalf("failed to route %s: %v", want.GetType(), err)
+		}
+		assertSameRequest(t, got, want)
+	}
+	if _, err := RouteConn(receiver); err != io.EOF {
+		t.Fatalf("err = %v, want io.EOF", err)
+	}
+}
+
+func TestRouteConnRejectsCorruptedType(t *testing.T) {
+	faults := faultnet.NoFaults()
+	faults.CorruptAt = 0
+	sender, receiver := pipe(t, faults, faultnet.NoFaults())
+	sendAll(sender, testRequests(t)...)
+	_, err := RouteConn(receiver)
+	var invalid ErrInvalidPacketType
+	if !errors.As(err, &invalid) {
+		t.Fatalf("err = %v, want ErrInvalidPacketType", err)
+	}
+}
+
+func TestRouteConnDroppedByteMisframes(t *testing.T) {
+	// losing the type byte shifts the header by one byte, so the length is read 256 times larger than the packet,
+	// and the connection ends before the body does
+	faults := faultnet.NoFaults()
+	faults.DropAt, faults.DropLength = 0, 1
+	sender, receiver := pipe(t, faults, faultnet.NoFaults())
+	sendAll(sender, testRequests(t)[2])
+	_, err := RouteConn(receiver)
+	var truncated ErrTruncatedPacket
+	if !errors.As(err, &truncated) {
+		t.Fatalf("err = %v, want ErrTruncatedPacket", err)
+	}
+}
+
+// authPair Get the shared configuration, and the databases of a server and a client in a temporary directory
+func authPair(t *testing.T) (config.SyncatConfig, *database.Store, *database.Store) {
+	dir := t.TempDir()
+	sharedConfig := config.SyncatConfig{Auth: config.SyncatAuthConfig{Token: "fault-injection-token"}}
+	serverStore, err := database.LoadDatabase(config.SyncatDBConfig{Filename: filepath.Join(dir, "server.db")},
+		database.ServerRole)
+	if err != nil {
+		t.Fatalf("failed to open server database: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = serverStore.Close()
+	})
+	clientStore, err := database.LoadDatabase(config.SyncatDBConfig{Filename: filepath.Join(dir, "client.db")},
+		database.ClientRole)
+	if err != nil {
+		t.Fatalf("failed to open client database: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = clientStore.Close()
+	})
+	return sharedConfig, serverStore, clientStore
+}
+
+// authenticateThrough Send AUTH from the client through the faults, and return the errors of the server handling it
+// and of the client handling the REPLY
+func authenticateThrough(t *testing.T, write faultnet.Faults, read faultnet.Faults) (error, error) {
+	sharedConfig, serverStore, clientStore := authPair(t)
+	holder := config.NewHolder(sharedConfig)
+	a, b := net.Pipe()
+	client := &IdleTimeoutConn{Conn: faultnet.New(a, read, write), IdleTimeout: 5 * time.Second, Config: holder}
+	server := &IdleTimeoutConn{Conn: b, IdleTimeout: 5 * time.Second, Config: holder}
+	client.StartStreams(true)
+	server.StartStreams(false)
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	clientErr := make(chan error, 1)
+	go func() {
+		auth, err := NewSyncatAuthRequest(clientStore, sharedConfig, "fault")
+		if err == nil {
+			err = auth.Send(client.Control())
+		}
+		var req SyncatRequest
+		if err == nil {
+			req, err = Wait(client.Control(), []PacketType{REPLY})
+		}
+		if err == nil {
+			err = req.Handle(client.Control(), clientStore)
+		}
+		clientErr <- err
+	}()
+	req, err := Wait(server.Control(), []PacketType{AUTH})
+	if err == nil {
+		err = req.Handle(server.Control(), serverStore)
+	}
+	if err != nil {
+		// the client is left waiting for REPLY otherwise
+		_ = server.Close()
+	}
+	return err, <-clientErr
+}
+
+// tokenOffset Get the offset of the token in the AUTH packet of a new client
+func tokenOffset(t *testing.T) int {
+	sharedConfig, _, clientStore := authPair(t)
+	auth, err := NewSyncatAuthRequest(clientStore, sharedConfig, "fault")
+	if err != nil {
+		t.Fatalf("failed to create AUTH request: %v", err)
+	}
+	body, err := proto.Marshal(&auth.SyncatAuthRequestBody)
+	if err != nil {
+		t.Fatalf("failed to marshal AUTH request: %v", err)
+	}
+	return headerLength + bytes.Index(body, []byte(sharedConfig.Auth.Token))
+}
+
+func TestAuthOverFragmentedConnection(t *testing.T) {
+	faults := faultnet.NoFaults()
+	faults.Fragment = true
+	serverErr, clientErr := authenticateThrough(t, faults, faults)
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("server err = %v, client err = %v, want both nil", serverErr, clientErr)
+	}
+}
+
+func TestAuthRejectsCorruptedToken(t *testing.T) {
+	// the token is kept valid UTF-8, so that it is parsed and compared
+	faults := faultnet.NoFaults()
+	faults.CorruptAt, faults.CorruptMask = int64(tokenOffset(t)), 0x01
+	serverErr, clientErr := authenticateThrough(t, faults, faultnet.NoFaults())
+	var failed ErrAuthFailed
+	if !errors.As(serverErr, &failed) || !errors.As(clientErr, &failed) {
+		t.Fatalf("server err = %v, client err = %v, want both ErrAuthFailed", serverErr, clientErr)
+	}
+}
+
+func TestAuthRejectsMalformedBody(t *testing.T) {
+	// the length of the token field is made larger than the body
+	faults := faultnet.NoFaults()
+	faults.CorruptAt = int64(tokenOffset(t) - 1)
+	serverErr, clientErr := authenticateThrough(t, faults, faultnet.NoFaults())
+	var malformed ErrMalformedPacket
+	if !errors.As(serverErr, &malformed) {
+		t.Fatalf("server err = %v, want ErrMalformedPacket", serverErr)
+	}
+	if clientErr == nil {
+		t.Fatal("client is authenticated by malformed AUTH")
+	}
+}
+
+func TestAuthCutReturnsTruncatedPacket(t *testing.T) {
+	faults := faultnet.NoFaults()
+	faults.CutAt = int64(tokenOffset(t))
+	serverErr, clientErr := authenticateThrough(t, faults, faultnet.NoFaults())
+	var truncated ErrTruncatedPacket
+	if !errors.As(serverErr, &truncated) {
+		t.Fatalf("server err = %v, want ErrTruncatedPacket", serverErr)
+	}
+	if clientErr == nil {
+		t.Fatal("client is authenticated by truncated AUTH")
+	}
+}
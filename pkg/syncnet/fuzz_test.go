@@ -0,0 +1,321 @@
+package syncnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/JeffersonQin/syncat/pkg/compression"
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"github.com/JeffersonQin/syncat/pkg/database"
+	"github.com/JeffersonQin/syncat/pkg/hashing"
+	pb "github.com/JeffersonQin/syncat/pkg/proto"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// bufferConn is the connection reading from a reader and writing to a buffer, for parsing packets in memory
+type bufferConn struct {
+	net.Conn
+	r io.Reader
+	w bytes.Buffer
+}
+
+// Read reads data from the reader
+func (c *bufferConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// Write writes data to the buffer
+func (c *bufferConn) Write(b []byte) (int, error) {
+	return c.w.Write(b)
+}
+
+// SetReadDeadline does nothing, since reading from memory never blocks
+func (c *bufferConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline does nothing, since writing to memory never blocks
+func (c *bufferConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// encodePacket Get the bytes of the request as sent on the control stream
+func encodePacket(f *testing.F, req SyncatRequest) []byte {
+	conn := &bufferConn{r: bytes.NewReader(nil)}
+	err := req.Send(newStream(&IdleTimeoutConn{Conn: conn, IdleTimeout: time.Second}, ControlStream))
+	if err != nil {
+		f.Fatalf("failed to encode %s: %v", req.GetType(), err)
+	}
+	return conn.w.Bytes()
+}
+
+// packetOf Get the bytes of a packet of the type carrying the body on the control stream
+func packetOf(packetType PacketType, body []byte) []byte {
+	data := make([]byte, TypeLength+StreamLength+SizeLength, TypeLength+StreamLength+SizeLength+len(body))
+	data[0] = byte(packetType)
+	binary.BigEndian.PutUint64(data[TypeLength+StreamLength:], uint64(len(body)))
+	return append(data, body...)
+}
+
+// routeBytes Parse the data into the packets it carries, until the data ends or fails to parse
+func routeBytes(data []byte) ([]SyncatRequest, error) {
+	conn := &IdleTimeoutConn{Conn: &bufferConn{r: bytes.NewReader(data)}, IdleTimeout: time.Second}
+	var requests []SyncatRequest
+	for {
+		req, err := RouteConn(conn)
+		if err != nil {
+			return requests, err
+		}
+		requests = append(requests, req)
+	}
+}
+
+// seedEntry is a valid entry of the seed corpus
+var seedEntry = &pb.SyncatEntry{Path: "docs/a.txt", Hash: "hash", HashAlgorithm: string(hashing.SHA256),
+	Timestamp: 1, Size: 5, Uuid: "uuid", BaseUuid: "base", Modified: true}
+
+// seedRequests Get a valid request of every type for the seed corpus
+func seedRequests(f *testing.F) []SyncatRequest {
+	data := []byte("hello")
+	file, err := NewSyncatFileRequest(NewTransferId("sync", "docs/a.txt", "hash", uint64(len(data))), "sync",
+		"docs/a.txt", uint64(len(data)), "hash", hashing.SHA256, time.Unix(1, 0), 0, data)
+	if err != nil {
+		f.Fatalf("failed to create FILE request: %v", err)
+	}
+	auth := &SyncatAuthRequest{SyncatRequestHeader{PacketType: AUTH}, pb.SyncatAuthRequestBody{
+		Token: "token", HashAlgorithms: hashing.Names(hashing.Supported), DeviceName: "device",
+		Compressions: compression.Names([]compression.Algorithm{compression.Gzip}),
+	}}
+	reply := NewSyncatReplyRequest(true, "uuid", "OK")
+	reply.HashAlgorithm, reply.Compression = string(hashing.SHA256), string(compression.Gzip)
+	return []SyncatRequest{
+		NewSyncatAckRequest(), auth, reply, NewSyncatPingRequest(), NewSyncatPongRequest(), file,
+		NewSyncatSyncRequest("sync", []*pb.SyncatEntry{seedEntry}),
+		NewSyncatMetaRequest("sync", []*pb.SyncatAction{{Kind: pb.SyncatAction_DOWNLOAD, Entry: seedEntry}}, false),
+		NewSyncatByeRequest(), NewSyncatResumeRequest("transfer", 5), NewSyncatGetRequest("sync", "docs/a.txt"),
+		NewSyncatNotifyRequest("sync"), NewSyncatWindowRequest(1024), NewSyncatCloseRequest(),
+	}
+}
+
+func FuzzRouteConn(f *testing.F) {
+	requests := seedRequests(f)
+	var all []byte
+	for _, req := range requests {
+		data := encodePacket(f, req)
+		f.Add(data)
+		all = append(all, data...)
+	}
+	f.Add(all)
+	f.Add(packetOf(FILE, nil)[:TypeLength+StreamLength])
+	f.Fuzz(func(t *testing.T, data []byte) {
+		requests, err := routeBytes(data)
+		var truncated ErrTruncatedPacket
+		var tooLarge ErrPacketTooLarge
+		var invalid ErrInvalidPacketType
+		if err != io.EOF && !errors.As(err, &truncated) && !errors.As(err, &tooLarge) && !errors.As(err, &invalid) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		parsed := 0
+		for _, req := range requests {
+			if req.GetLength() > MaxPacketLength {
+				t.Fatalf("%s of %d bytes is parsed", req.GetType(), req.GetLength())
+			}
+			parsed += TypeLength + StreamLength + SizeLength + int(req.GetLength())
+		}
+		if parsed > len(data) {
+			t.Fatalf("%d bytes are parsed from %d bytes", parsed, len(data))
+		}
+	})
+}
+
+// fuzzSide is the side handling the packets fuzzed, with a sync root and a database in a temporary directory
+type fuzzSide struct {
+	store  *database.Store
+	config config.SyncatConfig
+	dir    string
+}
+
+// newFuzzSide Create the side of the role, with a file in its sync root for GET and SYNC to work on
+func newFuzzSide(f *testing.F, role database.Role) *fuzzSide {
+	dir := f.TempDir()
+	root := filepath.Join(dir, "sync")
+	err := os.MkdirAll(filepath.Join(root, "docs"), os.ModePerm)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(root, "docs", "a.txt"), []byte("hello"), 0666)
+	}
+	if err != nil {
+		f.Fatalf("failed to create sync root: %v", err)
+	}
+	sideConfig := config.SyncatConfig{
+		Db:       config.SyncatDBConfig{Filename: filepath.Join(dir, "syncat.db")},
+		Sync:     config.SyncatSyncConfig{Directories: []string{root}},
+		Protocol: config.SyncatProtocolConfig{BufferSize: 4},
+		Auth:     config.SyncatAuthConfig{Token: "token"},
+	}
+	store, err := database.LoadDatabase(sideConfig.Db, role)
+	if err != nil {
+		f.Fatalf("failed to open database: %v", err)
+	}
+	f.Cleanup(func() {
+		_ = store.Close()
+	})
+	return &fuzzSide{store: store, config: sideConfig, dir: dir}
+}
+
+// handle Parse the body as a packet of the type and handle it on a fresh control stream
+// The stream reads nothing more, as if the peer had closed it, so that handlers waiting for a response return
+func (s *fuzzSide) handle(t *testing.T, packetType PacketType, body []byte) (SyncatRequest, error) {
+	requests, err := routeBytes(packetOf(packetType, body))
+	if err != io.EOF || len(requests) != 1 {
+		t.Fatalf("failed to route %s of %d bytes: %v", packetType, len(body), err)
+	}
+	conn := &IdleTimeoutConn{
+		Conn:          &bufferConn{r: bytes.NewReader(nil)},
+		IdleTimeout:   time.Second,
+		Config:        config.NewHolder(s.config),
+		HashAlgorithm: hashing.SHA256,
+		Compression:   compression.Gzip,
+	}
+	stream := newStream(conn, ControlStream)
+	stream.closedByPeer = true
+	if packetType == FILE || packetType == GET {
+		// files are only transferred in the sync session of their sync root
+		stream.SyncRoot = "sync"
+	}
+	err = requests[0].Handle(stream, s.store)
+	_ = stream.EndSyncSession(s.store, false)
+	return requests[0], err
+}
+
+// fuzzHandler Fuzz the handler of the packet type on the side of the role, seeded with the valid bodies
+// Bodies that are not valid protobuf messages must fail with ErrMalformedPacket, the others may fail with any error,
+// but must never crash the handler or write outside the sync root
+func fuzzHandler(f *testing.F, packetType PacketType, role database.Role, message func() proto.Message,
+	seeds ...proto.Message) {
+	side := newFuzzSide(f, role)
+	for _, seed := range seeds {
+		data, err := proto.Marshal(seed)
+		if err != nil {
+			f.Fatalf("failed to marshal seed: %v", err)
+		}
+		f.Add(data)
+	}
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, body []byte) {
+		_, err := side.handle(t, packetType, body)
+		var malformed ErrMalformedPacket
+		if proto.Unmarshal(body, message()) != nil && !errors.As(err, &malformed) {
+			t.Fatalf("err = %v for malformed body, want ErrMalformedPacket", err)
+		}
+		entries, err := os.ReadDir(side.dir)
+		if err != nil {
+			t.Fatalf("failed to list directory: %v", err)
+		}
+		for _, e := range entries {
+			if e.Name() != "sync" && !bytes.HasPrefix([]byte(e.Name()), []byte("syncat.db")) {
+				t.Fatalf("%s is written outside the sync root", e.Name())
+			}
+		}
+	})
+}
+
+func FuzzAuthHandler(f *testing.F) {
+	fuzzHandler(f, AUTH, database.ServerRole, func() proto.Message { return &pb.SyncatAuthRequestBody{} },
+		&pb.SyncatAuthRequestBody{Token: "token", HashAlgorithms: hashing.Names(hashing.Supported)},
+		&pb.SyncatAuthRequestBody{Token: "token", ClientUuid: "uuid"},
+		&pb.SyncatAuthRequestBody{Token: "wrong"})
+}
+
+func FuzzReplyHandler(f *testing.F) {
+	fuzzHandler(f, REPLY, database.ClientRole, func() proto.Message { return &pb.SyncatReplyRequestBody{} },
+		&pb.SyncatReplyRequestBody{Success: true, ClientUuid: "uuid", HashAlgorithm: string(hashing.SHA256),
+			Compression: string(compression.Gzip)},
+		&pb.SyncatReplyRequestBody{Message: "Invalid token"})
+}
+
+func FuzzFileHandler(f *testing.F) {
+	hash, err := hashing.SHA256.Bytes([]byte("hello"))
+	if err != nil {
+		f.Fatalf("failed to hash: %v", err)
+	}
+	chunk := &pb.SyncatFileRequestBody{TransferId: NewTransferId("sync", "docs/b.txt", hash, 5), Root: "sync",
+		Path: "docs/b.txt", Size: 5, Hash: hash, HashAlgorithm: string(hashing.SHA256), Data: []byte("hello"),
+		ChunkHash: hash}
+	compressed, err := compression.Gzip.Compress([]byte("hello"))
+	if err != nil {
+		f.Fatalf("failed to compress: %v", err)
+	}
+	gzipped := proto.Clone(chunk).(*pb.SyncatFileRequestBody)
+	gzipped.Path, gzipped.Data, gzipped.Compression = "docs/c.txt", compressed, string(compression.Gzip)
+	gzipped.TransferId = NewTransferId("sync", "docs/c.txt", hash, 5)
+	probe := &pb.SyncatFileRequestBody{TransferId: NewTransferId("sync", "docs/d.txt", "", 5), Root: "sync",
+		Path: "docs/d.txt", Size: 5}
+	traversing := proto.Clone(chunk).(*pb.SyncatFileRequestBody)
+	traversing.TransferId = "/../../../escaped"
+	fuzzHandler(f, FILE, database.ServerRole, func() proto.Message { return &pb.SyncatFileRequestBody{} },
+		chunk, gzipped, probe, traversing)
+}
+
+func FuzzResumeHandler(f *testing.F) {
+	fuzzHandler(f, RESUME, database.ClientRole, func() proto.Message { return &pb.SyncatResumeRequestBody{} },
+		&pb.SyncatResumeRequestBody{TransferId: "transfer", Offset: 5})
+}
+
+func FuzzSyncHandler(f *testing.F) {
+	dir := &pb.SyncatEntry{Path: "new", IsDir: true, Modified: true}
+	deleted := &pb.SyncatEntry{Path: "docs/a.txt", Deleted: true, Modified: true}
+	fuzzHandler(f, SYNC, database.ServerRole, func() proto.Message { return &pb.SyncatSyncRequestBody{} },
+		&pb.SyncatSyncRequestBody{Root: "sync", Entries: []*pb.SyncatEntry{seedEntry, dir}},
+		&pb.SyncatSyncRequestBody{Root: "sync", Entries: []*pb.SyncatEntry{deleted}},
+		&pb.SyncatSyncRequestBody{Root: "other"})
+}
+
+func FuzzMetaHandler(f *testing.F) {
+	side := newFuzzSide(f, database.ClientRole)
+	seeds := []*pb.SyncatMetaRequestBody{
+		{Root: "sync", Actions: []*pb.SyncatAction{{Kind: pb.SyncatAction_UPLOAD, Entry: seedEntry}}},
+		{Root: "sync", Busy: true},
+	}
+	for _, seed := range seeds {
+		data, err := proto.Marshal(seed)
+		if err != nil {
+			f.Fatalf("failed to marshal seed: %v", err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, body []byte) {
+		req, err := side.handle(t, META, body)
+		if err != nil {
+			return
+		}
+		// the actions are taken by the client afterwards, which relies on every action having an entry
+		for _, a := range req.(*SyncatMetaRequest).Actions {
+			if a.Entry == nil {
+				t.Fatal("action without entry is accepted")
+			}
+		}
+	})
+}
+
+func FuzzGetHandler(f *testing.F) {
+	fuzzHandler(f, GET, database.ServerRole, func() proto.Message { return &pb.SyncatGetRequestBody{} },
+		&pb.SyncatGetRequestBody{Root: "sync", Path: "docs/a.txt"},
+		&pb.SyncatGetRequestBody{Root: "sync", Path: "../escape"})
+}
+
+func FuzzNotifyHandler(f *testing.F) {
+	fuzzHandler(f, NOTIFY, database.ClientRole, func() proto.Message { return &pb.SyncatNotifyRequestBody{} },
+		&pb.SyncatNotifyRequestBody{Root: "sync"})
+}
+
+func FuzzWindowHandler(f *testing.F) {
+	fuzzHandler(f, WINDOW, database.ClientRole, func() proto.Message { return &pb.SyncatWindowRequestBody{} },
+		&pb.SyncatWindowRequestBody{Increment: streamWindow / 4},
+		&pb.SyncatWindowRequestBody{Increment: 1 << 63})
+}
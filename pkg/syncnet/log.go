@@ -1,8 +1,39 @@
 package syncnet
 
-import "log"
+import (
+	"github.com/google/uuid"
+	"golang.org/x/exp/slog"
+)
 
-// Log is the logger for the connection
-func (c *IdleTimeoutConn) Log(v ...any) {
-	log.Println("["+c.RemoteAddr().String()+"]", v)
+// Logger Get the logger for the connection, with the address of the peer
+// Once authenticated, the uuid and name of the client and the id of the session are attached on the server
+func (c *IdleTimeoutConn) Logger() *slog.Logger {
+	logger := slog.Default().With("remote", c.Remote())
+	if c.SessionId != "" {
+		logger = logger.With("client", c.PeerUuid, "name", c.PeerName, "session", c.SessionId)
+	}
+	return logger
+}
+
+// Remote Get the address of the peer, or the network if the transport has no address for the peer,
+// e.g. unix for the clients connecting to a Unix socket
+func (c *IdleTimeoutConn) Remote() string {
+	// unnamed Unix socket peers are shown as @ on Linux
+	if addr := c.RemoteAddr(); addr != nil && addr.String() != "" && addr.String() != "@" {
+		return addr.String()
+	}
+	return c.LocalAddr().Network()
+}
+
+// newSessionId Generate the id of a session, unique across the restarts of the server
+func newSessionId() string {
+	return uuid.NewString()
+}
+
+// Logger Get the logger for the stream, with the id of the stream unless it is the control stream
+func (s *Stream) Logger() *slog.Logger {
+	if s.Id == ControlStream {
+		return s.IdleTimeoutConn.Logger()
+	}
+	return s.IdleTimeoutConn.Logger().With("stream", s.Id)
 }
@@ -6,9 +6,16 @@ type PacketType uint8
 // TypeLength is the length that the packet type information occupies in the protocol header
 const TypeLength = 1
 
+// StreamLength is the length that the stream id occupies in the protocol header
+const StreamLength = 4
+
 // SizeLength is the length that the packet size information occupies in the protocol header
 const SizeLength = 8
 
+// MaxPacketLength is the largest body of a packet, which bounds the memory a peer can make allocated by one packet
+// It leaves room for the manifest of a large sync root in SYNC and META, and for file chunks of maxChunkSize in FILE
+const MaxPacketLength = 64 << 20
+
 const (
 	// ACK Acknowledgement packet
 	ACK PacketType = iota
@@ -24,6 +31,30 @@ const (
 	FILE
 	// SYNC packet
 	SYNC
+	// META packet for replying the actions of a sync session
 	META
+	// BYE packet for closing the connection
 	BYE
+	// RESUME packet for reporting the verified offset of a file transfer
+	RESUME
+	// GET packet for requesting a file from the server
+	GET
+	// NOTIFY packet for pushing the changes of a sync root to the clients
+	NOTIFY
+	// WINDOW packet for granting the peer more bytes to send on a stream
+	WINDOW
+	// CLOSE packet for closing a stream
+	CLOSE
 )
+
+// packetNames are the names of the packet types
+var packetNames = [...]string{"ACK", "AUTH", "REPLY", "PING", "PONG", "FILE", "SYNC", "META", "BYE", "RESUME", "GET",
+	"NOTIFY", "WINDOW", "CLOSE"}
+
+// String returns the name of the packet type
+func (t PacketType) String() string {
+	if int(t) < len(packetNames) {
+		return packetNames[t]
+	}
+	return "UNKNOWN"
+}
@@ -0,0 +1,83 @@
+package syncnet
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the bytes transferred per second with a token bucket
+// A nil RateLimiter, or one with zero rate, does not limit anything, and SetRate limits it
+// It bursts up to one second of transfer
+type RateLimiter struct {
+	mu sync.Mutex
+	// rate is the number of bytes allowed per second
+	rate float64
+	// tokens are the bytes allowed right now, negative if bytes are reserved in advance
+	tokens float64
+	// last is the time tokens are last refilled
+	last time.Time
+}
+
+// SetRate Change the number of bytes allowed per second, unlimited if not positive
+func (l *RateLimiter) SetRate(bytesPerSecond int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rate := float64(bytesPerSecond)
+	if rate < 0 {
+		rate = 0
+	}
+	if rate == l.rate {
+		return
+	}
+	if l.rate == 0 || l.tokens > rate {
+		l.tokens = rate
+	}
+	l.rate, l.last = rate, time.Now()
+}
+
+// reserve Take n bytes from the bucket, and get how long to wait before they are allowed
+func (l *RateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		return 0
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// slice Get how many of n bytes to transfer at once, at most one second of transfer
+// Large transfers are waited for and written in slices, so that the peer never sees the connection idle for long
+func (l *RateLimiter) slice(n int) int {
+	if l == nil {
+		return n
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 || float64(n) <= l.rate {
+		return n
+	}
+	if l.rate < 1 {
+		return 1
+	}
+	return int(l.rate)
+}
+
+// Wait Block until n bytes are allowed to transfer
+func (l *RateLimiter) Wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	if delay := l.reserve(n); delay > 0 {
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,139 @@
+package syncnet
+
+import (
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestLimitedWriteKeepsPeerReading A write held back by the limit for longer than the timeout of the peer is sent in
+// slices, so that the peer reading with that timeout never sees the connection idle
+func TestLimitedWriteKeepsPeerReading(t *testing.T) {
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		_ = a.Close()
+		_ = b.Close()
+	})
+	limiter := &RateLimiter{}
+	limiter.SetRate(1000)
+	sender := &IdleTimeoutConn{Conn: a, IdleTimeout: 5 * time.Second, WriteLimiter: limiter}
+	receiver := &IdleTimeoutConn{Conn: b, IdleTimeout: 1200 * time.Millisecond}
+	data := make([]byte, 2500)
+	written := make(chan error, 1)
+	go func() {
+		_, err := sender.Write(data)
+		written <- err
+	}()
+	_, err := io.ReadFull(receiver, make([]byte, len(data)))
+	if err != nil {
+		t.Fatalf("failed to read the limited write: %v", err)
+	}
+	if err := <-written; err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+}
+
+// TestRateLimiterSlice The bytes transferred at once are at most one second of transfer, unless unlimited
+func TestRateLimiterSlice(t *testing.T) {
+	var unlimited *RateLimiter
+	limited := &RateLimiter{}
+	tests := []struct {
+		name    string
+		limiter *RateLimiter
+		rate    int
+		n       int
+		want    int
+	}{
+		{"nil", unlimited, 0, 5000, 5000},
+		{"zero rate", &RateLimiter{}, 0, 5000, 5000},
+		{"below rate", limited, 1000, 500, 500},
+		{"above rate", limited, 1000, 5000, 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.limiter != nil {
+				tt.limiter.SetRate(tt.rate)
+			}
+			if got := tt.limiter.slice(tt.n); got != tt.want {
+				t.Fatalf("slice(%d) = %d, want %d", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestBandwidthPerSide The bandwidth limits of one side do not limit the connections of another side in the same
+// process, and the limits set later apply to the transfers from then on
+func TestBandwidthPerSide(t *testing.T) {
+	limited, err := NewBandwidth(config.SyncatBandwidthConfig{
+		SyncatBandwidthLimit: config.SyncatBandwidthLimit{Upload: 1000},
+	})
+	if err != nil {
+		t.Fatalf("failed to create bandwidth limits: %v", err)
+	}
+	other, err := NewBandwidth(config.SyncatBandwidthConfig{})
+	if err != nil {
+		t.Fatalf("failed to create bandwidth limits: %v", err)
+	}
+	if n := limited.wait("docs", true, 5000); n != 1000 {
+		t.Fatalf("limited wait(5000) = %d, want 1000", n)
+	}
+	if n := other.wait("docs", true, 5000); n != 5000 {
+		t.Fatalf("wait(5000) of another side = %d, want 5000", n)
+	}
+	limits, err := ParseBandwidth(config.SyncatBandwidthConfig{})
+	if err != nil {
+		t.Fatalf("failed to parse bandwidth limits: %v", err)
+	}
+	limited.Set(limits)
+	if n := limited.wait("docs", true, 5000); n != 5000 {
+		t.Fatalf("wait(5000) after lifting the limits = %d, want 5000", n)
+	}
+}
+
+// TestLimitedPacketKeepsConnectionWriting A packet held back by the bandwidth limit does not hold the connection,
+// so that the packets of the other streams are sent meanwhile
+func TestLimitedPacketKeepsConnectionWriting(t *testing.T) {
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		_ = a.Close()
+		_ = b.Close()
+	})
+	bandwidth, err := NewBandwidth(config.SyncatBandwidthConfig{
+		SyncatBandwidthLimit: config.SyncatBandwidthLimit{Upload: 1000},
+	})
+	if err != nil {
+		t.Fatalf("failed to create bandwidth limits: %v", err)
+	}
+	sender := &IdleTimeoutConn{Conn: a, IdleTimeout: 5 * time.Second, Bandwidth: bandwidth}
+	receiver := &IdleTimeoutConn{Conn: b, IdleTimeout: 5 * time.Second}
+	header := TypeLength + StreamLength + SizeLength
+	body := make([]byte, 1500)
+	limited := make(chan error, 1)
+	go func() {
+		limited <- (&SyncatRequestHeader{PacketType: ACK}).sendWithBody(newStream(sender, 1), body)
+	}()
+	// the limited packet waits for the second second of transfer by now
+	time.Sleep(200 * time.Millisecond)
+	window := make(chan error, 1)
+	go func() {
+		window <- (&SyncatRequestHeader{PacketType: WINDOW}).Send(newStream(sender, 2))
+	}()
+	data := make([]byte, 2*header+len(body))
+	_, err = io.ReadFull(receiver, data)
+	if err != nil {
+		t.Fatalf("failed to read the packets: %v", err)
+	}
+	if PacketType(data[0]) != WINDOW {
+		t.Fatalf("first packet = %v, want WINDOW sent while the limited packet waits", PacketType(data[0]))
+	}
+	if PacketType(data[header]) != ACK {
+		t.Fatalf("second packet = %v, want ACK written at once", PacketType(data[header]))
+	}
+	for _, sent := range []chan error{limited, window} {
+		if err := <-sent; err != nil {
+			t.Fatalf("failed to send: %v", err)
+		}
+	}
+}
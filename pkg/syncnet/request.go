@@ -2,10 +2,18 @@ package syncnet
 
 import (
 	"encoding/binary"
+	"errors"
+	"github.com/JeffersonQin/syncat/pkg/compression"
 	"github.com/JeffersonQin/syncat/pkg/config"
 	"github.com/JeffersonQin/syncat/pkg/database"
+	"github.com/JeffersonQin/syncat/pkg/hashing"
+	"github.com/JeffersonQin/syncat/pkg/metrics"
 	pb "github.com/JeffersonQin/syncat/pkg/proto"
+	"github.com/JeffersonQin/syncat/pkg/version"
 	"github.com/golang/protobuf/proto"
+	"os"
+	"runtime"
+	"time"
 )
 
 // SyncatRequest is the interface for all syncat request
@@ -14,18 +22,24 @@ type SyncatRequest interface {
 	GetType() PacketType
 	// GetLength Get the length of the request
 	GetLength() uint64
-	// Handle the request
-	Handle(conn *IdleTimeoutConn) error
+	// GetStream Get the id of the stream carrying the request
+	GetStream() uint32
+	// Handle the request, with the database of the side handling it
+	Handle(stream *Stream, store *database.Store) error
 	// Send the request
-	Send(conn *IdleTimeoutConn) error
+	Send(stream *Stream) error
 }
 
 // SyncatRequestHeader is the header of all syncat request
 type SyncatRequestHeader struct {
 	// PacketType is the type of the packet
 	PacketType PacketType
+	// StreamId is the id of the stream carrying the packet
+	StreamId uint32
 	// Length is the length of the packet
 	Length uint64
+	// body is the body of the packet received, parsed when the request is handled
+	body []byte
 }
 
 // GetType Get the type of the request from header info, default method for all request
@@ -38,18 +52,63 @@ func (r *SyncatRequestHeader) GetLength() uint64 {
 	return r.Length
 }
 
+// GetStream Get the id of the stream from header info, default method for all request
+func (r *SyncatRequestHeader) GetStream() uint32 {
+	return r.StreamId
+}
+
+// unmarshal Parse the body of the packet received into the protobuf message
+// ErrMalformedPacket is returned if the body is not a valid message
+func (r *SyncatRequestHeader) unmarshal(message proto.Message) error {
+	err := proto.Unmarshal(r.body, message)
+	if err != nil {
+		return ErrMalformedPacket{r.PacketType, err}
+	}
+	return nil
+}
+
 // Send the request based on configured header info
-func (r *SyncatRequestHeader) Send(conn *IdleTimeoutConn) error {
-	data := make([]byte, TypeLength+SizeLength)
+func (r *SyncatRequestHeader) Send(stream *Stream) error {
+	return r.sendWithBody(stream, nil)
+}
+
+// sendWithBody Send the header followed by the body as one packet on the stream
+// The packet waits for the window of the stream and the bandwidth limit of the transfer, except WINDOW and CLOSE
+// The bandwidth is waited for before taking the stream's write lock, so that the other streams keep sending, e.g. the
+// pings keeping the peer receiving, while the packet is held back by the limit
+// The packet is then written at once while holding the lock, so that the packets sent by different goroutines are
+// never interleaved
+// ErrPacketTooLarge is returned if the body exceeds MaxPacketLength, which the peer would refuse
+func (r *SyncatRequestHeader) sendWithBody(stream *Stream, body []byte) error {
+	if len(body) > MaxPacketLength {
+		return ErrPacketTooLarge{uint64(len(body))}
+	}
+	r.StreamId, r.Length = stream.Id, uint64(len(body))
+	data := make([]byte, TypeLength+StreamLength+SizeLength+len(body))
 	data[0] = byte(r.PacketType)
-	binary.BigEndian.PutUint64(data[TypeLength:], r.Length)
-	count, err := conn.Write(data)
+	binary.BigEndian.PutUint32(data[TypeLength:], r.StreamId)
+	binary.BigEndian.PutUint64(data[TypeLength+StreamLength:], r.Length)
+	copy(data[TypeLength+StreamLength+SizeLength:], body)
+	if r.PacketType != WINDOW && r.PacketType != CLOSE {
+		err := stream.reserve(len(body))
+		if err != nil {
+			return err
+		}
+		// at most one second of transfer is waited for at once, so that the changed limits apply soon
+		for allowed := 0; allowed < len(data); {
+			allowed += stream.Bandwidth.wait(stream.transferRoot, true, len(data)-allowed)
+		}
+	}
+	stream.writeMu.Lock()
+	defer stream.writeMu.Unlock()
+	count, err := stream.Write(data)
 	if err != nil {
 		return err
 	}
-	if count != TypeLength+SizeLength {
+	if count != len(data) {
 		return ErrInvalidPacket{count}
 	}
+	metrics.Packets.Inc(metrics.Sent, r.PacketType.String())
 	return nil
 }
 
@@ -58,9 +117,11 @@ type SyncatAckRequest struct {
 	SyncatRequestHeader
 }
 
-// Handle ACK request does not need to be handled, the function is empty
-func (r *SyncatAckRequest) Handle(_ *IdleTimeoutConn) error {
-	return nil
+// Handle ACK request
+// ACK request is sent by the client when all the actions of a sync session are done,
+// and the server ends the sync session and closes its stream
+func (r *SyncatAckRequest) Handle(stream *Stream, store *database.Store) error {
+	return stream.EndSyncSession(store, true)
 }
 
 // NewSyncatAckRequest Create a new SyncatAckRequest
@@ -81,81 +142,130 @@ type SyncatAuthRequest struct {
 
 // Handle AUTH request
 // Authenticate the token and check whether the client is registered
-// If the client is not registered, auth will also fail
+// If the client is not registered or has been revoked, auth will also fail
 // If the client field is empty, the client will be registered
+// The identity metadata reported by the client is recorded, so that the client can be told apart by humans
+// The content hash algorithm and the compression algorithm are negotiated among the ones offered by the client
 // REPLY packet will be sent back as response
+// ErrAuthFailed is returned if the client is rejected, so that the connection is not served
 // AUTH request will only be sent by the client to the server when the connection is established
-func (r *SyncatAuthRequest) Handle(conn *IdleTimeoutConn) error {
-	data := make([]byte, r.Length)
-	_, err := conn.Read(data)
+func (r *SyncatAuthRequest) Handle(stream *Stream, store *database.Store) error {
+	err := r.unmarshal(&r.SyncatAuthRequestBody)
 	if err != nil {
+		metrics.AuthFailures.Inc("malformed")
 		return err
 	}
-	err = proto.Unmarshal(data, &r.SyncatAuthRequestBody)
-	if err != nil {
-		return err
+	sharedConfig := stream.sharedConfig()
+	if r.SyncatAuthRequestBody.Token != sharedConfig.Auth.Token {
+		metrics.AuthFailures.Inc("invalid_token")
+		_ = NewSyncatReplyRequest(false, r.SyncatAuthRequestBody.ClientUuid,
+			"Invalid token").Send(stream)
+		return ErrAuthFailed{"Invalid token"}
 	}
-	if r.SyncatAuthRequestBody.Token != config.GetConfig().Auth.Token {
-		err = NewSyncatReplyRequest(false, r.SyncatAuthRequestBody.ClientUuid,
-			"Invalid token").Send(conn)
+	algorithm, err := hashing.Negotiate(r.SyncatAuthRequestBody.HashAlgorithms)
+	if err != nil {
+		metrics.AuthFailures.Inc("unsupported_hash")
+		_ = NewSyncatReplyRequest(false, r.SyncatAuthRequestBody.ClientUuid,
+			"Unsupported hash algorithms").Send(stream)
 		return err
 	}
 	uuid := r.SyncatAuthRequestBody.ClientUuid
 	// Allocate uuid for a new client if is a new client
 	if r.SyncatAuthRequestBody.ClientUuid == "" {
-		uuid, err = database.AllocateNewClientUuid()
+		uuid, err = store.AllocateNewClientUuid()
 		if err != nil {
+			metrics.AuthFailures.Inc("internal")
 			_ = NewSyncatReplyRequest(false, r.SyncatAuthRequestBody.ClientUuid,
-				"Failed to allocate new uuid").Send(conn)
+				"Failed to allocate new uuid").Send(stream)
 			return err
 		}
 	}
 	// check whether the uuid exists in database
-	exists, err := database.QueryExistsClientUuid(uuid)
+	client, exists, err := store.QueryClient(uuid)
 	if err != nil {
+		metrics.AuthFailures.Inc("internal")
 		_ = NewSyncatReplyRequest(false, r.SyncatAuthRequestBody.ClientUuid,
-			"Failed to query uuid").Send
+			"Failed to query uuid").Send(stream)
 		return err
 	}
 	if !exists {
-		err = NewSyncatReplyRequest(false, r.SyncatAuthRequestBody.ClientUuid,
-			"Invalid uuid").Send(conn)
+		metrics.AuthFailures.Inc("invalid_uuid")
+		_ = NewSyncatReplyRequest(false, r.SyncatAuthRequestBody.ClientUuid,
+			"Invalid uuid").Send(stream)
+		return ErrAuthFailed{"Invalid uuid"}
+	}
+	if client.Revoked {
+		metrics.AuthFailures.Inc("revoked")
+		_ = NewSyncatReplyRequest(false, r.SyncatAuthRequestBody.ClientUuid,
+			"Revoked client").Send(stream)
+		return ErrAuthFailed{"Revoked client"}
+	}
+	err = store.UpdateClientSeen(client.Id, database.ClientInfo{
+		DeviceName: r.SyncatAuthRequestBody.DeviceName,
+		Hostname:   r.SyncatAuthRequestBody.Hostname,
+		Os:         r.SyncatAuthRequestBody.Os,
+		Version:    r.SyncatAuthRequestBody.Version,
+	})
+	if err != nil {
+		metrics.AuthFailures.Inc("internal")
+		_ = NewSyncatReplyRequest(false, r.SyncatAuthRequestBody.ClientUuid,
+			"Failed to update client").Send(stream)
 		return err
 	}
 	// success
-	err = NewSyncatReplyRequest(true, uuid, "OK").Send(conn)
+	client.DeviceName, client.Hostname = r.SyncatAuthRequestBody.DeviceName, r.SyncatAuthRequestBody.Hostname
+	stream.PeerId, stream.PeerUuid, stream.PeerName = client.Id, client.Uuid, client.DisplayName()
+	stream.SessionId = newSessionId()
+	stream.HashAlgorithm = algorithm
+	stream.Compression = compression.None
+	if !sharedConfig.Protocol.DisableCompression {
+		stream.Compression = compression.Negotiate(r.SyncatAuthRequestBody.Compressions)
+	}
+	reply := NewSyncatReplyRequest(true, uuid, "OK")
+	reply.HashAlgorithm = string(algorithm)
+	reply.Compression = string(stream.Compression)
+	err = reply.Send(stream)
 	return err
 }
 
 // Send the AUTH request
-func (r *SyncatAuthRequest) Send(conn *IdleTimeoutConn) error {
+func (r *SyncatAuthRequest) Send(stream *Stream) error {
 	data, err := proto.Marshal(&r.SyncatAuthRequestBody)
 	if err != nil {
 		return err
 	}
-	r.Length = uint64(len(data))
-	err = r.SyncatRequestHeader.Send(conn)
-	if err != nil {
-		return err
-	}
-	_, err = conn.Write(data)
-	return err
+	return r.SyncatRequestHeader.sendWithBody(stream, data)
 }
 
-// NewSyncatAuthRequest Create a new SyncatAuthRequest
-func NewSyncatAuthRequest() (*SyncatAuthRequest, error) {
-	clientUuid, err := database.QueryClientUuid()
+// NewSyncatAuthRequest Create a new SyncatAuthRequest with the token of the shared configuration,
+// and the identity metadata of the client
+// deviceName is the name of the device chosen by the user, which can be empty
+func NewSyncatAuthRequest(store *database.Store, sharedConfig config.SyncatConfig,
+	deviceName string) (*SyncatAuthRequest, error) {
+	clientUuid, err := store.QueryClientUuid()
 	if err != nil {
 		return nil, err
 	}
+	// the hostname is only informative
+	hostname, _ := os.Hostname()
+	var compressions []string
+	if !sharedConfig.Protocol.DisableCompression {
+		compressions = compression.Names(compression.Supported)
+	}
 	return &SyncatAuthRequest{
 		SyncatRequestHeader{
 			PacketType: AUTH,
 			Length:     0,
 		},
 		pb.SyncatAuthRequestBody{
-			ClientUuid: clientUuid,
-			Token:      config.GetConfig().Auth.Token,
+			ClientUuid:     clientUuid,
+			Token:          sharedConfig.Auth.Token,
+			HashAlgorithms: hashing.Names(hashing.Supported),
+			Compressions:   compressions,
+			Hostname:       hostname,
+			Os:             runtime.GOOS + "/" + runtime.GOARCH,
+			Version:        version.Version,
+			DeviceName:     deviceName,
 		},
 	}, nil
 }
@@ -168,19 +278,23 @@ type SyncatReplyRequest struct {
 
 // Handle REPLY request
 // Check whether the auth is successful, and also update the client's uuid when newly registered
+// The content hash algorithm chosen by the server is used for the connection
 // REPLY request will only be sent by the server to the client when the connection is established
-func (r *SyncatReplyRequest) Handle(conn *IdleTimeoutConn) error {
-	data := make([]byte, r.Length)
-	_, err := conn.Read(data)
-	if err != nil {
-		return err
-	}
-	err = proto.Unmarshal(data, &r.SyncatReplyRequestBody)
+func (r *SyncatReplyRequest) Handle(stream *Stream, store *database.Store) error {
+	err := r.unmarshal(&r.SyncatReplyRequestBody)
 	if err != nil {
 		return err
 	}
 	if r.SyncatReplyRequestBody.Success {
-		err = database.UpdateClientUuid(r.SyncatReplyRequestBody.ClientUuid)
+		stream.HashAlgorithm, err = hashing.Parse(r.SyncatReplyRequestBody.HashAlgorithm)
+		if err != nil {
+			return err
+		}
+		stream.Compression, err = compression.Parse(r.SyncatReplyRequestBody.Compression)
+		if err != nil {
+			return err
+		}
+		err = store.UpdateClientUuid(r.SyncatReplyRequestBody.ClientUuid)
 		return err
 	} else {
 		return ErrAuthFailed{r.SyncatReplyRequestBody.Message}
@@ -188,18 +302,12 @@ func (r *SyncatReplyRequest) Handle(conn *IdleTimeoutConn) error {
 }
 
 // Send the REPLY request
-func (r *SyncatReplyRequest) Send(conn *IdleTimeoutConn) error {
+func (r *SyncatReplyRequest) Send(stream *Stream) error {
 	data, err := proto.Marshal(&r.SyncatReplyRequestBody)
 	if err != nil {
 		return err
 	}
-	r.Length = uint64(len(data))
-	err = r.SyncatRequestHeader.Send(conn)
-	if err != nil {
-		return err
-	}
-	_, err = conn.Write(data)
-	return err
+	return r.SyncatRequestHeader.sendWithBody(stream, data)
 }
 
 // NewSyncatReplyRequest Create a new SyncatReplyRequest
@@ -216,3 +324,427 @@ func NewSyncatReplyRequest(success bool, clientUUid string, message string) *Syn
 		},
 	}
 }
+
+// SyncatFileRequest is the request for FILE packet
+type SyncatFileRequest struct {
+	SyncatRequestHeader
+	pb.SyncatFileRequestBody
+}
+
+// Handle FILE request
+// Verify the chunk and write it into the staged file at the verified offset
+// The verified offset is recorded in database, so that an interrupted transfer can be resumed
+// A FILE request without data is a probe for the verified offset of the transfer
+// When the whole file is received, it will be verified and atomically moved to its destination
+// RESUME packet will be sent back with the verified offset as response
+// The server only accepts the files uploaded in the sync session of their sync root on the stream,
+// which has locked the sync root, and ErrNoSyncSession is returned otherwise
+func (r *SyncatFileRequest) Handle(stream *Stream, store *database.Store) error {
+	err := r.unmarshal(&r.SyncatFileRequestBody)
+	if err != nil {
+		return err
+	}
+	if r.Root == "" || stream.SyncRoot != r.Root {
+		return ErrNoSyncSession{r.Root}
+	}
+	_, err = r.stage(stream, store)
+	return err
+}
+
+// receive Handle the FILE request, and return the verified offset replied to the sender
+func (r *SyncatFileRequest) receive(stream *Stream, store *database.Store) (uint64, error) {
+	err := r.unmarshal(&r.SyncatFileRequestBody)
+	if err != nil {
+		return 0, err
+	}
+	return r.stage(stream, store)
+}
+
+// stage Stage the chunk of the FILE request parsed already, and return the verified offset replied to the sender
+func (r *SyncatFileRequest) stage(stream *Stream, store *database.Store) (uint64, error) {
+	offset, err := stageChunk(stream, store, &r.SyncatFileRequestBody)
+	if err != nil {
+		return 0, err
+	}
+	return offset, NewSyncatResumeRequest(r.SyncatFileRequestBody.TransferId, offset).Send(stream)
+}
+
+// Send the FILE request
+func (r *SyncatFileRequest) Send(stream *Stream) error {
+	data, err := proto.Marshal(&r.SyncatFileRequestBody)
+	if err != nil {
+		return err
+	}
+	return r.SyncatRequestHeader.sendWithBody(stream, data)
+}
+
+// NewSyncatFileRequest Create a new SyncatFileRequest carrying a chunk of file starting at offset
+// The chunk is hashed with the same algorithm as the whole file
+func NewSyncatFileRequest(transferId string, root string, path string, size uint64, hash string,
+	hashAlgorithm hashing.Algorithm, timestamp time.Time, offset uint64, chunk []byte) (*SyncatFileRequest, error) {
+	chunkHash := ""
+	if len(chunk) > 0 {
+		var err error
+		chunkHash, err = hashAlgorithm.Bytes(chunk)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &SyncatFileRequest{
+		SyncatRequestHeader{
+			PacketType: FILE,
+			Length:     0,
+		},
+		pb.SyncatFileRequestBody{
+			TransferId:    transferId,
+			Root:          root,
+			Path:          path,
+			Size:          size,
+			Hash:          hash,
+			Offset:        offset,
+			Data:          chunk,
+			ChunkHash:     chunkHash,
+			Timestamp:     timestamp.UnixNano(),
+			HashAlgorithm: string(hashAlgorithm),
+		},
+	}, nil
+}
+
+// SyncatResumeRequest is the request for RESUME packet
+type SyncatResumeRequest struct {
+	SyncatRequestHeader
+	pb.SyncatResumeRequestBody
+}
+
+// Handle RESUME request
+// The verified offset is parsed into the request body, and the sender of the file continues from there
+// RESUME request will only be sent by the receiver of a file as the response of FILE request
+func (r *SyncatResumeRequest) Handle(stream *Stream, store *database.Store) error {
+	return r.unmarshal(&r.SyncatResumeRequestBody)
+}
+
+// Send the RESUME request
+func (r *SyncatResumeRequest) Send(stream *Stream) error {
+	data, err := proto.Marshal(&r.SyncatResumeRequestBody)
+	if err != nil {
+		return err
+	}
+	return r.SyncatRequestHeader.sendWithBody(stream, data)
+}
+
+// NewSyncatResumeRequest Create a new SyncatResumeRequest
+func NewSyncatResumeRequest(transferId string, offset uint64) *SyncatResumeRequest {
+	return &SyncatResumeRequest{
+		SyncatRequestHeader{
+			PacketType: RESUME,
+			Length:     0,
+		},
+		pb.SyncatResumeRequestBody{
+			TransferId: transferId,
+			Offset:     offset,
+		},
+	}
+}
+
+// SyncatPingRequest is the request for PING packet
+type SyncatPingRequest struct {
+	SyncatRequestHeader
+}
+
+// Handle PING request
+// PONG packet will be sent back as response
+// PING request is sent by the client periodically, so that the connection is not closed for idle timeout
+func (r *SyncatPingRequest) Handle(stream *Stream, _ *database.Store) error {
+	return NewSyncatPongRequest().Send(stream)
+}
+
+// NewSyncatPingRequest Create a new SyncatPingRequest
+func NewSyncatPingRequest() *SyncatPingRequest {
+	return &SyncatPingRequest{
+		SyncatRequestHeader{
+			PacketType: PING,
+			Length:     0,
+		},
+	}
+}
+
+// SyncatPongRequest is the request for PONG packet
+type SyncatPongRequest struct {
+	SyncatRequestHeader
+}
+
+// Handle PONG request does not need to be handled, the function is empty
+func (r *SyncatPongRequest) Handle(_ *Stream, _ *database.Store) error {
+	return nil
+}
+
+// NewSyncatPongRequest Create a new SyncatPongRequest
+func NewSyncatPongRequest() *SyncatPongRequest {
+	return &SyncatPongRequest{
+		SyncatRequestHeader{
+			PacketType: PONG,
+			Length:     0,
+		},
+	}
+}
+
+// SyncatByeRequest is the request for BYE packet
+type SyncatByeRequest struct {
+	SyncatRequestHeader
+}
+
+// Handle BYE request does not need to be handled, the function is empty
+// The connection is closed by the receiver of BYE request
+func (r *SyncatByeRequest) Handle(_ *Stream, _ *database.Store) error {
+	return nil
+}
+
+// NewSyncatByeRequest Create a new SyncatByeRequest
+func NewSyncatByeRequest() *SyncatByeRequest {
+	return &SyncatByeRequest{
+		SyncatRequestHeader{
+			PacketType: BYE,
+			Length:     0,
+		},
+	}
+}
+
+// SyncatSyncRequest is the request for SYNC packet
+type SyncatSyncRequest struct {
+	SyncatRequestHeader
+	pb.SyncatSyncRequestBody
+}
+
+// Handle SYNC request
+// The manifest of the client is compared with the entries of the server,
+// and the actions for the client to take are decided
+// META packet will be sent back with the actions as response
+// SYNC request will only be sent by the client to the server to start a sync session of a sync root
+func (r *SyncatSyncRequest) Handle(stream *Stream, store *database.Store) error {
+	err := r.unmarshal(&r.SyncatSyncRequestBody)
+	if err != nil {
+		return err
+	}
+	return serveSync(stream, store, &r.SyncatSyncRequestBody)
+}
+
+// Send the SYNC request
+func (r *SyncatSyncRequest) Send(stream *Stream) error {
+	data, err := proto.Marshal(&r.SyncatSyncRequestBody)
+	if err != nil {
+		return err
+	}
+	return r.SyncatRequestHeader.sendWithBody(stream, data)
+}
+
+// NewSyncatSyncRequest Create a new SyncatSyncRequest with the manifest of the sync root
+func NewSyncatSyncRequest(root string, entries []*pb.SyncatEntry) *SyncatSyncRequest {
+	return &SyncatSyncRequest{
+		SyncatRequestHeader{
+			PacketType: SYNC,
+			Length:     0,
+		},
+		pb.SyncatSyncRequestBody{
+			Root:    root,
+			Entries: entries,
+		},
+	}
+}
+
+// SyncatMetaRequest is the request for META packet
+type SyncatMetaRequest struct {
+	SyncatRequestHeader
+	pb.SyncatMetaRequestBody
+}
+
+// Handle META request
+// The actions are parsed into the request body, and taken by the client afterwards
+// ErrMalformedPacket is returned if an action has no entry to take
+// META request will only be sent by the server to the client as the response of SYNC request
+func (r *SyncatMetaRequest) Handle(stream *Stream, _ *database.Store) error {
+	err := r.unmarshal(&r.SyncatMetaRequestBody)
+	if err != nil {
+		return err
+	}
+	for _, a := range r.SyncatMetaRequestBody.Actions {
+		if a.Entry == nil {
+			return ErrMalformedPacket{r.PacketType, errors.New("action without entry")}
+		}
+	}
+	return nil
+}
+
+// Send the META request
+func (r *SyncatMetaRequest) Send(stream *Stream) error {
+	data, err := proto.Marshal(&r.SyncatMetaRequestBody)
+	if err != nil {
+		return err
+	}
+	return r.SyncatRequestHeader.sendWithBody(stream, data)
+}
+
+// NewSyncatMetaRequest Create a new SyncatMetaRequest
+// busy indicates that the sync root is in the session of another client, and the actions are empty
+func NewSyncatMetaRequest(root string, actions []*pb.SyncatAction, busy bool) *SyncatMetaRequest {
+	return &SyncatMetaRequest{
+		SyncatRequestHeader{
+			PacketType: META,
+			Length:     0,
+		},
+		pb.SyncatMetaRequestBody{
+			Root:    root,
+			Actions: actions,
+			Busy:    busy,
+		},
+	}
+}
+
+// SyncatGetRequest is the request for GET packet
+type SyncatGetRequest struct {
+	SyncatRequestHeader
+	pb.SyncatGetRequestBody
+}
+
+// Handle GET request
+// The requested file is sent back by FILE requests, with the version recorded by the server
+// GET request will only be sent by the client to the server during a sync session
+// ErrNoSyncSession is returned unless the stream is in the sync session of the sync root, which has locked it
+func (r *SyncatGetRequest) Handle(stream *Stream, store *database.Store) error {
+	err := r.unmarshal(&r.SyncatGetRequestBody)
+	if err != nil {
+		return err
+	}
+	if r.Root == "" || stream.SyncRoot != r.Root {
+		return ErrNoSyncSession{r.Root}
+	}
+	return serveGet(stream, store, &r.SyncatGetRequestBody)
+}
+
+// Send the GET request
+func (r *SyncatGetRequest) Send(stream *Stream) error {
+	data, err := proto.Marshal(&r.SyncatGetRequestBody)
+	if err != nil {
+		return err
+	}
+	return r.SyncatRequestHeader.sendWithBody(stream, data)
+}
+
+// NewSyncatGetRequest Create a new SyncatGetRequest
+func NewSyncatGetRequest(root string, path string) *SyncatGetRequest {
+	return &SyncatGetRequest{
+		SyncatRequestHeader{
+			PacketType: GET,
+			Length:     0,
+		},
+		pb.SyncatGetRequestBody{
+			Root: root,
+			Path: path,
+		},
+	}
+}
+
+// SyncatNotifyRequest is the request for NOTIFY packet
+type SyncatNotifyRequest struct {
+	SyncatRequestHeader
+	pb.SyncatNotifyRequestBody
+}
+
+// Handle NOTIFY request
+// The sync root is recorded, and synced by the client when it is idle
+// NOTIFY request will only be sent by the server to the clients on the control stream,
+// when a sync root is changed by another client, and is handled as soon as it arrives
+func (r *SyncatNotifyRequest) Handle(stream *Stream, _ *database.Store) error {
+	err := r.unmarshal(&r.SyncatNotifyRequestBody)
+	if err != nil {
+		return err
+	}
+	stream.notify(r.SyncatNotifyRequestBody.Root)
+	return nil
+}
+
+// Send the NOTIFY request
+func (r *SyncatNotifyRequest) Send(stream *Stream) error {
+	data, err := proto.Marshal(&r.SyncatNotifyRequestBody)
+	if err != nil {
+		return err
+	}
+	return r.SyncatRequestHeader.sendWithBody(stream, data)
+}
+
+// NewSyncatNotifyRequest Create a new SyncatNotifyRequest
+func NewSyncatNotifyRequest(root string) *SyncatNotifyRequest {
+	return &SyncatNotifyRequest{
+		SyncatRequestHeader{
+			PacketType: NOTIFY,
+			Length:     0,
+		},
+		pb.SyncatNotifyRequestBody{
+			Root: root,
+		},
+	}
+}
+
+// SyncatWindowRequest is the request for WINDOW packet
+type SyncatWindowRequest struct {
+	SyncatRequestHeader
+	pb.SyncatWindowRequestBody
+}
+
+// Handle WINDOW request
+// The peer is allowed to send more bytes on the stream, since the bytes it sent before are consumed
+// WINDOW request is sent by the receiver of the packets on a stream, and is handled as soon as it arrives
+func (r *SyncatWindowRequest) Handle(stream *Stream, _ *database.Store) error {
+	err := r.unmarshal(&r.SyncatWindowRequestBody)
+	if err != nil {
+		return err
+	}
+	// the peer never grants more than the window, which would overflow the bytes allowed to send
+	if r.SyncatWindowRequestBody.Increment > streamWindow {
+		return ErrFlowControl{stream.Id}
+	}
+	stream.grant(r.SyncatWindowRequestBody.Increment)
+	return nil
+}
+
+// Send the WINDOW request
+func (r *SyncatWindowRequest) Send(stream *Stream) error {
+	data, err := proto.Marshal(&r.SyncatWindowRequestBody)
+	if err != nil {
+		return err
+	}
+	return r.SyncatRequestHeader.sendWithBody(stream, data)
+}
+
+// NewSyncatWindowRequest Create a new SyncatWindowRequest granting the bytes to the peer
+func NewSyncatWindowRequest(increment uint64) *SyncatWindowRequest {
+	return &SyncatWindowRequest{
+		SyncatRequestHeader{
+			PacketType: WINDOW,
+			Length:     0,
+		},
+		pb.SyncatWindowRequestBody{
+			Increment: increment,
+		},
+	}
+}
+
+// SyncatCloseRequest is the request for CLOSE packet
+type SyncatCloseRequest struct {
+	SyncatRequestHeader
+}
+
+// Handle CLOSE request does not need to be handled, the function is empty
+// The stream is marked closed as soon as CLOSE request arrives
+func (r *SyncatCloseRequest) Handle(_ *Stream, _ *database.Store) error {
+	return nil
+}
+
+// NewSyncatCloseRequest Create a new SyncatCloseRequest
+func NewSyncatCloseRequest() *SyncatCloseRequest {
+	return &SyncatCloseRequest{
+		SyncatRequestHeader{
+			PacketType: CLOSE,
+			Length:     0,
+		},
+	}
+}
@@ -1,14 +1,17 @@
 package syncnet
 
 import (
+	"bytes"
 	"encoding/binary"
 	pb "github.com/JeffersonQin/syncat/pkg/proto"
 	"golang.org/x/exp/slices"
+	"io"
 )
 
-// Wait for the certain kinds of packet, provided by typeList
-func Wait(conn *IdleTimeoutConn, typeList []PacketType) (SyncatRequest, error) {
-	request, err := RouteConn(conn)
+// Wait for the next packet on the stream, which must be one of the kinds provided by typeList
+// ErrStreamClosed is returned if the stream is closed by the peer instead
+func Wait(stream *Stream, typeList []PacketType) (SyncatRequest, error) {
+	request, err := stream.next()
 	if err != nil {
 		return nil, err
 	}
@@ -19,40 +22,84 @@ func Wait(conn *IdleTimeoutConn, typeList []PacketType) (SyncatRequest, error) {
 }
 
 // RouteConn wait for the next packet, parse the request header and identify which type of request it is
+// The body of the packet is read along with the header, and parsed when the request is handled
+// The error of the connection is returned if it ends between packets, and ErrTruncatedPacket if within a packet
+// The header is checked before the body is read, so that ErrInvalidPacketType and ErrPacketTooLarge are returned
+// without allocating the body claimed by the header
 func RouteConn(conn *IdleTimeoutConn) (SyncatRequest, error) {
-	headData := make([]byte, TypeLength+SizeLength)
-	count, err := conn.Read(headData)
+	headData := make([]byte, TypeLength+StreamLength+SizeLength)
+	count, err := io.ReadFull(conn, headData)
+	if err == io.ErrUnexpectedEOF {
+		return nil, ErrTruncatedPacket{len(headData), count}
+	}
+	if err != nil {
+		return nil, err
+	}
+	header := SyncatRequestHeader{
+		PacketType: PacketType(headData[0]),
+		StreamId:   binary.BigEndian.Uint32(headData[TypeLength:]),
+		Length:     binary.BigEndian.Uint64(headData[TypeLength+StreamLength:]),
+	}
+	if int(header.PacketType) >= len(packetNames) {
+		return nil, ErrInvalidPacketType{headData[0]}
+	}
+	if header.Length > MaxPacketLength {
+		return nil, ErrPacketTooLarge{header.Length}
+	}
+	header.body, err = readBody(conn, int(header.Length))
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ErrTruncatedPacket{len(headData) + int(header.Length), len(headData) + len(header.body)}
+	}
 	if err != nil {
 		return nil, err
 	}
-	if count != TypeLength+SizeLength {
-		return nil, ErrInvalidPacket{count}
-	}
-	length := binary.BigEndian.Uint64(headData[TypeLength:])
-	switch headData[0] {
-	case byte(ACK):
-		return &SyncatAckRequest{
-			SyncatRequestHeader{
-				PacketType: ACK,
-				Length:     length,
-			},
-		}, nil
-	case byte(AUTH):
-		return &SyncatAuthRequest{
-			SyncatRequestHeader{
-				PacketType: AUTH,
-				Length:     length,
-			},
-			pb.SyncatAuthRequestBody{},
-		}, nil
-	case byte(REPLY):
-		return &SyncatReplyRequest{
-			SyncatRequestHeader{
-				PacketType: REPLY,
-				Length:     length,
-			},
-			pb.SyncatReplyRequestBody{},
-		}, nil
+	switch header.PacketType {
+	case ACK:
+		return &SyncatAckRequest{header}, nil
+	case AUTH:
+		return &SyncatAuthRequest{header, pb.SyncatAuthRequestBody{}}, nil
+	case REPLY:
+		return &SyncatReplyRequest{header, pb.SyncatReplyRequestBody{}}, nil
+	case PING:
+		return &SyncatPingRequest{header}, nil
+	case PONG:
+		return &SyncatPongRequest{header}, nil
+	case FILE:
+		return &SyncatFileRequest{header, pb.SyncatFileRequestBody{}}, nil
+	case RESUME:
+		return &SyncatResumeRequest{header, pb.SyncatResumeRequestBody{}}, nil
+	case SYNC:
+		return &SyncatSyncRequest{header, pb.SyncatSyncRequestBody{}}, nil
+	case META:
+		return &SyncatMetaRequest{header, pb.SyncatMetaRequestBody{}}, nil
+	case BYE:
+		return &SyncatByeRequest{header}, nil
+	case GET:
+		return &SyncatGetRequest{header, pb.SyncatGetRequestBody{}}, nil
+	case NOTIFY:
+		return &SyncatNotifyRequest{header, pb.SyncatNotifyRequestBody{}}, nil
+	case WINDOW:
+		return &SyncatWindowRequest{header, pb.SyncatWindowRequestBody{}}, nil
+	case CLOSE:
+		return &SyncatCloseRequest{header}, nil
 	}
 	return nil, ErrInvalidPacketType{headData[0]}
 }
+
+// bodyChunk is the size the body of a packet is allocated by at first
+const bodyChunk = 64 << 10
+
+// readBody Read the body of the length from the connection, and return the bytes read so far on error
+// The body is allocated as it arrives, so that a peer claiming a large body without sending it cannot make
+// the whole length allocated
+func readBody(conn io.Reader, length int) ([]byte, error) {
+	if length <= bodyChunk {
+		body := make([]byte, length)
+		count, err := io.ReadFull(conn, body)
+		return body[:count], err
+	}
+	var buf bytes.Buffer
+	buf.Grow(bodyChunk)
+	_, err := io.CopyN(&buf, conn, int64(length))
+	return buf.Bytes(), err
+}
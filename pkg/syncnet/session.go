@@ -0,0 +1,556 @@
+package syncnet
+
+import (
+	"github.com/JeffersonQin/syncat/pkg/database"
+	"github.com/JeffersonQin/syncat/pkg/metrics"
+	pb "github.com/JeffersonQin/syncat/pkg/proto"
+	"github.com/JeffersonQin/syncat/pkg/scanner"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SyncStats is the summary of a sync session on the client
+type SyncStats struct {
+	// Uploaded is the number of files sent to the server
+	Uploaded int `json:"uploaded"`
+	// Downloaded is the number of files received from the server
+	Downloaded int `json:"downloaded"`
+	// Deleted is the number of files and directories deleted locally
+	Deleted int `json:"deleted"`
+	// Conflicts is the number of conflicts detected
+	Conflicts int `json:"conflicts"`
+	// RawBytes is the size of the file chunks transferred before compression
+	RawBytes int64 `json:"raw_bytes"`
+	// WireBytes is the size of the file chunks transferred after compression
+	WireBytes int64 `json:"wire_bytes"`
+}
+
+// CompressionRatio Get the ratio of the size of the file chunks before compression to after, 1 if nothing transferred
+func (s SyncStats) CompressionRatio() float64 {
+	if s.WireBytes == 0 {
+		return 1
+	}
+	return float64(s.RawBytes) / float64(s.WireBytes)
+}
+
+// entryToPb Convert the entry to its protocol form
+func entryToPb(e database.Entry) *pb.SyncatEntry {
+	return &pb.SyncatEntry{
+		Path:          e.Path,
+		Hash:          e.Hash,
+		HashAlgorithm: e.HashAlgo,
+		Timestamp:     e.Timestamp.UnixNano(),
+		Size:          e.Size,
+		IsDir:         e.IsDir,
+		Deleted:       e.Deleted,
+		Uuid:          e.Uuid,
+	}
+}
+
+// entryFromPb Convert the entry in protocol form to the entry in the sync root
+func entryFromPb(root string, e *pb.SyncatEntry) database.Entry {
+	return database.Entry{
+		Root:      root,
+		Path:      cleanPath(e.Path),
+		Hash:      e.Hash,
+		HashAlgo:  e.HashAlgorithm,
+		Timestamp: time.Unix(0, e.Timestamp),
+		Size:      e.Size,
+		IsDir:     e.IsDir,
+		Deleted:   e.Deleted,
+		Uuid:      e.Uuid,
+	}
+}
+
+// sameContent Check whether the entry of the client and the entry of the server have the same content
+func sameContent(c *pb.SyncatEntry, s database.Entry, exists bool) bool {
+	if c.Deleted {
+		return !exists || s.Deleted
+	}
+	if !exists || s.Deleted || c.IsDir != s.IsDir {
+		return false
+	}
+	return c.IsDir || (c.Hash == s.Hash && c.HashAlgorithm == s.HashAlgo)
+}
+
+// serverAction Get the kind of action for the client to take the entry of the server
+func serverAction(s database.Entry) pb.SyncatAction_Kind {
+	if s.Deleted {
+		return pb.SyncatAction_DELETE
+	}
+	if s.IsDir {
+		return pb.SyncatAction_MKDIR
+	}
+	return pb.SyncatAction_DOWNLOAD
+}
+
+// serveSync Start the sync session of the client on the sync root, and reply the actions for the client to take
+// The sync root is locked until the client acknowledges the end of the session, or the stream is closed
+// The session is refused if the client is revoked since it authenticated
+func serveSync(stream *Stream, store *database.Store, body *pb.SyncatSyncRequestBody) error {
+	dir, ok := stream.sharedConfig().GetSyncDirectory(body.Root)
+	if !ok {
+		return ErrUnknownRoot{body.Root}
+	}
+	client, exists, err := store.QueryClient(stream.PeerUuid)
+	if err != nil {
+		return err
+	}
+	if !exists || client.Revoked {
+		return ErrClientRevoked{stream.PeerUuid}
+	}
+	// a session is never left unacknowledged by the client, end it anyway
+	err = stream.EndSyncSession(store, false)
+	if err != nil {
+		return err
+	}
+	if !store.TryLockRoot(body.Root) {
+		return NewSyncatMetaRequest(body.Root, nil, true).Send(stream)
+	}
+	stream.SyncRoot, stream.sessionStart, stream.transferRoot = body.Root, time.Now(), body.Root
+	// changes found on the server itself are pushed to other clients as well
+	changed, err := scanner.Scan(store, body.Root, dir, stream.HashAlgorithm)
+	if err != nil {
+		return err
+	}
+	stream.changed = changed
+	actions, err := planSync(stream, store, body.Root, body.Entries)
+	if err != nil {
+		return err
+	}
+	return NewSyncatMetaRequest(body.Root, actions, false).Send(stream)
+}
+
+// EndSyncSession End the sync session of the client on the stream, and release the sync root
+// The result of the session is recorded for the client, completed if the client acknowledges its end
+// If the entries of the sync root are changed during the session, OnChange is called to notify other clients
+// It is called when the client acknowledges the end of the session, or when the stream is closed
+func (s *Stream) EndSyncSession(store *database.Store, completed bool) error {
+	if s.SyncRoot == "" {
+		return nil
+	}
+	root, changed := s.SyncRoot, s.changed
+	store.UnlockRoot(root)
+	s.SyncRoot, s.changed, s.transferRoot = "", false, ""
+	if changed && s.OnChange != nil {
+		s.OnChange(root)
+	}
+	result := database.SyncCompleted
+	if !completed {
+		result = database.SyncInterrupted
+	}
+	metrics.SyncSessionDuration.Observe(time.Since(s.sessionStart).Seconds(), result)
+	return store.UpdateClientSyncResult(s.PeerId, root, result)
+}
+
+// planSync Compare the manifest of the client with the entries of the server, and decide the actions
+// An entry modified on only one side is taken by the other side. The changes of the client are applied
+// to the server right away, except files, which the client is asked to upload.
+// An entry modified on both sides is a conflict, unless both sides have the same content,
+// or one side deleted it, in which case the modification wins over the deletion.
+// The last sync status with the client is recorded for the entries the client is going to take,
+// except files to download, which are recorded when they are sent
+func planSync(stream *Stream, store *database.Store, root string,
+	manifest []*pb.SyncatEntry) ([]*pb.SyncatAction, error) {
+	entries, err := store.QueryEntriesByPrefix(root, "")
+	if err != nil {
+		return nil, err
+	}
+	server := make(map[string]database.Entry, len(entries))
+	for _, e := range entries {
+		server[e.Path] = e
+	}
+	var actions []*pb.SyncatAction
+	var synced []database.Entry
+	// respond Ask the client to take the entry of the server
+	respond := func(s database.Entry) {
+		kind := serverAction(s)
+		actions = append(actions, &pb.SyncatAction{Kind: kind, Entry: entryToPb(s)})
+		if kind != pb.SyncatAction_DOWNLOAD {
+			synced = append(synced, s)
+		}
+	}
+	// accept Tell the client that the server has taken its entry
+	accept := func(e database.Entry) {
+		actions = append(actions, &pb.SyncatAction{Kind: pb.SyncatAction_ACCEPT, Entry: entryToPb(e)})
+		synced = append(synced, e)
+	}
+	known := make(map[string]bool, len(manifest))
+	var deletions []*pb.SyncatEntry
+	for _, c := range manifest {
+		local, err := stream.resolvePath(root, c.Path)
+		if err != nil {
+			return nil, err
+		}
+		path := cleanPath(c.Path)
+		known[path] = true
+		s, exists := server[path]
+		if !c.Modified && exists && s.Uuid == c.BaseUuid {
+			continue
+		}
+		if sameContent(c, s, exists) {
+			if exists {
+				accept(s)
+			} else {
+				// deleted on the client, and never seen by the server
+				actions = append(actions, &pb.SyncatAction{Kind: pb.SyncatAction_ACCEPT, Entry: c})
+			}
+			continue
+		}
+		serverChanged := exists && s.Uuid != c.BaseUuid
+		switch {
+		case serverChanged && (!c.Modified || c.Deleted):
+			respond(s)
+		case serverChanged && !s.Deleted:
+			actions = append(actions, &pb.SyncatAction{Kind: pb.SyncatAction_CONFLICT, Entry: entryToPb(s)})
+			metrics.Conflicts.Inc()
+			if s.IsDir {
+				synced = append(synced, s)
+			}
+		case c.Deleted:
+			// deleted after the other changes, so that directories are emptied first
+			deletions = append(deletions, c)
+		case c.IsDir:
+			if exists && !s.Deleted && !s.IsDir {
+				err = os.Remove(local)
+				if err != nil && !os.IsNotExist(err) {
+					return nil, err
+				}
+			}
+			err = os.MkdirAll(local, os.ModePerm)
+			if err != nil {
+				return nil, err
+			}
+			e := entryFromPb(root, c)
+			e.Id, err = store.UpsertEntry(e)
+			if err != nil {
+				return nil, err
+			}
+			stream.changed = true
+			accept(e)
+		default:
+			actions = append(actions, &pb.SyncatAction{Kind: pb.SyncatAction_UPLOAD, Entry: c})
+		}
+	}
+	for _, s := range entries {
+		if !known[s.Path] && !s.Deleted {
+			respond(s)
+		}
+	}
+	sort.Slice(deletions, func(i, j int) bool {
+		return deletions[i].Path > deletions[j].Path
+	})
+	for _, c := range deletions {
+		local, err := stream.resolvePath(root, c.Path)
+		if err != nil {
+			return nil, err
+		}
+		s, exists := server[cleanPath(c.Path)]
+		err = os.Remove(local)
+		if err != nil && !os.IsNotExist(err) {
+			// the directory still has files the client has not seen, keep it
+			if exists && !s.Deleted {
+				respond(s)
+			}
+			continue
+		}
+		e := entryFromPb(root, c)
+		e.Id, err = store.UpsertEntry(e)
+		if err != nil {
+			return nil, err
+		}
+		stream.changed = true
+		accept(e)
+	}
+	// parent directories are created before their children
+	sort.SliceStable(actions, func(i, j int) bool {
+		return actions[i].Entry.Path < actions[j].Entry.Path
+	})
+	return actions, store.InsertLastSyncs(stream.PeerId, synced)
+}
+
+// serveGet Send the file requested by the client with the version recorded by the server,
+// and record it as the last sync status with the client
+func serveGet(stream *Stream, store *database.Store, body *pb.SyncatGetRequestBody) error {
+	e, exists, err := store.QueryEntry(body.Root, cleanPath(body.Path))
+	if err != nil {
+		return err
+	}
+	if !exists || e.Deleted || e.IsDir {
+		return ErrInvalidPath{body.Path}
+	}
+	sent, err := SendFile(stream, store, body.Root, body.Path, e.Uuid)
+	if err != nil {
+		return err
+	}
+	sent.Id = e.Id
+	return store.UpsertLastSync(stream.PeerId, sent)
+}
+
+// buildManifest Build the manifest of the sync root sent to the server
+// Every entry carries the version last synced with the server as its base, and whether it is modified since then
+// Deleted entries never synced with the server are left out
+func buildManifest(store *database.Store, root string) ([]*pb.SyncatEntry, error) {
+	entries, err := store.QueryEntriesByPrefix(root, "")
+	if err != nil {
+		return nil, err
+	}
+	synced, err := store.QueryLastSyncByPrefix(0, root, "")
+	if err != nil {
+		return nil, err
+	}
+	base := make(map[string]string, len(synced))
+	for _, e := range synced {
+		base[e.Path] = e.Uuid
+	}
+	manifest := make([]*pb.SyncatEntry, 0, len(entries))
+	for _, e := range entries {
+		baseUuid, ok := base[e.Path]
+		if e.Deleted && !ok {
+			continue
+		}
+		c := entryToPb(e)
+		c.BaseUuid = baseUuid
+		c.Modified = !ok || baseUuid != e.Uuid
+		manifest = append(manifest, c)
+	}
+	return manifest, nil
+}
+
+// RunSyncSession Run a sync session of the sync root with the server, on a new stream of the connection
+// The sync root is scanned, and its manifest is sent to the server, which replies the actions to take
+// ErrRootBusy is returned if the sync root is in the session of another client
+func RunSyncSession(conn *IdleTimeoutConn, store *database.Store, root string) (SyncStats, error) {
+	var stats SyncStats
+	dir, ok := conn.sharedConfig().GetSyncDirectory(root)
+	if !ok {
+		return stats, ErrUnknownRoot{root}
+	}
+	stream, err := conn.OpenStream()
+	if err != nil {
+		return stats, err
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+	stream.transferRoot = root
+	_, err = scanner.Scan(store, root, dir, stream.HashAlgorithm)
+	if err != nil {
+		return stats, err
+	}
+	manifest, err := buildManifest(store, root)
+	if err != nil {
+		return stats, err
+	}
+	err = NewSyncatSyncRequest(root, manifest).Send(stream)
+	if err != nil {
+		return stats, err
+	}
+	req, err := Wait(stream, []PacketType{META})
+	if err != nil {
+		return stats, err
+	}
+	err = req.Handle(stream, store)
+	if err != nil {
+		return stats, err
+	}
+	meta := req.(*SyncatMetaRequest)
+	if meta.Busy {
+		return stats, ErrRootBusy{root}
+	}
+	var deletions []*pb.SyncatEntry
+	for _, a := range meta.Actions {
+		local, err := stream.resolvePath(root, a.Entry.Path)
+		if err != nil {
+			return stats, err
+		}
+		switch a.Kind {
+		case pb.SyncatAction_ACCEPT:
+			err = acceptEntry(store, root, a.Entry)
+		case pb.SyncatAction_UPLOAD:
+			var sent database.Entry
+			sent, err = SendFile(stream, store, root, a.Entry.Path, a.Entry.Uuid)
+			if err == nil {
+				stats.Uploaded++
+				err = recordSynced(store, sent)
+			}
+		case pb.SyncatAction_DOWNLOAD:
+			var changed bool
+			changed, err = changedSinceScan(store, root, a.Entry.Path, local)
+			if err == nil && !changed {
+				err = downloadFile(stream, store, root, a.Entry)
+				if err == nil {
+					stats.Downloaded++
+				}
+			}
+		case pb.SyncatAction_MKDIR:
+			var changed bool
+			changed, err = changedSinceScan(store, root, a.Entry.Path, local)
+			if err == nil && !changed {
+				err = makeDir(store, root, local, a.Entry)
+			}
+		case pb.SyncatAction_DELETE:
+			deletions = append(deletions, a.Entry)
+		case pb.SyncatAction_CONFLICT:
+			err = resolveConflict(stream, store, root, local, a.Entry)
+			if err == nil {
+				stats.Conflicts++
+			}
+		}
+		if err != nil {
+			return stats, err
+		}
+	}
+	// children are deleted before their parent directories
+	sort.Slice(deletions, func(i, j int) bool {
+		return deletions[i].Path > deletions[j].Path
+	})
+	for _, e := range deletions {
+		deleted, err := deleteLocal(stream, store, root, e)
+		if err != nil {
+			return stats, err
+		}
+		if deleted {
+			stats.Deleted++
+		}
+	}
+	stats.RawBytes, stats.WireBytes = stream.rawBytes, stream.wireBytes
+	err = NewSyncatAckRequest().Send(stream)
+	if err != nil {
+		return stats, err
+	}
+	// the server closes the stream once the session is ended, so that the next session finds the sync root released
+	return stats, stream.waitClosed()
+}
+
+// recordSynced Record the entry in entries, and as the last sync status with the server
+func recordSynced(store *database.Store, e database.Entry) error {
+	var err error
+	e.Id, err = store.UpsertEntry(e)
+	if err != nil {
+		return err
+	}
+	return store.UpsertLastSync(0, e)
+}
+
+// acceptEntry Record the local entry as synced with the version taken by the server
+func acceptEntry(store *database.Store, root string, accepted *pb.SyncatEntry) error {
+	e, exists, err := store.QueryEntry(root, cleanPath(accepted.Path))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		e = entryFromPb(root, accepted)
+	}
+	e.Uuid = accepted.Uuid
+	return recordSynced(store, e)
+}
+
+// changedSinceScan Check whether the local file is changed after the sync root is scanned
+// Changes made during the session must not be overwritten, they are synced by the next session instead
+func changedSinceScan(store *database.Store, root string, path string, local string) (bool, error) {
+	e, exists, err := store.QueryEntry(root, cleanPath(path))
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(local)
+	if os.IsNotExist(err) {
+		return exists && !e.Deleted, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !exists || e.Deleted || e.IsDir != info.IsDir() {
+		return true, nil
+	}
+	return !e.IsDir && (e.Size != uint64(info.Size()) || !e.Timestamp.Equal(info.ModTime())), nil
+}
+
+// downloadFile Request the file from the server and receive it
+// The received file is recorded in entries and last_sync when it is moved to its destination
+func downloadFile(stream *Stream, store *database.Store, root string, e *pb.SyncatEntry) error {
+	err := NewSyncatGetRequest(root, e.Path).Send(stream)
+	if err != nil {
+		return err
+	}
+	return ReceiveFile(stream, store)
+}
+
+// makeDir Create the directory taken from the server, replacing the file at its path
+func makeDir(store *database.Store, root string, local string, e *pb.SyncatEntry) error {
+	info, err := os.Stat(local)
+	if err == nil && !info.IsDir() {
+		err = os.Remove(local)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.MkdirAll(local, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return recordSynced(store, entryFromPb(root, e))
+}
+
+// deleteLocal Delete the file or directory deleted by the server
+// Nothing is deleted if it is changed after the scan, or if it is a directory with files unknown to the server
+func deleteLocal(stream *Stream, store *database.Store, root string, e *pb.SyncatEntry) (bool, error) {
+	local, err := stream.resolvePath(root, e.Path)
+	if err != nil {
+		return false, err
+	}
+	changed, err := changedSinceScan(store, root, e.Path, local)
+	if err != nil || changed {
+		return false, err
+	}
+	err = os.Remove(local)
+	if err != nil && !os.IsNotExist(err) {
+		return false, nil
+	}
+	return true, recordSynced(store, entryFromPb(root, e))
+}
+
+// resolveConflict Keep the local copy of the conflicting entry beside it, and take the version of the server
+// The local copy is synced to the server as a new file by the next session
+func resolveConflict(stream *Stream, store *database.Store, root string, local string,
+	e *pb.SyncatEntry) error {
+	path := cleanPath(e.Path)
+	conflictPath := conflictName(path, time.Now())
+	_, err := os.Stat(local)
+	if err == nil {
+		localConflict, err := stream.resolvePath(root, conflictPath)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(local, localConflict)
+		if err != nil {
+			return err
+		}
+		err = store.InsertConflict(root, path, conflictPath, e.Uuid)
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if e.IsDir {
+		return makeDir(store, root, local, e)
+	}
+	return downloadFile(stream, store, root, e)
+}
+
+// conflictName Get the path the local copy of a conflicting file is moved to
+// e.g. docs/report.txt is moved to docs/report.conflict-20060102-150405.txt
+func conflictName(path string, t time.Time) string {
+	dir, name := filepath.Split(filepath.FromSlash(path))
+	ext := filepath.Ext(name)
+	if ext == name {
+		// dot files have no extension
+		ext = ""
+	}
+	name = strings.TrimSuffix(name, ext) + ".conflict-" + t.Format("20060102-150405") + ext
+	return filepath.ToSlash(filepath.Join(dir, name))
+}
@@ -0,0 +1,339 @@
+package syncnet
+
+import (
+	"errors"
+	"github.com/JeffersonQin/syncat/pkg/metrics"
+	"sync"
+	"time"
+)
+
+// ControlStream is the id of the stream carrying the packets of the connection itself,
+// which are AUTH, REPLY, PING, PONG, NOTIFY and BYE
+// It is open as long as the connection, and is not flow controlled
+const ControlStream uint32 = 0
+
+// streamWindow is the number of bytes a stream can receive before they are consumed,
+// which bounds the memory buffered for a stream
+const streamWindow = 256 << 10
+
+// maxPacketCost is the most bytes of the window taken by one packet, which is half the window
+// A packet never waits for more than half the window, since up to a quarter of it may be consumed by the peer and not
+// granted back yet, and a larger packet, e.g. SYNC of a large sync root, would wait forever for the whole window
+const maxPacketCost = streamWindow / 2
+
+// packetCost Get the bytes of the window taken by a packet with a body of length bytes
+func packetCost(length int) int {
+	if length > maxPacketCost {
+		return maxPacketCost
+	}
+	return length
+}
+
+// maxStreams is the number of streams a connection can have open at the same time, including the control stream
+const maxStreams = 64
+
+// Stream is a sequence of packets multiplexed with other streams over a connection
+// Every sync session runs on its own stream, so that a large file does not hold back the packets of other streams
+// The peer can send at most streamWindow bytes not consumed yet on a stream, and waits for WINDOW beyond that
+type Stream struct {
+	*IdleTimeoutConn
+	// Id is the id of the stream, odd if opened by the dialing side, and even if opened by the accepting side
+	Id uint32
+	// mu guards the states of flow control below, and cond is broadcast when they change
+	mu   sync.Mutex
+	cond *sync.Cond
+	// inbound are the packets received on the stream and not consumed yet
+	inbound []SyncatRequest
+	// buffered is the number of bytes of the window taken by inbound
+	buffered int
+	// consumed is the number of bytes consumed and not granted back to the peer yet
+	consumed int
+	// sendWindow is the number of bytes allowed to send before the peer grants more
+	sendWindow int
+	// closedByPeer and closedLocally indicate whether the stream is closed by the peer or by this side
+	closedByPeer  bool
+	closedLocally bool
+	// err is the error stopping the connection, nil while the connection is read
+	err error
+	// SyncRoot is the sync root locked by the sync session on the stream, empty if not in a session
+	// It is only used by the server
+	SyncRoot string
+	// sessionStart is the time the sync session on the stream started, recorded for the metrics
+	sessionStart time.Time
+	// changed indicates whether the entries of SyncRoot are changed during the sync session
+	changed bool
+	// transferRoot is the sync root whose bandwidth limit applies to the stream, empty if not in a sync session
+	transferRoot string
+	// rawBytes and wireBytes are the bytes of file chunks transferred before and after compression
+	rawBytes  int64
+	wireBytes int64
+}
+
+// newStream Create a stream of the connection with the full window
+func newStream(conn *IdleTimeoutConn, id uint32) *Stream {
+	s := &Stream{
+		IdleTimeoutConn: conn,
+		Id:              id,
+		sendWindow:      streamWindow,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// StartStreams Start reading the connection in the background, and dispatching the packets to their streams
+// dialed indicates whether the connection is dialed by this side, which decides the ids of the streams it opens
+// It must be called once before any packet is sent or waited for on the connection
+func (c *IdleTimeoutConn) StartStreams(dialed bool) {
+	c.streams = make(map[uint32]*Stream)
+	c.accepted = make(chan *Stream, maxStreams)
+	c.notifyCh = make(chan struct{}, 1)
+	c.nextStream = 2
+	if dialed {
+		c.nextStream = 1
+	}
+	c.control = newStream(c, ControlStream)
+	c.streams[ControlStream] = c.control
+	go c.readStreams()
+}
+
+// Control Get the control stream of the connection
+func (c *IdleTimeoutConn) Control() *Stream {
+	return c.control
+}
+
+// OpenStream Open a new stream to the peer, which sees the stream once a packet is sent on it
+func (c *IdleTimeoutConn) OpenStream() (*Stream, error) {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	if c.streamErr != nil {
+		return nil, c.streamErr
+	}
+	if len(c.streams) >= maxStreams {
+		return nil, ErrTooManyStreams{maxStreams}
+	}
+	s := newStream(c, c.nextStream)
+	c.nextStream += 2
+	c.streams[s.Id] = s
+	return s, nil
+}
+
+// AcceptStream Wait for the next stream opened by the peer
+// The error stopping the connection is returned once the connection is closed
+func (c *IdleTimeoutConn) AcceptStream() (*Stream, error) {
+	s, ok := <-c.accepted
+	if !ok {
+		c.streamsMu.Lock()
+		defer c.streamsMu.Unlock()
+		return nil, c.streamErr
+	}
+	return s, nil
+}
+
+// readStreams Read the packets of the connection and dispatch them to their streams, until an error occurs
+// The open streams are then failed with the error, and the connection is closed
+func (c *IdleTimeoutConn) readStreams() {
+	var err error
+	for err == nil {
+		var req SyncatRequest
+		req, err = RouteConn(c)
+		if err == nil {
+			metrics.Packets.Inc(metrics.Received, req.GetType().String())
+			err = c.dispatch(req)
+		}
+	}
+	c.streamsMu.Lock()
+	c.streamErr = err
+	streams := make([]*Stream, 0, len(c.streams))
+	for _, s := range c.streams {
+		streams = append(streams, s)
+	}
+	c.streamsMu.Unlock()
+	close(c.accepted)
+	for _, s := range streams {
+		s.fail(err)
+	}
+	_ = c.Close()
+}
+
+// dispatch Deliver the packet received to its stream
+// WINDOW and CLOSE are handled right away, and so is NOTIFY, so that changes are noticed while other streams are busy
+// Packets of the streams already closed are dropped
+func (c *IdleTimeoutConn) dispatch(req SyncatRequest) error {
+	opens := req.GetType() != WINDOW && req.GetType() != CLOSE
+	s, err := c.stream(req.GetStream(), opens)
+	if err != nil || s == nil {
+		return err
+	}
+	switch req.GetType() {
+	case WINDOW, NOTIFY:
+		return req.Handle(s, nil)
+	case CLOSE:
+		s.closeByPeer()
+		return nil
+	}
+	return s.push(req)
+}
+
+// stream Get the open stream of the id, nil if it is already closed
+// If opens is true and the id is new to the streams of the peer, the stream is opened and queued for AcceptStream
+func (c *IdleTimeoutConn) stream(id uint32, opens bool) (*Stream, error) {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	if s, ok := c.streams[id]; ok {
+		return s, nil
+	}
+	// the ids of the streams opened by this side have the parity of nextStream
+	if !opens || id%2 == c.nextStream%2 || id <= c.lastAccepted {
+		return nil, nil
+	}
+	// streams are only removed when closed locally, after being accepted, so that accepted never blocks
+	if len(c.streams) >= maxStreams {
+		return nil, ErrTooManyStreams{maxStreams}
+	}
+	s := newStream(c, id)
+	c.streams[id] = s
+	c.lastAccepted = id
+	c.accepted <- s
+	return s, nil
+}
+
+// push Queue the packet received on the stream for Wait
+// ErrFlowControl is returned if the peer sends beyond the window of the stream
+func (s *Stream) push(req SyncatRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closedLocally {
+		return nil
+	}
+	cost := packetCost(int(req.GetLength()))
+	if s.Id != ControlStream && s.buffered+cost > streamWindow {
+		return ErrFlowControl{s.Id}
+	}
+	s.inbound = append(s.inbound, req)
+	s.buffered += cost
+	s.cond.Broadcast()
+	return nil
+}
+
+// next Wait for the next packet received on the stream
+// The packet is charged to the bandwidth limit of the transfer, and the bytes consumed are granted back to the peer
+// by WINDOW once they reach a quarter of the window, so that a slow consumer holds back the peer
+// ErrStreamClosed is returned once the stream is closed and all its packets are consumed
+func (s *Stream) next() (SyncatRequest, error) {
+	s.mu.Lock()
+	for len(s.inbound) == 0 && !s.closedByPeer && !s.closedLocally && s.err == nil {
+		s.cond.Wait()
+	}
+	if len(s.inbound) == 0 {
+		err := s.err
+		if err == nil {
+			err = ErrStreamClosed{s.Id}
+		}
+		s.mu.Unlock()
+		return nil, err
+	}
+	req := s.inbound[0]
+	s.inbound[0] = nil
+	s.inbound = s.inbound[1:]
+	n := int(req.GetLength())
+	s.buffered -= packetCost(n)
+	increment := 0
+	if s.Id != ControlStream {
+		s.consumed += packetCost(n)
+		if s.consumed >= streamWindow/4 {
+			increment, s.consumed = s.consumed, 0
+		}
+	}
+	s.mu.Unlock()
+	for left := n; left > 0; {
+		left -= s.Bandwidth.wait(s.transferRoot, false, left)
+	}
+	if increment > 0 {
+		err := NewSyncatWindowRequest(uint64(increment)).Send(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// reserve Wait until the window of the stream allows sending a packet with a body of n bytes, and take its cost
+// from the window, so that the peer never buffers more than the window
+func (s *Stream) reserve(n int) error {
+	if s.Id == ControlStream {
+		return nil
+	}
+	cost := packetCost(n)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.sendWindow < cost && !s.closedByPeer && !s.closedLocally && s.err == nil {
+		s.cond.Wait()
+	}
+	if s.err != nil {
+		return s.err
+	}
+	if s.closedByPeer || s.closedLocally {
+		return ErrStreamClosed{s.Id}
+	}
+	s.sendWindow -= cost
+	return nil
+}
+
+// grant Allow sending more bytes on the stream, as granted by WINDOW of the peer
+func (s *Stream) grant(increment uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sendWindow += int(increment)
+	s.cond.Broadcast()
+}
+
+// closeByPeer Mark the stream closed by CLOSE of the peer
+// The packets received before are still consumed by Wait
+func (s *Stream) closeByPeer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closedByPeer = true
+	s.cond.Broadcast()
+}
+
+// fail Stop the stream with the error stopping the connection
+func (s *Stream) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+	s.cond.Broadcast()
+}
+
+// Close Close the stream, and tell the peer by CLOSE unless the peer has closed it already
+// The control stream is closed along with the connection instead
+func (s *Stream) Close() error {
+	if s.Id == ControlStream {
+		return nil
+	}
+	s.mu.Lock()
+	send := !s.closedByPeer && !s.closedLocally && s.err == nil
+	s.closedLocally = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	s.streamsMu.Lock()
+	delete(s.streams, s.Id)
+	s.streamsMu.Unlock()
+	if !send {
+		return nil
+	}
+	return NewSyncatCloseRequest().Send(s)
+}
+
+// waitClosed Wait for the peer to close the stream
+// ErrUnexpectedPacketType is returned if the peer sends another packet instead
+func (s *Stream) waitClosed() error {
+	req, err := s.next()
+	var closed ErrStreamClosed
+	if errors.As(err, &closed) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return ErrUnexpectedPacketType{byte(req.GetType())}
+}
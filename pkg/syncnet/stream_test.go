@@ -0,0 +1,60 @@
+package syncnet
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// windowPacket Get a request received on the stream with a body of length bytes
+func windowPacket(length int) SyncatRequest {
+	return &SyncatAckRequest{SyncatRequestHeader{PacketType: ACK, StreamId: 1, Length: uint64(length)}}
+}
+
+// TestPushRefusesBeyondWindow The packets received on a stream are refused once they would exceed the window,
+// and a packet larger than the window takes only its cost
+func TestPushRefusesBeyondWindow(t *testing.T) {
+	s := newStream(&IdleTimeoutConn{}, 1)
+	for _, length := range []int{streamWindow / 4, streamWindow / 4, MaxPacketLength} {
+		err := s.push(windowPacket(length))
+		if err != nil {
+			t.Fatalf("push of %d bytes within the window: %v", length, err)
+		}
+	}
+	var flow ErrFlowControl
+	if err := s.push(windowPacket(1)); !errors.As(err, &flow) {
+		t.Fatalf("push beyond the window = %v, want ErrFlowControl", err)
+	}
+}
+
+// TestReserveWaitsForWindow Sending waits until the window allows the whole cost of the packet
+func TestReserveWaitsForWindow(t *testing.T) {
+	s := newStream(&IdleTimeoutConn{}, 1)
+	for _, n := range []int{maxPacketCost, maxPacketCost - 10} {
+		err := s.reserve(n)
+		if err != nil {
+			t.Fatalf("failed to reserve %d bytes of the window: %v", n, err)
+		}
+	}
+	reserved := make(chan error, 1)
+	go func() {
+		reserved <- s.reserve(MaxPacketLength)
+	}()
+	select {
+	case err := <-reserved:
+		t.Fatalf("reserve beyond the window = %v, want it to wait", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	s.grant(maxPacketCost - 10)
+	select {
+	case err := <-reserved:
+		if err != nil {
+			t.Fatalf("reserve after the grant: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("reserve still waits after the window is granted")
+	}
+	if s.sendWindow != 0 {
+		t.Fatalf("send window = %d, want 0", s.sendWindow)
+	}
+}
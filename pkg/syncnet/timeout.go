@@ -1,34 +1,158 @@
 package syncnet
 
 import (
+	"github.com/JeffersonQin/syncat/pkg/compression"
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"github.com/JeffersonQin/syncat/pkg/hashing"
+	"github.com/JeffersonQin/syncat/pkg/metrics"
 	"net"
+	"sync"
 	"time"
 )
 
 // IdleTimeoutConn is the connection with idle timeout
+// It runs over any transport implementing net.Conn with deadlines, e.g. TCP, Unix sockets, or net.Pipe
 type IdleTimeoutConn struct {
-	// TCPConn is the underlying TCP connection
-	*net.TCPConn
-	// IdleTimeout is the timeout for idle connection
+	// Conn is the underlying connection
+	net.Conn
+	// IdleTimeout is the timeout for idle connection, protocol.timeout of the shared configuration if zero,
+	// which follows the reloads of the configuration
 	IdleTimeout time.Duration
+	// Config holds the shared configuration of this side of the connection, the loaded configuration if nil
+	// It is given when several sides run in one process, e.g. in tests, or when it is reloaded by a server
+	Config *config.Holder
+	// ReadLimiter and WriteLimiter cap the bandwidth of the connection, unlimited if nil
+	ReadLimiter  *RateLimiter
+	WriteLimiter *RateLimiter
+	// Bandwidth limits the transfers of the sync sessions together with the other connections of this side,
+	// unlimited if nil
+	Bandwidth *Bandwidth
+	// PeerId is the id of the client in clients table, assigned by the server after authentication
+	// It is used by the server to record the last sync status with the client
+	// Clients only sync with the server, and leave it unassigned
+	PeerId int64
+	// PeerUuid is the uuid of the client, assigned by the server after authentication
+	PeerUuid string
+	// PeerName is the name of the client shown in logs, assigned by the server after authentication
+	PeerName string
+	// SessionId identifies the connection of the client in logs, assigned by the server after authentication
+	SessionId string
+	// HashAlgorithm is the content hash algorithm negotiated during authentication
+	HashAlgorithm hashing.Algorithm
+	// Compression is the compression algorithm of file chunks negotiated during authentication
+	Compression compression.Algorithm
+	// OnChange is called by the server when the sync session of the client has changed the entries of the sync root
+	OnChange func(root string)
+	// notifyMu guards notified, which is updated by the goroutine reading the connection
+	notifyMu sync.Mutex
+	// notified are the sync roots changed on the server by other clients, pushed by NOTIFY requests
+	notified map[string]bool
+	// notifyCh is signalled when a sync root is notified
+	notifyCh chan struct{}
+	// control is the control stream of the connection
+	control *Stream
+	// streamsMu guards the states of the streams below
+	streamsMu sync.Mutex
+	// streams are the open streams by id, including the control stream
+	streams map[uint32]*Stream
+	// nextStream is the id of the next stream opened by this side
+	nextStream uint32
+	// lastAccepted is the largest id of the streams opened by the peer
+	lastAccepted uint32
+	// accepted are the streams opened by the peer, waiting for AcceptStream
+	accepted chan *Stream
+	// streamErr is the error stopping the connection from being read, nil while it is read
+	streamErr error
+	// writeMu guards writing packets, since the packets of the streams are sent by different goroutines
+	writeMu sync.Mutex
+}
+
+// sharedConfig Get the shared configuration of this side of the connection
+func (c *IdleTimeoutConn) sharedConfig() *config.SyncatConfig {
+	loaded := config.GetConfig()
+	if c.Config != nil {
+		loaded = c.Config.Get()
+	}
+	return &loaded
+}
+
+// idleTimeout Get the timeout for idle connection, which is read for every operation to follow the reloads
+func (c *IdleTimeoutConn) idleTimeout() time.Duration {
+	if c.IdleTimeout != 0 {
+		return c.IdleTimeout
+	}
+	loaded := config.GetConfig()
+	if c.Config != nil {
+		loaded = c.Config.Get()
+	}
+	return time.Duration(loaded.Protocol.Timeout) * time.Second
 }
 
 // Read reads data from the connection
 // The timeout is set for each read operation
+// The bytes read are charged to ReadLimiter, delaying the next read once the limit is exceeded
+// At most one second of transfer of ReadLimiter is read at once, so that the delay never outlasts the peer's timeout
 func (c *IdleTimeoutConn) Read(b []byte) (int, error) {
-	err := c.TCPConn.SetReadDeadline(time.Now().Add(c.IdleTimeout))
-	if err != nil {
-		return 0, err
-	}
-	return c.TCPConn.Read(b)
+	b = b[:c.ReadLimiter.slice(len(b))]
+	// some transports refuse deadlines once the peer has closed, e.g. net.Pipe,
+	// in which case the read still tells the bytes left and how the connection ended
+	_ = c.Conn.SetReadDeadline(time.Now().Add(c.idleTimeout()))
+	n, err := c.Conn.Read(b)
+	metrics.Bytes.Add(float64(n), metrics.Received)
+	c.ReadLimiter.Wait(n)
+	return n, err
 }
 
 // Write writes data to the connection
-// The timeout is set for each write operation
+// The timeout is set for each write operation, after waiting for WriteLimiter
+// The data is written in slices of at most one second of transfer of WriteLimiter, so that the peer keeps receiving
+// while a large packet is held back by the limit
 func (c *IdleTimeoutConn) Write(b []byte) (int, error) {
-	err := c.TCPConn.SetWriteDeadline(time.Now().Add(c.IdleTimeout))
-	if err != nil {
-		return 0, err
+	written := 0
+	for written < len(b) {
+		n := c.WriteLimiter.slice(len(b) - written)
+		c.WriteLimiter.Wait(n)
+		err := c.Conn.SetWriteDeadline(time.Now().Add(c.idleTimeout()))
+		if err != nil {
+			return written, err
+		}
+		n, err = c.Conn.Write(b[written : written+n])
+		metrics.Bytes.Add(float64(n), metrics.Sent)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Notified Get the channel signalled when sync roots are pushed by NOTIFY requests, to be taken by TakeNotifiedRoots
+func (c *IdleTimeoutConn) Notified() <-chan struct{} {
+	return c.notifyCh
+}
+
+// notify Record the sync root pushed by NOTIFY request, and signal Notified
+func (c *IdleTimeoutConn) notify(root string) {
+	c.notifyMu.Lock()
+	if c.notified == nil {
+		c.notified = make(map[string]bool)
+	}
+	c.notified[root] = true
+	c.notifyMu.Unlock()
+	select {
+	case c.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// TakeNotifiedRoots Get the sync roots pushed by NOTIFY requests since the last call
+func (c *IdleTimeoutConn) TakeNotifiedRoots() []string {
+	c.notifyMu.Lock()
+	defer c.notifyMu.Unlock()
+	roots := make([]string, 0, len(c.notified))
+	for root := range c.notified {
+		roots = append(roots, root)
 	}
-	return c.TCPConn.Write(b)
+	c.notified = nil
+	return roots
 }
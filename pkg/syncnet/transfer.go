@@ -0,0 +1,536 @@
+package syncnet
+
+import (
+	"fmt"
+	"github.com/JeffersonQin/syncat/pkg/compression"
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"github.com/JeffersonQin/syncat/pkg/database"
+	"github.com/JeffersonQin/syncat/pkg/hashing"
+	pb "github.com/JeffersonQin/syncat/pkg/proto"
+	"github.com/JeffersonQin/syncat/pkg/scanner"
+	"github.com/google/uuid"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxChunkRetries is the number of times a chunk can be rejected by the receiver before the transfer fails
+const maxChunkRetries = 3
+
+// maxIncompressibleChunks is the number of incompressible chunks in a row before a file is sent uncompressed
+const maxIncompressibleChunks = 3
+
+// NewTransferId Generate the id of a file transfer
+// The id is derived from the identity and the content of the file,
+// so that the same transfer gets the same id after reconnecting
+func NewTransferId(root string, path string, hash string, size uint64) string {
+	name := fmt.Sprintf("%s/%s:%s:%d", root, path, hash, size)
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(name)).String()
+}
+
+// SendFile Send a file in the sync root to the peer, and return the entry of the sent file
+// The peer replies every FILE request with the verified offset by RESUME request,
+// and the transfer continues from there, so that an interrupted transfer is resumed instead of restarted
+// The file is hashed with the algorithm negotiated for the connection, unless the hash recorded by the scan is current
+// The peer records the file with the version, or with the id of the transfer if the version is empty
+// Chunks are compressed with the algorithm negotiated for the connection, unless the file type is compressed already
+// or the chunks turn out incompressible
+func SendFile(stream *Stream, store *database.Store, root string, path string,
+	version string) (database.Entry, error) {
+	localPath, err := stream.resolvePath(root, path)
+	if err != nil {
+		return database.Entry{}, err
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		return database.Entry{}, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return database.Entry{}, err
+	}
+	size := uint64(info.Size())
+	algorithm, err := hashing.Parse(string(stream.HashAlgorithm))
+	if err != nil {
+		return database.Entry{}, err
+	}
+	hash, err := fileHash(store, root, cleanPath(path), f, info, algorithm)
+	if err != nil {
+		return database.Entry{}, err
+	}
+	transferId := NewTransferId(root, path, hash, size)
+	entry := database.Entry{
+		Root:      root,
+		Path:      cleanPath(path),
+		Hash:      hash,
+		HashAlgo:  string(algorithm),
+		Timestamp: info.ModTime(),
+		Size:      size,
+		Uuid:      entryVersion(version, transferId),
+	}
+	// probe for the verified offset of the transfer
+	req, err := NewSyncatFileRequest(transferId, root, path, size, hash, algorithm, info.ModTime(), 0, nil)
+	if err != nil {
+		return database.Entry{}, err
+	}
+	req.Version = version
+	err = req.Send(stream)
+	if err != nil {
+		return database.Entry{}, err
+	}
+	buf := make([]byte, chunkSize(stream.sharedConfig().Protocol))
+	sent, lastOffset, retries := false, uint64(0), 0
+	compress, incompressible := stream.Compression != compression.None && compression.Compressible(path), 0
+	for {
+		resp, err := Wait(stream, []PacketType{RESUME})
+		if err != nil {
+			return database.Entry{}, err
+		}
+		err = resp.Handle(stream, store)
+		if err != nil {
+			return database.Entry{}, err
+		}
+		offset := resp.(*SyncatResumeRequest).Offset
+		if offset == size {
+			return entry, nil
+		}
+		if offset > size {
+			return database.Entry{}, ErrTransferCorrupted{transferId}
+		}
+		// the receiver rejected the last chunk, or restarted the transfer
+		if sent && offset <= lastOffset {
+			retries++
+			if retries > maxChunkRetries {
+				return database.Entry{}, ErrTransferCorrupted{transferId}
+			}
+		} else {
+			retries = 0
+		}
+		lastOffset = offset
+		n, err := f.ReadAt(buf, int64(offset))
+		if err != nil && err != io.EOF {
+			return database.Entry{}, err
+		}
+		if n == 0 {
+			// the file is truncated during the transfer
+			return database.Entry{}, ErrTransferCorrupted{transferId}
+		}
+		req, err = NewSyncatFileRequest(transferId, root, path, size, hash, algorithm, info.ModTime(), offset, buf[:n])
+		if err != nil {
+			return database.Entry{}, err
+		}
+		req.Version = version
+		if compress {
+			ok, err := compressChunk(stream.Compression, &req.SyncatFileRequestBody)
+			if err != nil {
+				return database.Entry{}, err
+			}
+			if ok {
+				incompressible = 0
+			} else if incompressible++; incompressible >= maxIncompressibleChunks {
+				compress = false
+			}
+		}
+		stream.rawBytes += int64(n)
+		stream.wireBytes += int64(len(req.Data))
+		err = req.Send(stream)
+		if err != nil {
+			return database.Entry{}, err
+		}
+		sent = true
+	}
+}
+
+// compressChunk Compress the chunk carried by the FILE request, and return whether it is worth compressing
+// The chunk is left uncompressed if compression saves less than 1/16 of its size
+func compressChunk(algorithm compression.Algorithm, body *pb.SyncatFileRequestBody) (bool, error) {
+	compressed, err := algorithm.Compress(body.Data)
+	if err != nil {
+		return false, err
+	}
+	if len(compressed) > len(body.Data)-len(body.Data)/16 {
+		return false, nil
+	}
+	body.Data, body.Compression = compressed, string(algorithm)
+	return true, nil
+}
+
+// ReceiveFile Receive a file sent by the peer with SendFile
+// FILE requests are handled until the whole file is received and moved to its destination
+func ReceiveFile(stream *Stream, store *database.Store) error {
+	for {
+		req, err := Wait(stream, []PacketType{FILE})
+		if err != nil {
+			return err
+		}
+		fileReq := req.(*SyncatFileRequest)
+		offset, err := fileReq.receive(stream, store)
+		if err != nil {
+			return err
+		}
+		if offset == fileReq.Size {
+			return nil
+		}
+	}
+}
+
+// stageChunk Write a chunk of file into the staged file and return the verified offset of the transfer
+// A chunk that does not start at the verified offset or fails the verification is discarded,
+// and the sender will send again from the returned offset
+// The id of the transfer names the staged file, and is refused with ErrMalformedPacket unless it is derived from the
+// file by NewTransferId, so that the peer cannot make the staged file escape the sync root
+func stageChunk(stream *Stream, store *database.Store, body *pb.SyncatFileRequestBody) (uint64, error) {
+	if body.TransferId != NewTransferId(body.Root, body.Path, body.Hash, body.Size) {
+		return 0, ErrMalformedPacket{FILE, ErrInvalidTransferId{body.TransferId}}
+	}
+	dest, err := stream.resolvePath(body.Root, body.Path)
+	if err != nil {
+		return 0, err
+	}
+	algorithm, err := hashing.Parse(body.HashAlgorithm)
+	if err != nil {
+		return 0, err
+	}
+	staged := stagingPath(dest, body.TransferId)
+	offset, exists, err := store.QueryTransferOffset(body.TransferId)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		err = os.MkdirAll(filepath.Dir(dest), os.ModePerm)
+		if err != nil {
+			return 0, err
+		}
+		f, err := os.Create(staged)
+		if err != nil {
+			return 0, err
+		}
+		err = f.Close()
+		if err != nil {
+			return 0, err
+		}
+		err = store.CreateTransfer(body.TransferId, body.Root, body.Path, body.Hash, string(algorithm), body.Size)
+		if err != nil {
+			return 0, err
+		}
+	} else if info, err := os.Stat(staged); err != nil || uint64(info.Size()) < offset {
+		// the staged file is lost or shorter than recorded, restart the transfer
+		offset = 0
+		err = store.UpdateTransferOffset(body.TransferId, offset)
+		if err != nil {
+			return 0, err
+		}
+	}
+	data, valid := decompressChunk(body)
+	stream.rawBytes += int64(len(data))
+	stream.wireBytes += int64(len(body.Data))
+	body.Data = data
+	chunkHash, err := algorithm.Bytes(body.Data)
+	if err != nil {
+		return 0, err
+	}
+	if valid && len(body.Data) > 0 && body.Offset == offset && chunkHash == body.ChunkHash &&
+		offset+uint64(len(body.Data)) <= body.Size {
+		f, err := os.OpenFile(staged, os.O_WRONLY|os.O_CREATE, 0666)
+		if err != nil {
+			return 0, err
+		}
+		_, err = f.WriteAt(body.Data, int64(offset))
+		if err != nil {
+			_ = f.Close()
+			return 0, err
+		}
+		err = f.Close()
+		if err != nil {
+			return 0, err
+		}
+		offset += uint64(len(body.Data))
+		err = store.UpdateTransferOffset(body.TransferId, offset)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if offset < body.Size {
+		return offset, nil
+	}
+	ok, err := commitStaged(stream, store, body, algorithm, staged, dest)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		// restart the whole transfer
+		return 0, nil
+	}
+	return offset, nil
+}
+
+// decompressChunk Get the chunk carried by the FILE request decompressed
+// false is returned if the chunk cannot be decompressed, or exceeds the rest of the file,
+// in which case it is discarded like a chunk failing the verification
+func decompressChunk(body *pb.SyncatFileRequestBody) ([]byte, bool) {
+	if body.Compression == "" {
+		return body.Data, true
+	}
+	algorithm, err := compression.Parse(body.Compression)
+	if err != nil || body.Offset > body.Size {
+		return nil, false
+	}
+	// a chunk is never larger than maxChunkSize, whatever size the sender claims for the file
+	limit := body.Size - body.Offset
+	if limit > maxChunkSize {
+		limit = maxChunkSize
+	}
+	data, err := algorithm.Decompress(body.Data, int(limit))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// commitStaged Verify the fully staged file and atomically move it to its destination
+// The staged file is flushed to disk, verified by size and hash, and given the modification time of the sender
+// before it is renamed over the destination, so that the destination is never partially written
+// The entry of the file and the last sync status with the peer are updated in the same transaction
+// If the verification fails, the staged file is discarded and false is returned
+func commitStaged(stream *Stream, store *database.Store, body *pb.SyncatFileRequestBody,
+	algorithm hashing.Algorithm, staged string, dest string) (bool, error) {
+	ok, err := verifyStaged(body, algorithm, staged)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		_ = os.Remove(staged)
+		return false, store.DeleteTransfer(body.TransferId)
+	}
+	timestamp := time.Unix(0, body.Timestamp)
+	err = os.Chtimes(staged, timestamp, timestamp)
+	if err != nil {
+		return false, err
+	}
+	entry := database.Entry{
+		Root:      body.Root,
+		Path:      cleanPath(body.Path),
+		Hash:      body.Hash,
+		HashAlgo:  string(algorithm),
+		Timestamp: timestamp,
+		Size:      body.Size,
+		Uuid:      entryVersion(body.Version, body.TransferId),
+	}
+	replaced := replacedPath(dest, body.TransferId)
+	err = store.CommitFileEntry(stream.PeerId, entry, body.TransferId, func() (func(), error) {
+		return placeStaged(staged, dest, replaced)
+	})
+	if err != nil {
+		return false, err
+	}
+	_ = os.Remove(replaced)
+	stream.changed = true
+	return true, nil
+}
+
+// placeStaged Move the staged file into place, keeping the file it replaces aside until the entry is committed
+// The returned function puts the staged file and the replaced file back, for when the entry fails to commit
+func placeStaged(staged string, dest string, replaced string) (func(), error) {
+	err := os.Rename(dest, replaced)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	err = os.Rename(staged, dest)
+	if err != nil {
+		if exists {
+			_ = os.Rename(replaced, dest)
+		}
+		return nil, err
+	}
+	syncDir(filepath.Dir(dest))
+	return func() {
+		_ = os.Rename(dest, staged)
+		if exists {
+			_ = os.Rename(replaced, dest)
+		}
+		syncDir(filepath.Dir(dest))
+	}, nil
+}
+
+// verifyStaged Flush the fully staged file to disk and verify its size and hash
+func verifyStaged(body *pb.SyncatFileRequestBody, algorithm hashing.Algorithm, staged string) (bool, error) {
+	f, err := os.OpenFile(staged, os.O_RDWR, 0666)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	// drop the bytes written beyond the last verified chunk
+	err = f.Truncate(int64(body.Size))
+	if err != nil {
+		return false, err
+	}
+	err = f.Sync()
+	if err != nil {
+		return false, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if uint64(info.Size()) != body.Size {
+		return false, nil
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return false, err
+	}
+	hash, err := algorithm.Reader(f)
+	if err != nil {
+		return false, err
+	}
+	return hash == body.Hash, nil
+}
+
+// fileHash Get the hash of the file to send, as recorded in entries while the file is unchanged since then
+// The file is only read again when it is changed after the scan, or recorded with another algorithm
+func fileHash(store *database.Store, root string, path string, f *os.File, info os.FileInfo,
+	algorithm hashing.Algorithm) (string, error) {
+	e, exists, err := store.QueryEntry(root, path)
+	if err != nil {
+		return "", err
+	}
+	if exists && !e.Deleted && !e.IsDir && e.HashAlgo == string(algorithm) && e.Size == uint64(info.Size()) &&
+		e.Timestamp.Equal(info.ModTime()) {
+		return e.Hash, nil
+	}
+	hash, err := algorithm.Reader(f)
+	if err != nil {
+		return "", err
+	}
+	return hash, rehashEntry(store, root, path, f.Name(), algorithm, hash)
+}
+
+// rehashEntry Lazily replace the hash of an entry recorded with another algorithm
+// The recorded hash is only replaced when the file still has the recorded content,
+// otherwise the entry is left to be updated by the next scan
+func rehashEntry(store *database.Store, root string, path string, localPath string,
+	algorithm hashing.Algorithm, hash string) error {
+	entry, exists, err := store.QueryEntry(root, path)
+	if err != nil || !exists || entry.HashAlgo == string(algorithm) {
+		return err
+	}
+	oldAlgorithm, err := hashing.Parse(entry.HashAlgo)
+	if err != nil {
+		return err
+	}
+	current, err := oldAlgorithm.File(localPath)
+	if err != nil {
+		return err
+	}
+	if current != entry.Hash {
+		return nil
+	}
+	return store.UpdateEntryHash(root, path, entry.Hash, entry.HashAlgo, hash, string(algorithm))
+}
+
+// entryVersion Get the version recorded for a transferred file
+// Files transferred without a version are versioned by the id of the transfer
+func entryVersion(version string, transferId string) string {
+	if version == "" {
+		return transferId
+	}
+	return version
+}
+
+// syncDir Flush the directory to disk, so that a rename inside it is durable
+// This is best effort, since directories cannot be flushed on some platforms
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
+
+// resolvePath Resolve the local path of a file in the sync root of this side
+func (s *Stream) resolvePath(root string, path string) (string, error) {
+	return localPath(s.sharedConfig(), root, path)
+}
+
+// localPath Resolve the local path of a file in the sync root of the shared configuration
+// The path is relative to the sync root with slash as separator, and must not escape the sync root
+func localPath(sharedConfig *config.SyncatConfig, root string, path string) (string, error) {
+	dir, ok := sharedConfig.GetSyncDirectory(root)
+	if !ok {
+		return "", ErrUnknownRoot{root}
+	}
+	local := filepath.Clean(filepath.FromSlash(path))
+	if local == "." || local == ".." || filepath.IsAbs(local) ||
+		strings.HasPrefix(local, ".."+string(filepath.Separator)) {
+		return "", ErrInvalidPath{path}
+	}
+	return filepath.Join(dir, local), nil
+}
+
+// cleanPath Get the canonical form of a path relative to the sync root, as recorded in database
+func cleanPath(path string) string {
+	return filepath.ToSlash(filepath.Clean(filepath.FromSlash(path)))
+}
+
+// stagingPath Get the path of the staged partial file, which is placed beside its destination
+func stagingPath(dest string, transferId string) string {
+	return filepath.Join(filepath.Dir(dest), scanner.TempPrefix+transferId+".part")
+}
+
+// replacedPath Get the path the file replaced by a transfer is kept at, until the transfer is committed
+func replacedPath(dest string, transferId string) string {
+	return filepath.Join(filepath.Dir(dest), scanner.TempPrefix+transferId+".old")
+}
+
+// TransferExpiry is how long a staged transfer is kept without progress before it is abandoned
+// Interrupted transfers are resumed as soon as the peers reconnect, so a transfer left for that long never will be
+const TransferExpiry = 7 * 24 * time.Hour
+
+// PruneTransfers Delete the staged transfers without progress since the time, together with their partial files
+// It is called with TransferExpiry before serving any session, and returns the number of transfers pruned
+func PruneTransfers(store *database.Store, sharedConfig config.SyncatConfig, before time.Time) (int, error) {
+	stale, err := store.QueryStaleTransfers(before)
+	if err != nil {
+		return 0, err
+	}
+	for i, t := range stale {
+		// the record is dropped anyway when its sync root is no longer configured
+		dest, err := localPath(&sharedConfig, t.Root, t.Path)
+		if err == nil {
+			err = os.Remove(stagingPath(dest, t.Uuid))
+			if err != nil && !os.IsNotExist(err) {
+				return i, err
+			}
+		}
+		err = store.DeleteTransfer(t.Uuid)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(stale), nil
+}
+
+// maxChunkSize is the largest file chunk carried by a FILE request, which takes at most the cost of a packet,
+// so that the file chunks buffered by the peer stay within the window of the stream
+const maxChunkSize = maxPacketCost
+
+// chunkSize Get the size of file chunk carried by a FILE request, at most maxChunkSize
+func chunkSize(protocolConfig config.SyncatProtocolConfig) int {
+	size := protocolConfig.BufferSize
+	if size <= 0 {
+		return 4096
+	}
+	if size > maxChunkSize {
+		return maxChunkSize
+	}
+	return size
+}
@@ -0,0 +1,153 @@
+package syncnet
+
+import (
+	"bytes"
+	"errors"
+	"github.com/JeffersonQin/syncat/pkg/config"
+	"github.com/JeffersonQin/syncat/pkg/database"
+	"github.com/JeffersonQin/syncat/pkg/hashing"
+	pb "github.com/JeffersonQin/syncat/pkg/proto"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPruneTransfers Stale transfers are deleted with their partial files, and recent ones are kept
+func TestPruneTransfers(t *testing.T) {
+	dir := t.TempDir()
+	sharedConfig := config.SyncatConfig{Sync: config.SyncatSyncConfig{Directories: []string{filepath.Join(dir, "sync")}}}
+	store, err := database.LoadDatabase(config.SyncatDBConfig{Filename: filepath.Join(dir, "client.db")},
+		database.ClientRole)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	dest, err := localPath(&sharedConfig, "sync", "sub/a.txt")
+	if err != nil {
+		t.Fatalf("failed to resolve path: %v", err)
+	}
+	staged := stagingPath(dest, "staged")
+	err = os.MkdirAll(filepath.Dir(staged), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	err = os.WriteFile(staged, []byte("partial"), 0666)
+	if err != nil {
+		t.Fatalf("failed to write partial file: %v", err)
+	}
+	err = store.CreateTransfer("staged", "sync", "sub/a.txt", "hash", string(hashing.SHA256), 100)
+	if err != nil {
+		t.Fatalf("failed to record transfer: %v", err)
+	}
+	// the sync root of this transfer is no longer configured
+	err = store.CreateTransfer("unknown", "removed", "b.txt", "hash", string(hashing.SHA256), 100)
+	if err != nil {
+		t.Fatalf("failed to record transfer: %v", err)
+	}
+
+	pruned, err := PruneTransfers(store, sharedConfig, time.Now().Add(-time.Hour))
+	if err != nil || pruned != 0 {
+		t.Fatalf("PruneTransfers of recent transfers = %d, %v, want 0", pruned, err)
+	}
+	if _, err := os.Stat(staged); err != nil {
+		t.Fatalf("partial file of a recent transfer is removed: %v", err)
+	}
+
+	pruned, err = PruneTransfers(store, sharedConfig, time.Now().Add(time.Second))
+	if err != nil || pruned != 2 {
+		t.Fatalf("PruneTransfers of stale transfers = %d, %v, want 2", pruned, err)
+	}
+	if _, err := os.Stat(staged); !os.IsNotExist(err) {
+		t.Fatalf("partial file of a stale transfer is kept: %v", err)
+	}
+	for _, id := range []string{"staged", "unknown"} {
+		_, exists, err := store.QueryTransferOffset(id)
+		if err != nil || exists {
+			t.Fatalf("transfer %s exists = %v, %v, want pruned", id, exists, err)
+		}
+	}
+}
+
+// TestPlaceStagedRestore The staged file and the file it replaces are put back when the entry fails to commit
+func TestPlaceStagedRestore(t *testing.T) {
+	for _, existing := range []bool{true, false} {
+		dir := t.TempDir()
+		dest := filepath.Join(dir, "a.txt")
+		staged, replaced := stagingPath(dest, "transfer"), replacedPath(dest, "transfer")
+		if existing {
+			err := os.WriteFile(dest, []byte("old"), 0666)
+			if err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+		}
+		err := os.WriteFile(staged, []byte("new"), 0666)
+		if err != nil {
+			t.Fatalf("failed to write staged file: %v", err)
+		}
+		restore, err := placeStaged(staged, dest, replaced)
+		if err != nil {
+			t.Fatalf("placeStaged with existing = %v: %v", existing, err)
+		}
+		if data, err := os.ReadFile(dest); err != nil || string(data) != "new" {
+			t.Fatalf("file in place = %q, %v, want the staged file", data, err)
+		}
+		restore()
+		if data, err := os.ReadFile(staged); err != nil || string(data) != "new" {
+			t.Fatalf("staged file after restore = %q, %v, want it back", data, err)
+		}
+		data, err := os.ReadFile(dest)
+		if existing && (err != nil || string(data) != "old") {
+			t.Fatalf("file after restore = %q, %v, want the replaced file back", data, err)
+		}
+		if !existing && !os.IsNotExist(err) {
+			t.Fatalf("file after restore = %q, %v, want none", data, err)
+		}
+		if _, err := os.Stat(replaced); !os.IsNotExist(err) {
+			t.Fatalf("replaced file is left aside: %v", err)
+		}
+	}
+}
+
+// TestFileRefusesTraversingTransferId A FILE request whose transfer id escapes the sync root is refused, and nothing is
+// written outside the sync root
+func TestFileRefusesTraversingTransferId(t *testing.T) {
+	dir := t.TempDir()
+	sideConfig := config.SyncatConfig{Sync: config.SyncatSyncConfig{Directories: []string{filepath.Join(dir, "sync")}}}
+	store, err := database.LoadDatabase(config.SyncatDBConfig{Filename: filepath.Join(dir, "syncat.db")},
+		database.ServerRole)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	hash, err := hashing.SHA256.Bytes([]byte("hello"))
+	if err != nil {
+		t.Fatalf("failed to hash: %v", err)
+	}
+	body := &pb.SyncatFileRequestBody{TransferId: "/../../../escaped", Root: "sync", Path: "docs/a.txt", Size: 5,
+		Hash: hash, HashAlgorithm: string(hashing.SHA256), Data: []byte("hello"), ChunkHash: hash}
+	conn := &IdleTimeoutConn{
+		Conn:        &bufferConn{r: bytes.NewReader(nil)},
+		IdleTimeout: time.Second,
+		Config:      config.NewHolder(sideConfig),
+	}
+	stream := newStream(conn, 1)
+	_, err = stageChunk(stream, store, body)
+	var malformed ErrMalformedPacket
+	if !errors.As(err, &malformed) {
+		t.Fatalf("err = %v, want ErrMalformedPacket", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to list directory: %v", err)
+	}
+	for _, e := range entries {
+		if e.Name() != "sync" && !bytes.HasPrefix([]byte(e.Name()), []byte("syncat.db")) {
+			t.Fatalf("%s is written outside the sync root", e.Name())
+		}
+	}
+}
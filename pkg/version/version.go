@@ -0,0 +1,5 @@
+package version
+
+// Version is the version of syncat, reported by clients at authentication
+// It is set at build time with -ldflags "-X github.com/JeffersonQin/syncat/pkg/version.Version=<version>"
+var Version = "dev"